@@ -0,0 +1,148 @@
+// Package bankfile renders merchant payouts into files that can be uploaded to a bank.
+package bankfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter renders a batch in one bank file format. Export validates the batch
+// against the format rules before writing anything.
+type Exporter interface {
+	Format() string
+	Extension() string
+	Export(w io.Writer, b *Batch) error
+}
+
+var (
+	mu        sync.RWMutex
+	exporters = map[string]Exporter{}
+)
+
+// Register makes an exporter available under its format name
+func Register(e Exporter) {
+	mu.Lock()
+	defer mu.Unlock()
+	exporters[e.Format()] = e
+}
+
+// Get returns the exporter registered for format
+func Get(format string) (Exporter, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	e, ok := exporters[format]
+	return e, ok
+}
+
+// Formats lists the registered format names
+func Formats() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]string, 0, len(exporters))
+	for f := range exporters {
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func init() {
+	Register(nachaExporter{})
+	Register(pain001Exporter{})
+}
+
+// Account holds the bank details of the paying company or a receiving merchant.
+// NACHA uses the routing and account numbers; pain.001 uses IBAN (or account number) and BIC.
+type Account struct {
+	Name          string `json:"name"`
+	RoutingNumber string `json:"routing_number"`
+	AccountNumber string `json:"account_number"`
+	AccountType   string `json:"account_type"` // checking (default) or savings
+	IBAN          string `json:"iban"`
+	BIC           string `json:"bic"`
+}
+
+// Originator is the company paying out, with its own bank account
+type Originator struct {
+	Account
+	CompanyID string `json:"company_id"`
+	BankName  string `json:"bank_name"`
+	Currency  string `json:"currency"`
+}
+
+// Config is the bank configuration file: the originator and the merchants' accounts by merchant id
+type Config struct {
+	Originator Originator         `json:"originator"`
+	Merchants  map[string]Account `json:"merchants"`
+}
+
+// LoadConfig reads a JSON bank configuration file
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if cfg.Originator.Currency == "" {
+		cfg.Originator.Currency = "USD"
+	}
+	return &cfg, nil
+}
+
+// Entry is one credit to a merchant
+type Entry struct {
+	MerchantID  string
+	Account     Account
+	AmountCents int64
+	Reference   string
+	PaymentID   string // identifies the credit across files, e.g. the payout id; pain.001 uses it as the end-to-end id
+}
+
+// Batch is the set of credits written into one file
+type Batch struct {
+	ID            string
+	Originator    Originator
+	CreatedAt     time.Time
+	EffectiveDate time.Time
+	Description   string
+	Entries       []Entry
+}
+
+// TotalCents sums the entry amounts
+func (b *Batch) TotalCents() int64 {
+	var total int64
+	for _, e := range b.Entries {
+		total += e.AmountCents
+	}
+	return total
+}
+
+// ValidationError lists every rule a batch breaks for a format
+type ValidationError struct {
+	Format   string
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Format, strings.Join(e.Problems, "; "))
+}
+
+type problems []string
+
+func (p *problems) addf(format string, args ...any) { *p = append(*p, fmt.Sprintf(format, args...)) }
+
+func (p problems) err(format string) error {
+	if len(p) == 0 {
+		return nil
+	}
+	return &ValidationError{Format: format, Problems: p}
+}
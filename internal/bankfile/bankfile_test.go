@@ -0,0 +1,158 @@
+package bankfile
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func testBatch() *Batch {
+	return &Batch{
+		ID: "job_20250201090000",
+		Originator: Originator{
+			Account: Account{
+				Name:          "Indico Payments",
+				RoutingNumber: "021000021",
+				AccountNumber: "123456789",
+				IBAN:          "DE89370400440532013000",
+				BIC:           "COBADEFFXXX",
+			},
+			CompanyID: "1234567890",
+			BankName:  "JPMorgan Chase",
+			Currency:  "USD",
+		},
+		CreatedAt:     time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC),
+		EffectiveDate: time.Date(2025, 2, 3, 0, 0, 0, 0, time.UTC),
+		Description:   "SETTLEMENT",
+		Entries: []Entry{
+			{
+				MerchantID:  "m-001",
+				Account:     Account{Name: "Merchant One", RoutingNumber: "011000015", AccountNumber: "987654321", IBAN: "GB82WEST12345698765432", BIC: "NWBKGB2L"},
+				AmountCents: 1234567,
+				Reference:   "Settlement 2025-01-01 to 2025-01-31",
+			},
+			{
+				MerchantID:  "m-002",
+				Account:     Account{Name: "Merchant Two", RoutingNumber: "122105278", AccountNumber: "0001112223", AccountType: "savings", BIC: "WFBIUS6SXXX"},
+				AmountCents: 50,
+				Reference:   "Settlement 2025-01-01 to 2025-01-31",
+			},
+		},
+	}
+}
+
+func TestExportersGolden(t *testing.T) {
+	for _, format := range []string{"nacha", "pain001"} {
+		t.Run(format, func(t *testing.T) {
+			e, ok := Get(format)
+			if !ok {
+				t.Fatalf("exporter %s not registered", format)
+			}
+			var buf bytes.Buffer
+			if err := e.Export(&buf, testBatch()); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", format+".golden")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("%s output differs from %s; run go test ./internal/bankfile -update to accept\n%s", format, golden, buf.String())
+			}
+		})
+	}
+}
+
+func TestNachaRecordStructure(t *testing.T) {
+	var buf bytes.Buffer
+	if err := (nachaExporter{}).Export(&buf, testBatch()); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines)%nachaBlockingFactor != 0 {
+		t.Fatalf("expected a multiple of %d records, got %d", nachaBlockingFactor, len(lines))
+	}
+	for i, l := range lines {
+		if len(l) != nachaRecordSize {
+			t.Fatalf("record %d has length %d", i+1, len(l))
+		}
+	}
+	if got := lines[len(lines)-1]; got != strings.Repeat("9", nachaRecordSize) {
+		t.Fatalf("expected padding record, got %q", got)
+	}
+}
+
+func TestExportersValidate(t *testing.T) {
+	b := testBatch()
+	b.Entries[0].Account.RoutingNumber = "011000016"
+	b.Entries[0].Account.IBAN = "GB82WEST12345698765433"
+	b.Entries[1].AmountCents = 0
+	for _, format := range Formats() {
+		e, _ := Get(format)
+		var buf bytes.Buffer
+		err := e.Export(&buf, b)
+		var verr *ValidationError
+		if !errors.As(err, &verr) {
+			t.Fatalf("%s: expected validation error, got %v", format, err)
+		}
+		if len(verr.Problems) < 2 {
+			t.Fatalf("%s: expected every problem to be reported, got %v", format, verr.Problems)
+		}
+		if buf.Len() != 0 {
+			t.Fatalf("%s: wrote output for an invalid batch", format)
+		}
+	}
+}
+
+func TestPain001RandomJobID(t *testing.T) {
+	b := testBatch()
+	b.ID = "job_20250201090000_0123456789abcdef" // shaped like services.NewJobID
+	b.Entries[0].PaymentID = "9223372036854775807"
+	b.Entries[1].PaymentID = "42"
+	e, _ := Get("pain001")
+	var buf bytes.Buffer
+	if err := e.Export(&buf, b); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"<MsgId>20250201090000-0123456789abcdef</MsgId>",
+		"<EndToEndId>9223372036854775807</EndToEndId>",
+		"<EndToEndId>42</EndToEndId>",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("missing %s in\n%s", want, out)
+		}
+	}
+
+	b.ID = strings.Repeat("x", 40)
+	if id := messageID(b.ID); len(id) == 0 || len(id) > 33 || id == messageID(strings.Repeat("y", 40)) {
+		t.Fatalf("long batch ids must hash to distinct ids of at most 33 characters, got %q", id)
+	}
+}
+
+func TestValidIBAN(t *testing.T) {
+	for iban, want := range map[string]bool{
+		"DE89370400440532013000":      true,
+		"GB82 WEST 1234 5698 7654 32": true,
+		"GB82WEST12345698765433":      false,
+		"XX":                          false,
+	} {
+		if got := validIBAN(iban); got != want {
+			t.Fatalf("validIBAN(%q) = %v, want %v", iban, got, want)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package bankfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const (
+	nachaRecordSize     = 94
+	nachaBlockingFactor = 10
+	nachaMaxAmount      = 9999999999 // ten digit amount field, in cents
+)
+
+// nachaExporter writes a NACHA ACH file with a single CCD credit batch
+type nachaExporter struct{}
+
+func (nachaExporter) Format() string    { return "nacha" }
+func (nachaExporter) Extension() string { return "ach" }
+
+func (x nachaExporter) Export(w io.Writer, b *Batch) error {
+	if err := x.validate(b); err != nil {
+		return err
+	}
+	o := b.Originator
+	odfi := o.RoutingNumber[:8]
+	var records []string
+
+	// File header
+	records = append(records, "1"+"01"+
+		" "+o.RoutingNumber+
+		fmt.Sprintf("%10s", strings.ToUpper(o.CompanyID))+
+		b.CreatedAt.Format("060102")+b.CreatedAt.Format("1504")+
+		"A"+"094"+"10"+"1"+
+		pad(o.BankName, 23)+
+		pad(o.Name, 23)+
+		pad("", 8))
+
+	// Batch header
+	records = append(records, "5"+"220"+
+		pad(o.Name, 16)+
+		pad("", 20)+
+		pad(o.CompanyID, 10)+
+		"CCD"+
+		pad(b.Description, 10)+
+		b.EffectiveDate.Format("060102")+
+		b.EffectiveDate.Format("060102")+
+		"   "+"1"+
+		odfi+
+		zeroPad(1, 7))
+
+	var hash, credit int64
+	for i, e := range b.Entries {
+		rdfi := e.Account.RoutingNumber
+		n, _ := strconv.ParseInt(rdfi[:8], 10, 64)
+		hash += n
+		credit += e.AmountCents
+		records = append(records, "6"+
+			nachaTransactionCode(e.Account.AccountType)+
+			rdfi+
+			pad(e.Account.AccountNumber, 17)+
+			zeroPad(e.AmountCents, 10)+
+			pad(e.MerchantID, 15)+
+			pad(e.Account.Name, 22)+
+			"  "+"0"+
+			odfi+zeroPad(int64(i+1), 7))
+	}
+	hashStr := zeroPad(hash%10000000000, 10)
+	count := int64(len(b.Entries))
+
+	// Batch control
+	records = append(records, "8"+"220"+
+		zeroPad(count, 6)+
+		hashStr+
+		zeroPad(0, 12)+
+		zeroPad(credit, 12)+
+		pad(o.CompanyID, 10)+
+		pad("", 19)+
+		pad("", 6)+
+		odfi+
+		zeroPad(1, 7))
+
+	// File control; the block count includes the padding records added below
+	blocks := (len(records) + 1 + nachaBlockingFactor - 1) / nachaBlockingFactor
+	records = append(records, "9"+
+		zeroPad(1, 6)+
+		zeroPad(int64(blocks), 6)+
+		zeroPad(count, 8)+
+		hashStr+
+		zeroPad(0, 12)+
+		zeroPad(credit, 12)+
+		pad("", 39))
+	for len(records)%nachaBlockingFactor != 0 {
+		records = append(records, strings.Repeat("9", nachaRecordSize))
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, r := range records {
+		if len(r) != nachaRecordSize {
+			return fmt.Errorf("nacha: internal error: record %q has length %d", r[:1], len(r))
+		}
+		if _, err := bw.WriteString(r + "\n"); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+func (x nachaExporter) validate(b *Batch) error {
+	var p problems
+	o := b.Originator
+	if !validABA(o.RoutingNumber) {
+		p.addf("originator routing number %q is not a valid ABA routing number", o.RoutingNumber)
+	}
+	if o.CompanyID == "" || len(o.CompanyID) > 10 || !nachaText(o.CompanyID) {
+		p.addf("originator company id %q must be 1-10 characters", o.CompanyID)
+	}
+	if o.Name == "" || !nachaText(o.Name) {
+		p.addf("originator name %q must be non-empty printable ASCII", o.Name)
+	}
+	if !nachaText(o.BankName) || !nachaText(b.Description) {
+		p.addf("bank name and description must be printable ASCII")
+	}
+	if len(b.Entries) == 0 {
+		p.addf("batch has no entries")
+	}
+	if len(b.Entries) > 9999999 {
+		p.addf("batch has %d entries, more than the trace number allows", len(b.Entries))
+	}
+	var total int64
+	for _, e := range b.Entries {
+		a := e.Account
+		if !validABA(a.RoutingNumber) {
+			p.addf("merchant %s: routing number %q is not a valid ABA routing number", e.MerchantID, a.RoutingNumber)
+		}
+		if a.AccountNumber == "" || len(a.AccountNumber) > 17 || !nachaText(a.AccountNumber) {
+			p.addf("merchant %s: account number must be 1-17 characters", e.MerchantID)
+		}
+		if a.AccountType != "" && a.AccountType != "checking" && a.AccountType != "savings" {
+			p.addf("merchant %s: account type %q must be checking or savings", e.MerchantID, a.AccountType)
+		}
+		if a.Name == "" || !nachaText(a.Name) {
+			p.addf("merchant %s: account name must be non-empty printable ASCII", e.MerchantID)
+		}
+		if len(e.MerchantID) > 15 {
+			p.addf("merchant %s: id longer than 15 characters", e.MerchantID)
+		}
+		if e.AmountCents <= 0 || e.AmountCents > nachaMaxAmount {
+			p.addf("merchant %s: amount %d cents out of range", e.MerchantID, e.AmountCents)
+		}
+		total += e.AmountCents
+	}
+	if total > 999999999999 {
+		p.addf("batch total %d cents exceeds the control record field", total)
+	}
+	return p.err(x.Format())
+}
+
+func nachaTransactionCode(accountType string) string {
+	if accountType == "savings" {
+		return "32"
+	}
+	return "22"
+}
+
+// validABA checks the 9 digit length and the 3-7-1 weighted checksum of a routing number
+func validABA(s string) bool {
+	if len(s) != 9 {
+		return false
+	}
+	weights := [3]int{3, 7, 1}
+	sum := 0
+	for i, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+		sum += int(c-'0') * weights[i%3]
+	}
+	return sum%10 == 0
+}
+
+// nachaText reports whether s only uses characters NACHA allows in alphanumeric fields
+func nachaText(s string) bool {
+	for _, c := range s {
+		if c < 0x20 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// pad upper-cases s, then left-justifies and space-pads or truncates it to n characters
+func pad(s string, n int) string {
+	s = strings.ToUpper(s)
+	if len(s) >= n {
+		return s[:n]
+	}
+	return s + strings.Repeat(" ", n-len(s))
+}
+
+// zeroPad right-justifies v in n digits
+func zeroPad(v int64, n int) string {
+	return fmt.Sprintf("%0*d", n, v)
+}
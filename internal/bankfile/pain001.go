@@ -0,0 +1,267 @@
+package bankfile
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math/big"
+	"regexp"
+	"strings"
+)
+
+const pain001Namespace = "urn:iso:std:iso:20022:tech:xsd:pain.001.001.03"
+
+var (
+	bicPattern      = regexp.MustCompile(`^[A-Z]{6}[A-Z0-9]{2}([A-Z0-9]{3})?$`)
+	currencyPattern = regexp.MustCompile(`^[A-Z]{3}$`)
+	// ISO 20022 restricted Latin character set used by most banks
+	latinPattern = regexp.MustCompile(`^[A-Za-z0-9/\-?:().,'+ ]*$`)
+	latinInvalid = regexp.MustCompile(`[^A-Za-z0-9/\-?:().,'+ ]`)
+)
+
+// pain001Exporter writes an ISO 20022 pain.001.001.03 customer credit transfer initiation
+type pain001Exporter struct{}
+
+func (pain001Exporter) Format() string    { return "pain001" }
+func (pain001Exporter) Extension() string { return "xml" }
+
+type painDocument struct {
+	XMLName xml.Name     `xml:"Document"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Initn   painTransfer `xml:"CstmrCdtTrfInitn"`
+}
+
+type painTransfer struct {
+	GrpHdr painGroupHeader `xml:"GrpHdr"`
+	PmtInf painPaymentInfo `xml:"PmtInf"`
+}
+
+type painGroupHeader struct {
+	MsgID    string    `xml:"MsgId"`
+	CreDtTm  string    `xml:"CreDtTm"`
+	NbOfTxs  int       `xml:"NbOfTxs"`
+	CtrlSum  string    `xml:"CtrlSum"`
+	InitgPty painParty `xml:"InitgPty"`
+}
+
+type painPaymentInfo struct {
+	PmtInfID    string            `xml:"PmtInfId"`
+	PmtMtd      string            `xml:"PmtMtd"`
+	NbOfTxs     int               `xml:"NbOfTxs"`
+	CtrlSum     string            `xml:"CtrlSum"`
+	ReqdExctnDt string            `xml:"ReqdExctnDt"`
+	Dbtr        painParty         `xml:"Dbtr"`
+	DbtrAcct    painAccount       `xml:"DbtrAcct"`
+	DbtrAgt     painAgent         `xml:"DbtrAgt"`
+	ChrgBr      string            `xml:"ChrgBr"`
+	Txs         []painTransaction `xml:"CdtTrfTxInf"`
+}
+
+type painTransaction struct {
+	PmtID    painPaymentID `xml:"PmtId"`
+	Amt      painAmount    `xml:"Amt"`
+	CdtrAgt  painAgent     `xml:"CdtrAgt"`
+	Cdtr     painParty     `xml:"Cdtr"`
+	CdtrAcct painAccount   `xml:"CdtrAcct"`
+	RmtInf   *painRemit    `xml:"RmtInf,omitempty"`
+}
+
+type painParty struct {
+	Nm string `xml:"Nm"`
+}
+
+type painAccount struct {
+	ID painAccountID `xml:"Id"`
+}
+
+type painAccountID struct {
+	IBAN string     `xml:"IBAN,omitempty"`
+	Othr *painOther `xml:"Othr,omitempty"`
+}
+
+type painOther struct {
+	ID string `xml:"Id"`
+}
+
+type painAgent struct {
+	FinInstnID struct {
+		BIC string `xml:"BIC"`
+	} `xml:"FinInstnId"`
+}
+
+type painPaymentID struct {
+	EndToEndID string `xml:"EndToEndId"`
+}
+
+type painAmount struct {
+	InstdAmt struct {
+		Ccy   string `xml:"Ccy,attr"`
+		Value string `xml:",chardata"`
+	} `xml:"InstdAmt"`
+}
+
+type painRemit struct {
+	Ustrd string `xml:"Ustrd"`
+}
+
+func (x pain001Exporter) Export(w io.Writer, b *Batch) error {
+	if err := x.validate(b); err != nil {
+		return err
+	}
+	o := b.Originator
+	msgID := messageID(b.ID)
+	total := formatCents(b.TotalCents())
+	doc := painDocument{Xmlns: pain001Namespace}
+	doc.Initn.GrpHdr = painGroupHeader{
+		MsgID:    msgID,
+		CreDtTm:  b.CreatedAt.UTC().Format("2006-01-02T15:04:05"),
+		NbOfTxs:  len(b.Entries),
+		CtrlSum:  total,
+		InitgPty: painParty{Nm: o.Name},
+	}
+	pi := painPaymentInfo{
+		PmtInfID:    msgID + "-1",
+		PmtMtd:      "TRF",
+		NbOfTxs:     len(b.Entries),
+		CtrlSum:     total,
+		ReqdExctnDt: b.EffectiveDate.Format("2006-01-02"),
+		Dbtr:        painParty{Nm: o.Name},
+		DbtrAcct:    painAccountFor(o.Account),
+		ChrgBr:      "SLEV",
+	}
+	pi.DbtrAgt.FinInstnID.BIC = o.BIC
+	for _, e := range b.Entries {
+		tx := painTransaction{
+			PmtID:    painPaymentID{EndToEndID: endToEndID(msgID, e)},
+			Cdtr:     painParty{Nm: e.Account.Name},
+			CdtrAcct: painAccountFor(e.Account),
+		}
+		tx.Amt.InstdAmt.Ccy = o.Currency
+		tx.Amt.InstdAmt.Value = formatCents(e.AmountCents)
+		tx.CdtrAgt.FinInstnID.BIC = e.Account.BIC
+		if e.Reference != "" {
+			tx.RmtInf = &painRemit{Ustrd: e.Reference}
+		}
+		pi.Txs = append(pi.Txs, tx)
+	}
+	doc.Initn.PmtInf = pi
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func (x pain001Exporter) validate(b *Batch) error {
+	var p problems
+	o := b.Originator
+	msgID := messageID(b.ID)
+	if msgID == "" || len(msgID) > 33 {
+		p.addf("message id %q must be 1-33 characters", b.ID)
+	}
+	if !currencyPattern.MatchString(o.Currency) {
+		p.addf("currency %q is not an ISO 4217 code", o.Currency)
+	}
+	validateParty(&p, "originator", o.Account)
+	if len(b.Entries) == 0 {
+		p.addf("batch has no entries")
+	}
+	for _, e := range b.Entries {
+		validateParty(&p, "merchant "+e.MerchantID, e.Account)
+		if e.AmountCents <= 0 {
+			p.addf("merchant %s: amount %d cents must be positive", e.MerchantID, e.AmountCents)
+		}
+		if len(endToEndID(msgID, e)) > 35 {
+			p.addf("merchant %s: end-to-end id longer than 35 characters", e.MerchantID)
+		}
+		if len(e.Reference) > 140 || !latinPattern.MatchString(e.Reference) {
+			p.addf("merchant %s: remittance information must be at most 140 Latin characters", e.MerchantID)
+		}
+	}
+	return p.err(x.Format())
+}
+
+func validateParty(p *problems, who string, a Account) {
+	if a.Name == "" || len(a.Name) > 70 || !latinPattern.MatchString(a.Name) {
+		p.addf("%s: name %q must be 1-70 characters of the Latin character set", who, a.Name)
+	}
+	if !bicPattern.MatchString(a.BIC) {
+		p.addf("%s: BIC %q is invalid", who, a.BIC)
+	}
+	switch {
+	case a.IBAN != "":
+		if !validIBAN(a.IBAN) {
+			p.addf("%s: IBAN %q fails the checksum", who, a.IBAN)
+		}
+	case a.AccountNumber == "" || len(a.AccountNumber) > 34:
+		p.addf("%s: an IBAN or an account number of at most 34 characters is required", who)
+	}
+}
+
+func painAccountFor(a Account) painAccount {
+	if a.IBAN != "" {
+		return painAccount{ID: painAccountID{IBAN: strings.ReplaceAll(a.IBAN, " ", "")}}
+	}
+	return painAccount{ID: painAccountID{Othr: &painOther{ID: a.AccountNumber}}}
+}
+
+// messageID is the MsgId for a batch id: the id itself when it fits in 33 characters, else the id
+// without its "job_" prefix (job ids are job_<timestamp>_<16 hex>, 35 characters), else a hash of it
+func messageID(batchID string) string {
+	id := painID(batchID)
+	if len(id) <= 33 {
+		return id
+	}
+	if short := strings.TrimPrefix(id, "job-"); len(short) <= 33 {
+		return short
+	}
+	sum := sha256.Sum256([]byte(batchID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// endToEndID identifies a credit: its payment id when it has one, else the message and merchant ids
+func endToEndID(msgID string, e Entry) string {
+	if e.PaymentID != "" {
+		return painID(e.PaymentID)
+	}
+	return painID(msgID + "-" + e.MerchantID)
+}
+
+// painID replaces characters outside the Latin character set in identifiers, e.g. the "_" of job ids
+func painID(s string) string {
+	return latinInvalid.ReplaceAllString(s, "-")
+}
+
+// validIBAN checks the structure and the ISO 7064 mod 97-10 checksum of an IBAN
+func validIBAN(iban string) bool {
+	s := strings.ToUpper(strings.ReplaceAll(iban, " ", ""))
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+	var digits strings.Builder
+	for _, c := range s[4:] + s[:4] {
+		switch {
+		case c >= '0' && c <= '9':
+			digits.WriteRune(c)
+		case c >= 'A' && c <= 'Z':
+			fmt.Fprintf(&digits, "%d", c-'A'+10)
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(digits.String(), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
+
+// formatCents renders cents as a decimal amount with two fraction digits
+func formatCents(cents int64) string {
+	return fmt.Sprintf("%d.%02d", cents/100, cents%100)
+}
@@ -0,0 +1,78 @@
+// Package downloads issues and checks expiring, HMAC-signed links to job artifacts.
+package downloads
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidLink = errors.New("INVALID_DOWNLOAD_LINK")
+	ErrLinkExpired = errors.New("DOWNLOAD_LINK_EXPIRED")
+)
+
+// Grant is what a valid link allows: one user to fetch one artifact of one job until Expires
+type Grant struct {
+	JobID   string
+	Key     string
+	User    string
+	Expires time.Time
+}
+
+// Signer issues links valid for ttl, signed with HMAC-SHA256 under a key shared by every replica
+type Signer struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+func NewSigner(key []byte, ttl time.Duration) *Signer {
+	return &Signer{key: key, ttl: ttl, now: time.Now}
+}
+
+// TTL is how long issued links stay valid
+func (s *Signer) TTL() time.Duration { return s.ttl }
+
+// mac signs the grant's fields, each length-prefixed so no two grants share an input
+func (s *Signer) mac(g Grant) []byte {
+	h := hmac.New(sha256.New, s.key)
+	for _, f := range []string{g.JobID, g.Key, g.User, strconv.FormatInt(g.Expires.Unix(), 10)} {
+		h.Write([]byte(strconv.Itoa(len(f)) + ":" + f))
+	}
+	return h.Sum(nil)
+}
+
+// URL returns a link to the artifact key of job jobID for user, valid for the signer's ttl
+func (s *Signer) URL(jobID, key, user string) string {
+	g := Grant{JobID: jobID, Key: key, User: user, Expires: s.now().Add(s.ttl).Truncate(time.Second)}
+	q := url.Values{}
+	q.Set("job", g.JobID)
+	q.Set("user", g.User)
+	q.Set("expires", strconv.FormatInt(g.Expires.Unix(), 10))
+	q.Set("signature", base64.RawURLEncoding.EncodeToString(s.mac(g)))
+	return "/downloads/" + url.PathEscape(key) + "?" + q.Encode()
+}
+
+// Verify checks the link's query for artifact key and returns what it grants
+func (s *Signer) Verify(key string, q url.Values) (Grant, error) {
+	g := Grant{JobID: q.Get("job"), Key: key, User: q.Get("user")}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil || g.JobID == "" || g.User == "" || !strings.HasPrefix(key, g.JobID) {
+		return g, ErrInvalidLink
+	}
+	g.Expires = time.Unix(expires, 0)
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get("signature"))
+	if err != nil || !hmac.Equal(sig, s.mac(g)) {
+		return g, ErrInvalidLink
+	}
+	if !s.now().Before(g.Expires) {
+		return g, ErrLinkExpired
+	}
+	return g, nil
+}
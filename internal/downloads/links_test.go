@@ -0,0 +1,55 @@
+package downloads
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignedLinks(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	s := NewSigner([]byte("secret"), 15*time.Minute)
+	s.now = func() time.Time { return now }
+
+	link := s.URL("job_1", "job_1.csv", "alice")
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(u.Path, "/downloads/job_1.csv") {
+		t.Fatalf("link = %s", link)
+	}
+	g, err := s.Verify("job_1.csv", u.Query())
+	if err != nil || g.User != "alice" || g.JobID != "job_1" || !g.Expires.Equal(now.Add(15*time.Minute)) {
+		t.Fatalf("Verify = %+v, %v", g, err)
+	}
+
+	tampered := func(name, value string) url.Values {
+		q := u.Query()
+		q.Set(name, value)
+		return q
+	}
+	for name, q := range map[string]url.Values{
+		"user":      tampered("user", "mallory"),
+		"expires":   tampered("expires", "99999999999"),
+		"job":       tampered("job", "job_2"),
+		"signature": tampered("signature", "AAAA"),
+	} {
+		if _, err := s.Verify("job_1.csv", q); !errors.Is(err, ErrInvalidLink) {
+			t.Errorf("tampered %s: err = %v", name, err)
+		}
+	}
+	if _, err := s.Verify("job_1.parquet", u.Query()); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("other artifact: err = %v", err)
+	}
+	if _, err := NewSigner([]byte("other"), time.Minute).Verify("job_1.csv", u.Query()); !errors.Is(err, ErrInvalidLink) {
+		t.Errorf("other key: err = %v", err)
+	}
+
+	now = now.Add(15 * time.Minute)
+	if _, err := s.Verify("job_1.csv", u.Query()); !errors.Is(err, ErrLinkExpired) {
+		t.Errorf("expired link: err = %v", err)
+	}
+}
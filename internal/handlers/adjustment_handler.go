@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/models"
+	"be/internal/models/response"
+	"be/internal/repositories"
+	"be/internal/services"
+)
+
+type AdjustmentHandler interface {
+	Propose(c *gin.Context)
+	List(c *gin.Context)
+	Get(c *gin.Context)
+	Approve(c *gin.Context)
+	Reject(c *gin.Context)
+	Audit(c *gin.Context)
+}
+
+type adjustmentHandler struct {
+	svc services.AdjustmentService
+}
+
+func NewAdjustmentHandler(svc services.AdjustmentService) AdjustmentHandler {
+	return &adjustmentHandler{svc: svc}
+}
+
+type proposeAdjustmentReq struct {
+	MerchantID    string `json:"merchant_id"`
+	AmountCents   int64  `json:"amount_cents"`
+	EffectiveDate string `json:"effective_date"`
+	Reason        string `json:"reason"`
+}
+
+type reviewAdjustmentReq struct {
+	Note string `json:"note"`
+}
+
+func (h *adjustmentHandler) Propose(c *gin.Context) {
+	user := requestUser(c)
+	if user == "" {
+		response.BadRequest(c, userHeader+" header required")
+		return
+	}
+	var req proposeAdjustmentReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	effective, err := time.Parse("2006-01-02", req.EffectiveDate)
+	if err != nil {
+		response.BadRequest(c, "invalid date format")
+		return
+	}
+	a, err := h.svc.Propose(c.Request.Context(), services.AdjustmentProposal{
+		MerchantID:    req.MerchantID,
+		AmountCents:   req.AmountCents,
+		EffectiveDate: effective,
+		Reason:        req.Reason,
+	}, user)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidAdjustment) {
+			response.BadRequest(c, err.Error())
+			return
+		}
+		response.Internal(c, err.Error())
+		return
+	}
+	response.Created(c, a)
+}
+
+func (h *adjustmentHandler) List(c *gin.Context) {
+	adjustments, err := h.svc.List(c.Request.Context(), c.Query("merchant_id"), c.Query("status"))
+	if err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, adjustments)
+}
+
+func (h *adjustmentHandler) Get(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.BadRequest(c, "invalid id")
+		return
+	}
+	a, err := h.svc.Get(c.Request.Context(), id)
+	if err != nil {
+		response.NotFound(c, "not found")
+		return
+	}
+	response.OK(c, a)
+}
+
+func (h *adjustmentHandler) Approve(c *gin.Context) {
+	h.review(c, h.svc.Approve)
+}
+
+func (h *adjustmentHandler) Reject(c *gin.Context) {
+	h.review(c, h.svc.Reject)
+}
+
+func (h *adjustmentHandler) review(c *gin.Context, review func(ctx context.Context, id int64, user, note string) (*models.Adjustment, error)) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.BadRequest(c, "invalid id")
+		return
+	}
+	user := requestUser(c)
+	if user == "" {
+		response.BadRequest(c, userHeader+" header required")
+		return
+	}
+	var req reviewAdjustmentReq
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	a, err := review(c.Request.Context(), id, user, req.Note)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			response.NotFound(c, "not found")
+		case errors.Is(err, repositories.ErrSelfReview):
+			response.Error(c, http.StatusForbidden, err.Error())
+		case errors.Is(err, repositories.ErrAdjustmentNotProposed):
+			response.Conflict(c, err.Error())
+		default:
+			response.Internal(c, err.Error())
+		}
+		return
+	}
+	response.OK(c, a)
+}
+
+func (h *adjustmentHandler) Audit(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.BadRequest(c, "invalid id")
+		return
+	}
+	entries, err := h.svc.Audit(c.Request.Context(), id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			response.NotFound(c, "not found")
+			return
+		}
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, entries)
+}
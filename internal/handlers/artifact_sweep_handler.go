@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/models/response"
+	"be/internal/repositories"
+	"be/internal/services"
+)
+
+type ArtifactSweepHandler interface {
+	StartSweep(c *gin.Context)
+}
+
+type artifactSweepHandler struct {
+	svc    services.ArtifactSweeper
+	access *JobAccess
+}
+
+func NewArtifactSweepHandler(svc services.ArtifactSweeper, access *JobAccess) ArtifactSweepHandler {
+	return &artifactSweepHandler{svc: svc, access: access}
+}
+
+type artifactSweepReq struct {
+	DryRun bool `json:"dry_run"`
+}
+
+// StartSweep queues an artifact sweep; it deletes other users' artifacts, so only job admins may start one
+func (h *artifactSweepHandler) StartSweep(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	if !h.access.Admin(user) {
+		response.Forbidden(c, "forbidden")
+		return
+	}
+	var req artifactSweepReq
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	jobID := newJobID()
+	if err := h.svc.StartSweep(c.Request.Context(), jobID, req.DryRun, user); err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.Created(c, gin.H{"job_id": jobID, "status": string(repositories.JobStatusQueued)})
+}
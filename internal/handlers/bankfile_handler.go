@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/bankfile"
+	"be/internal/models/response"
+	"be/internal/repositories"
+	"be/internal/services"
+)
+
+type BankFileHandler interface {
+	StartExport(c *gin.Context)
+	Formats(c *gin.Context)
+}
+
+type bankFileHandler struct {
+	svc services.BankFileService
+}
+
+func NewBankFileHandler(svc services.BankFileService) BankFileHandler {
+	return &bankFileHandler{svc: svc}
+}
+
+type bankFileReq struct {
+	Format        string   `json:"format" binding:"required"`
+	From          string   `json:"from"`
+	To            string   `json:"to"`
+	MerchantIDs   []string `json:"merchant_ids"`
+	EffectiveDate string   `json:"effective_date"`
+}
+
+func (h *bankFileHandler) StartExport(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	var req bankFileReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	from, err1 := time.Parse("2006-01-02", req.From)
+	to, err2 := time.Parse("2006-01-02", req.To)
+	if err1 != nil || err2 != nil {
+		response.BadRequest(c, "invalid date format")
+		return
+	}
+	jobID := newJobID()
+	err := h.svc.StartExport(c.Request.Context(), jobID, from, to, services.BankFileParams{
+		Format:        req.Format,
+		MerchantIDs:   req.MerchantIDs,
+		EffectiveDate: req.EffectiveDate,
+	}, user)
+	if err != nil {
+		switch err {
+		case services.ErrUnknownBankFormat:
+			response.BadRequest(c, "unknown format")
+		case services.ErrBankConfigMissing:
+			response.Internal(c, "bank configuration not loaded")
+		default:
+			response.BadRequest(c, err.Error())
+		}
+		return
+	}
+	response.Created(c, gin.H{"job_id": jobID, "status": string(repositories.JobStatusQueued)})
+}
+
+func (h *bankFileHandler) Formats(c *gin.Context) {
+	response.OK(c, gin.H{"formats": bankfile.Formats()})
+}
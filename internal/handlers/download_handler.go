@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/downloads"
+	"be/internal/models/response"
+	"be/internal/repositories"
+	"be/internal/storage"
+)
+
+// Audit actions of artifact downloads and result previews
+const (
+	auditDownload       = "DOWNLOAD"
+	auditDownloadDenied = "DOWNLOAD_DENIED"
+	auditPreview        = "PREVIEW"
+)
+
+type DownloadHandler interface {
+	Get(c *gin.Context)
+}
+
+type downloadHandler struct {
+	store storage.ArtifactStore
+	links *downloads.Signer
+	audit repositories.AuditRepository
+}
+
+// NewDownloadHandler serves job artifacts out of the artifact store, so any replica can serve any
+// job's files, but only through links signed by GET /jobs/:id that have not expired
+func NewDownloadHandler(store storage.ArtifactStore, links *downloads.Signer, audit repositories.AuditRepository) DownloadHandler {
+	return &downloadHandler{store: store, links: links, audit: audit}
+}
+
+// auditDenied records a refused download; a failure to record it is not reported to the caller
+func auditDenied(c *gin.Context, audit repositories.AuditRepository, jobID, user, artifact, reason string) {
+	_ = audit.Record(c.Request.Context(), repositories.AuditEntityJob, jobID, auditDownloadDenied, user, gin.H{
+		"artifact": artifact, "reason": reason, "client_ip": c.ClientIP(),
+	})
+}
+
+func (h *downloadHandler) Get(c *gin.Context) {
+	key := c.Param("name")
+	grant, err := h.links.Verify(key, c.Request.URL.Query())
+	if err != nil {
+		if grant.JobID != "" {
+			auditDenied(c, h.audit, grant.JobID, grant.User, key, err.Error())
+		}
+		if errors.Is(err, downloads.ErrLinkExpired) {
+			response.Forbidden(c, "download link expired")
+			return
+		}
+		response.Forbidden(c, "invalid download link")
+		return
+	}
+	rc, info, err := h.store.Open(c.Request.Context(), key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrInvalidKey) {
+			response.NotFound(c, "not found")
+			return
+		}
+		response.Internal(c, err.Error())
+		return
+	}
+	defer rc.Close()
+	// the download is only served once it is on the audit trail
+	if err := h.audit.Record(c.Request.Context(), repositories.AuditEntityJob, grant.JobID, auditDownload, grant.User, gin.H{
+		"artifact": key, "bytes": info.Size, "link_expires": grant.Expires.UTC(), "client_ip": c.ClientIP(),
+		"range": c.GetHeader("Range"),
+	}); err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Cache-Control", "private, no-store")
+	// a seekable artifact gets range and conditional requests; others are streamed as they are read
+	if rs, ok := rc.(io.ReadSeeker); ok {
+		http.ServeContent(c.Writer, c.Request, key, info.ModTime, rs)
+		return
+	}
+	if !info.ModTime.IsZero() {
+		c.Header("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if info.Size >= 0 {
+		c.Header("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	c.Status(http.StatusOK)
+	_, _ = io.Copy(c.Writer, rc)
+}
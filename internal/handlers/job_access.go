@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"strings"
+
+	"be/internal/repositories"
+)
+
+// JobAccess decides who may fetch a job's artifacts: the user who started it (X-User-ID) and the job admins
+type JobAccess struct{ admins map[string]bool }
+
+// NewJobAccess allows the listed users every job's artifacts
+func NewJobAccess(admins []string) *JobAccess {
+	a := &JobAccess{admins: map[string]bool{}}
+	for _, u := range admins {
+		if u = strings.TrimSpace(u); u != "" {
+			a.admins[u] = true
+		}
+	}
+	return a
+}
+
+// Allowed reports whether user may fetch the job's artifacts. Jobs started without a user, like
+// late-arrival corrections, are for admins only.
+func (a *JobAccess) Allowed(jr *repositories.JobRow, user string) bool {
+	if user == "" {
+		return false
+	}
+	return a.admins[user] || (jr.CreatedBy.Valid && jr.CreatedBy.String == user)
+}
+
+// Admin reports whether user is one of the job admins
+func (a *JobAccess) Admin(user string) bool {
+	return user != "" && a.admins[user]
+}
@@ -1,36 +1,61 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"be/internal/downloads"
 	"be/internal/models/response"
 	"be/internal/repositories"
 	"be/internal/services"
+	"be/internal/storage"
 )
 
 type JobHandler interface {
 	StartSettlement(c *gin.Context)
 	Get(c *gin.Context)
 	Cancel(c *gin.Context)
+	Artifact(c *gin.Context)
+	Verify(c *gin.Context)
+	Results(c *gin.Context)
 }
 
 type jobHandler struct {
-	jobs repositories.JobRepository
-	svc  services.JobService
+	jobs   repositories.JobRepository
+	svc    services.JobService
+	store  storage.ArtifactStore
+	links  *downloads.Signer
+	access *JobAccess
+	audit  repositories.AuditRepository
 }
 
-func NewJobHandler(j repositories.JobRepository, s services.JobService) JobHandler {
-	return &jobHandler{jobs: j, svc: s}
+func NewJobHandler(j repositories.JobRepository, s services.JobService, store storage.ArtifactStore, links *downloads.Signer, access *JobAccess, audit repositories.AuditRepository) JobHandler {
+	return &jobHandler{jobs: j, svc: s, store: store, links: links, access: access, audit: audit}
 }
 
 type settlementReq struct {
-	From string `json:"from"`
-	To   string `json:"to"`
+	From        string   `json:"from"`
+	To          string   `json:"to"`
+	Mode        string   `json:"mode"`
+	Aggregation string   `json:"aggregation"`
+	Format      string   `json:"format"`  // one result format
+	Formats     []string `json:"formats"` // or several, the first being the primary download
+	Archive     string   `json:"archive"` // "zip" or "tar.gz" to also split the result per merchant
 }
 
 func (h *jobHandler) StartSettlement(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
 	var req settlementReq
 	if err := c.ShouldBindJSON(&req); err != nil {
 		response.BadRequest(c, err.Error())
@@ -42,19 +67,64 @@ func (h *jobHandler) StartSettlement(c *gin.Context) {
 		response.BadRequest(c, "invalid date format")
 		return
 	}
-	jobID := "job_" + time.Now().Format("20060102150405")
-	if err := h.svc.StartSettlement(c.Request.Context(), jobID, from, to); err != nil {
+	jobID := newJobID()
+	if err := h.svc.StartSettlement(c.Request.Context(), jobID, from, to, services.SettlementParams{Mode: req.Mode, Aggregation: req.Aggregation, Formats: req.formats(), Archive: req.Archive}, user); err != nil {
+		if errors.Is(err, services.ErrInvalidSettlementMode) || errors.Is(err, services.ErrInvalidAggregation) || errors.Is(err, services.ErrInvalidFormat) ||
+			errors.Is(err, services.ErrInvalidArchive) {
+			response.BadRequest(c, err.Error())
+			return
+		}
 		response.Internal(c, err.Error())
 		return
 	}
 	response.Created(c, gin.H{"job_id": jobID, "status": string(repositories.JobStatusQueued)})
 }
 
-func (h *jobHandler) Get(c *gin.Context) {
-	id := c.Param("id")
-	jr, err := h.jobs.Get(c.Request.Context(), id)
+// formats merges format and formats, format first
+func (r settlementReq) formats() []string {
+	if r.Format == "" {
+		return r.Formats
+	}
+	return append([]string{r.Format}, r.Formats...)
+}
+
+// download describes one downloadable artifact of a job, with a link signed for user. Older jobs
+// stored a file path as their result; its base name is the artifact key.
+func (h *jobHandler) download(jobID, key, user string) gin.H {
+	name := path.Base(key)
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return gin.H{"url": h.links.URL(jobID, name, user), "content_type": contentType}
+}
+
+// newJobID returns a job id that cannot be guessed
+func newJobID() string {
+	return services.NewJobID()
+}
+
+// allowedJob loads the route's job for a caller allowed to see it. Otherwise it answers 404, or 403
+// and audits the refused action, and returns false.
+func (h *jobHandler) allowedJob(c *gin.Context, action string) (*repositories.JobRow, string, bool) {
+	jr, err := h.jobs.Get(c.Request.Context(), c.Param("id"))
 	if err != nil {
 		response.NotFound(c, "not found")
+		return nil, "", false
+	}
+	user := requestUser(c)
+	if !h.access.Allowed(jr, user) {
+		auditDenied(c, h.audit, jr.ID, user, action, "not allowed")
+		response.Forbidden(c, "forbidden")
+		return nil, "", false
+	}
+	return jr, user, true
+}
+
+// Get returns a job's status, with download links when it completed, to a caller allowed to see it
+func (h *jobHandler) Get(c *gin.Context) {
+	jr, user, ok := h.allowedJob(c, "status")
+	if !ok {
 		return
 	}
 	progress := int64(0)
@@ -68,18 +138,152 @@ func (h *jobHandler) Get(c *gin.Context) {
 		"total":     jr.Total,
 		"progress":  progress,
 	}
-	if jr.Status == repositories.JobStatusCompleted && jr.ResultPath.Valid {
-		// Expose a simple local download path via static /downloads
-		resp["download_url"] = "/downloads/" + id + ".csv"
+	if jr.SnapshotAt.Valid {
+		resp["snapshot_at"] = jr.SnapshotAt.Time
+		resp["snapshot"] = jr.Snapshot.String
+	}
+	if len(jr.Summary) > 0 {
+		resp["summary"] = json.RawMessage(jr.Summary)
+	}
+	if jr.ArtifactExpiredAt.Valid {
+		resp["artifact_expired"] = true
+		resp["artifact_expired_at"] = jr.ArtifactExpiredAt.Time
+	}
+	if jr.Status == repositories.JobStatusCompleted && jr.ResultPath.Valid && !jr.ArtifactExpiredAt.Valid {
+		// links are signed for this caller and expire; /downloads serves nothing else
+		download := func(key string) gin.H { return h.download(jr.ID, key, user) }
+		primary := download(jr.ResultPath.String)
+		resp["download_url"] = primary["url"]
+		resp["content_type"] = primary["content_type"]
+		var params services.SettlementParams
+		if jr.Type != repositories.JobTypeSettlement || jr.DecodeParams(&params) != nil {
+			params = services.SettlementParams{}
+		}
+		if len(params.Formats) > 1 {
+			downloads := make([]gin.H, 0, len(params.Formats))
+			for _, f := range params.Formats {
+				d := download(services.ResultKey(jr.ID, f))
+				d["format"] = f
+				downloads = append(downloads, d)
+			}
+			resp["downloads"] = downloads
+		}
+		if _, err := h.store.Stat(c.Request.Context(), services.ManifestKey(jr.ID)); err == nil {
+			resp["manifest_url"] = download(services.ManifestKey(jr.ID))["url"]
+		}
+		if params.Archive != "" {
+			archive := download(services.ArchiveKey(jr.ID, params.Archive))
+			archive["artifacts_url"] = "/jobs/" + jr.ID + "/artifacts/{merchant_id}"
+			resp["archive"] = archive
+		}
+		resp["links_expire_at"] = time.Now().Add(h.links.TTL()).UTC().Truncate(time.Second)
 	}
 	response.OK(c, resp)
 }
 
+// Cancel asks a job to stop, for a caller allowed to see it
 func (h *jobHandler) Cancel(c *gin.Context) {
-	id := c.Param("id")
-	if err := h.jobs.RequestCancel(c.Request.Context(), id); err != nil {
+	jr, _, ok := h.allowedJob(c, "cancel")
+	if !ok {
+		return
+	}
+	if err := h.jobs.RequestCancel(c.Request.Context(), jr.ID); err != nil {
 		response.NotFound(c, "not found")
 		return
 	}
-	response.OK(c, gin.H{"job_id": id, "status": "CANCEL_REQUESTED"})
+	response.OK(c, gin.H{"job_id": jr.ID, "status": "CANCEL_REQUESTED"})
+}
+
+// Artifact serves one merchant's file out of a settlement job's per-merchant archive to a caller
+// allowed to see the job; every download is audited
+func (h *jobHandler) Artifact(c *gin.Context) {
+	jr, user, ok := h.allowedJob(c, "merchant:"+c.Param("merchant_id"))
+	if !ok {
+		return
+	}
+	a, err := h.svc.MerchantArtifact(c.Request.Context(), jr.ID, c.Param("merchant_id"))
+	if err != nil {
+		if errors.Is(err, services.ErrArtifactNotFound) {
+			response.NotFound(c, "not found")
+			return
+		}
+		response.Internal(c, err.Error())
+		return
+	}
+	if err := h.audit.Record(c.Request.Context(), repositories.AuditEntityJob, jr.ID, auditDownload, user, gin.H{
+		"artifact": a.Name, "merchant_id": c.Param("merchant_id"), "bytes": len(a.Data), "client_ip": c.ClientIP(),
+	}); err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	c.Header("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+	c.Data(http.StatusOK, a.ContentType, a.Data)
+}
+
+// Verify checks a job's artifacts against its signed manifest: an uploaded multipart `file`, or
+// every stored artifact when none is sent, for a caller allowed to see the job
+func (h *jobHandler) Verify(c *gin.Context) {
+	jr, _, ok := h.allowedJob(c, "verify")
+	if !ok {
+		return
+	}
+	var name string
+	var body io.Reader
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+		defer f.Close()
+		name, body = fh.Filename, f
+	}
+	report, err := h.svc.VerifyJob(c.Request.Context(), jr.ID, name, body)
+	if err != nil {
+		if errors.Is(err, services.ErrManifestNotFound) {
+			response.NotFound(c, "manifest not found")
+			return
+		}
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, report)
+}
+
+// Results returns a page of a completed job's result rows, as JSON read from its artifact, with the
+// totals of every row matching merchant_id, to a caller allowed to see the job; every preview is audited
+func (h *jobHandler) Results(c *gin.Context) {
+	jr, user, ok := h.allowedJob(c, "results")
+	if !ok {
+		return
+	}
+	q := services.PreviewQuery{MerchantID: c.Query("merchant_id"), Cursor: c.Query("cursor")}
+	if v := c.Query("limit"); v != "" {
+		var err error
+		if q.Limit, err = strconv.Atoi(v); err != nil || q.Limit <= 0 {
+			response.BadRequest(c, "invalid limit")
+			return
+		}
+	}
+	p, err := h.svc.PreviewResults(c.Request.Context(), jr.ID, q)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrArtifactNotFound):
+			response.NotFound(c, "not found")
+		case errors.Is(err, services.ErrInvalidCursor), errors.Is(err, services.ErrPreviewUnsupported):
+			response.BadRequest(c, err.Error())
+		default:
+			response.Internal(c, err.Error())
+		}
+		return
+	}
+	if err := h.audit.Record(c.Request.Context(), repositories.AuditEntityJob, jr.ID, auditPreview, user, gin.H{
+		"artifact": p.Artifact, "merchant_id": q.MerchantID, "cursor": q.Cursor, "rows": len(p.Rows), "client_ip": c.ClientIP(),
+	}); err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	c.Header("Cache-Control", "private, no-store")
+	response.OK(c, p)
 }
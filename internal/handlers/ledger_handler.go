@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/models/response"
+	"be/internal/services"
+)
+
+type LedgerHandler interface {
+	MerchantBalance(c *gin.Context)
+	Check(c *gin.Context)
+	Sync(c *gin.Context)
+}
+
+type ledgerHandler struct {
+	svc services.LedgerService
+}
+
+func NewLedgerHandler(svc services.LedgerService) LedgerHandler {
+	return &ledgerHandler{svc: svc}
+}
+
+// MerchantBalance returns what is owed to a merchant, as of now or the RFC 3339 time in "at"
+func (h *ledgerHandler) MerchantBalance(c *gin.Context) {
+	at := time.Now().UTC()
+	if s := c.Query("at"); s != "" {
+		var err error
+		if at, err = time.Parse(time.RFC3339, s); err != nil {
+			response.BadRequest(c, "invalid at, want RFC 3339")
+			return
+		}
+	}
+	balance, err := h.svc.MerchantBalance(c.Request.Context(), c.Param("id"), at)
+	if err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, gin.H{"merchant_id": c.Param("id"), "at": at, "balance_cents": balance})
+}
+
+func (h *ledgerHandler) Check(c *gin.Context) {
+	from, err1 := time.Parse("2006-01-02", c.Query("from"))
+	to, err2 := time.Parse("2006-01-02", c.Query("to"))
+	if err1 != nil || err2 != nil {
+		response.BadRequest(c, "invalid date format")
+		return
+	}
+	check, err := h.svc.Check(c.Request.Context(), from, to)
+	if err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, check)
+}
+
+// Sync records new transactions in the ledger without waiting for the background sync
+func (h *ledgerHandler) Sync(c *gin.Context) {
+	n, err := h.svc.Sync(c.Request.Context())
+	if err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, gin.H{"posted": n})
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/models/response"
+	"be/internal/services"
+)
+
+type MerchantHandler interface {
+	Get(c *gin.Context)
+	Configure(c *gin.Context)
+	PayoutSchedule(c *gin.Context)
+	Reserves(c *gin.Context)
+}
+
+type merchantHandler struct {
+	svc services.MerchantService
+}
+
+func NewMerchantHandler(svc services.MerchantService) MerchantHandler {
+	return &merchantHandler{svc: svc}
+}
+
+type configureMerchantReq struct {
+	Timezone       string `json:"timezone"`
+	Cutoff         string `json:"cutoff"`
+	PayoutSchedule string `json:"payout_schedule"`
+	PayoutLagDays  *int   `json:"payout_lag_days"`
+	PayoutWeekday  *int   `json:"payout_weekday"`
+	ReserveBps     *int   `json:"reserve_bps"`
+	ReserveDays    *int   `json:"reserve_days"`
+}
+
+func (h *merchantHandler) Get(c *gin.Context) {
+	m, err := h.svc.Get(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		response.NotFound(c, "not found")
+		return
+	}
+	response.OK(c, m)
+}
+
+func (h *merchantHandler) Configure(c *gin.Context) {
+	var req configureMerchantReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	m, err := h.svc.Configure(c.Request.Context(), c.Param("id"), services.MerchantSettings{
+		Timezone:       req.Timezone,
+		Cutoff:         req.Cutoff,
+		PayoutSchedule: req.PayoutSchedule,
+		PayoutLagDays:  req.PayoutLagDays,
+		PayoutWeekday:  req.PayoutWeekday,
+		ReserveBps:     req.ReserveBps,
+		ReserveDays:    req.ReserveDays,
+	})
+	if err != nil {
+		switch err {
+		case services.ErrInvalidTimezone:
+			response.BadRequest(c, "invalid timezone")
+		case services.ErrInvalidCutoff:
+			response.BadRequest(c, "invalid cutoff")
+		case services.ErrInvalidSchedule:
+			response.BadRequest(c, "invalid payout schedule")
+		case services.ErrInvalidReserve:
+			response.BadRequest(c, "invalid reserve")
+		default:
+			response.Internal(c, err.Error())
+		}
+		return
+	}
+	response.OK(c, m)
+}
+
+func (h *merchantHandler) PayoutSchedule(c *gin.Context) {
+	from, err1 := time.Parse("2006-01-02", c.Query("from"))
+	to, err2 := time.Parse("2006-01-02", c.Query("to"))
+	if err1 != nil || err2 != nil {
+		response.BadRequest(c, "invalid date format")
+		return
+	}
+	periods, err := h.svc.PayoutSchedule(c.Request.Context(), c.Param("id"), from, to)
+	if err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, gin.H{"merchant_id": c.Param("id"), "periods": periods})
+}
+
+// Reserves returns the merchant's reserve balance as of today or the "as_of" date
+func (h *merchantHandler) Reserves(c *gin.Context) {
+	asOf := time.Now().UTC()
+	if s := c.Query("as_of"); s != "" {
+		var err error
+		if asOf, err = time.Parse("2006-01-02", s); err != nil {
+			response.BadRequest(c, "invalid date format")
+			return
+		}
+	}
+	balance, err := h.svc.Reserves(c.Request.Context(), c.Param("id"), asOf)
+	if err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, balance)
+}
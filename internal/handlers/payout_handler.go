@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/models/response"
+	"be/internal/repositories"
+	"be/internal/services"
+)
+
+type PayoutHandler interface {
+	StartPayout(c *gin.Context)
+	List(c *gin.Context)
+	Get(c *gin.Context)
+	MarkSent(c *gin.Context)
+	Confirm(c *gin.Context)
+	Fail(c *gin.Context)
+	UploadResults(c *gin.Context)
+}
+
+type payoutHandler struct {
+	svc    services.PayoutService
+	access *JobAccess
+}
+
+func NewPayoutHandler(svc services.PayoutService, access *JobAccess) PayoutHandler {
+	return &payoutHandler{svc: svc, access: access}
+}
+
+// admin returns the acting user when it is a job admin. Payout transitions move money: a FAILED payout
+// releases its rows to be paid again, so only admins may make them.
+func (h *payoutHandler) admin(c *gin.Context) (string, bool) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return "", false
+	}
+	if !h.access.Admin(user) {
+		response.Forbidden(c, "forbidden")
+		return "", false
+	}
+	return user, true
+}
+
+type payoutJobReq struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type payoutTransitionReq struct {
+	BankReference string `json:"bank_reference"`
+	FailureReason string `json:"failure_reason"`
+}
+
+func (h *payoutHandler) StartPayout(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	var req payoutJobReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	from, err1 := time.Parse("2006-01-02", req.From)
+	to, err2 := time.Parse("2006-01-02", req.To)
+	if err1 != nil || err2 != nil {
+		response.BadRequest(c, "invalid date format")
+		return
+	}
+	jobID := newJobID()
+	if err := h.svc.StartPayout(c.Request.Context(), jobID, from, to, user); err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.Created(c, gin.H{"job_id": jobID, "status": string(repositories.JobStatusQueued)})
+}
+
+func (h *payoutHandler) List(c *gin.Context) {
+	payouts, err := h.svc.List(c.Request.Context(), c.Query("merchant_id"), c.Query("status"))
+	if err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.OK(c, payouts)
+}
+
+func (h *payoutHandler) Get(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.BadRequest(c, "invalid id")
+		return
+	}
+	p, err := h.svc.Get(c.Request.Context(), id)
+	if err != nil {
+		response.NotFound(c, "not found")
+		return
+	}
+	response.OK(c, p)
+}
+
+func (h *payoutHandler) MarkSent(c *gin.Context) {
+	h.transition(c, repositories.PayoutStatusSent)
+}
+
+func (h *payoutHandler) Confirm(c *gin.Context) {
+	h.transition(c, repositories.PayoutStatusConfirmed)
+}
+
+func (h *payoutHandler) Fail(c *gin.Context) {
+	h.transition(c, repositories.PayoutStatusFailed)
+}
+
+func (h *payoutHandler) transition(c *gin.Context, status string) {
+	user, ok := h.admin(c)
+	if !ok {
+		return
+	}
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		response.BadRequest(c, "invalid id")
+		return
+	}
+	var req payoutTransitionReq
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	p, err := h.svc.Transition(c.Request.Context(), id, status, optional(req.BankReference), optional(req.FailureReason), user)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			response.NotFound(c, "not found")
+		case errors.Is(err, repositories.ErrInvalidPayoutTransition):
+			response.Conflict(c, "INVALID_PAYOUT_TRANSITION")
+		default:
+			response.Internal(c, err.Error())
+		}
+		return
+	}
+	response.OK(c, p)
+}
+
+// UploadResults accepts a bank result CSV either as a multipart "file" field or as the raw request body
+func (h *payoutHandler) UploadResults(c *gin.Context) {
+	user, ok := h.admin(c)
+	if !ok {
+		return
+	}
+	body := c.Request.Body
+	if fh, err := c.FormFile("file"); err == nil {
+		f, err := fh.Open()
+		if err != nil {
+			response.BadRequest(c, err.Error())
+			return
+		}
+		defer f.Close()
+		body = f
+	}
+	summary, err := h.svc.ApplyResults(c.Request.Context(), body, user)
+	if err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	response.OK(c, summary)
+}
+
+func optional(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
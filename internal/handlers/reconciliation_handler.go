@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/models/response"
+	"be/internal/repositories"
+	"be/internal/services"
+)
+
+type ReconciliationHandler interface {
+	StartReconciliation(c *gin.Context)
+}
+
+type reconciliationHandler struct {
+	svc services.ReconciliationService
+}
+
+func NewReconciliationHandler(svc services.ReconciliationService) ReconciliationHandler {
+	return &reconciliationHandler{svc: svc}
+}
+
+func (h *reconciliationHandler) StartReconciliation(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	var req settlementReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	from, err1 := time.Parse("2006-01-02", req.From)
+	to, err2 := time.Parse("2006-01-02", req.To)
+	if err1 != nil || err2 != nil {
+		response.BadRequest(c, "invalid date format")
+		return
+	}
+	jobID := newJobID()
+	if err := h.svc.StartReconciliation(c.Request.Context(), jobID, from, to, user); err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.Created(c, gin.H{"job_id": jobID, "status": string(repositories.JobStatusQueued)})
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"be/internal/models/response"
+	"be/internal/repositories"
+	"be/internal/services"
+)
+
+type StatementHandler interface {
+	StartStatement(c *gin.Context)
+}
+
+type statementHandler struct {
+	svc services.StatementService
+}
+
+func NewStatementHandler(svc services.StatementService) StatementHandler {
+	return &statementHandler{svc: svc}
+}
+
+type statementReq struct {
+	MerchantID string `json:"merchant_id" binding:"required"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+}
+
+func (h *statementHandler) StartStatement(c *gin.Context) {
+	user, ok := authenticatedUser(c)
+	if !ok {
+		return
+	}
+	var req statementReq
+	if err := c.ShouldBindJSON(&req); err != nil {
+		response.BadRequest(c, err.Error())
+		return
+	}
+	from, err1 := time.Parse("2006-01-02", req.From)
+	to, err2 := time.Parse("2006-01-02", req.To)
+	if err1 != nil || err2 != nil {
+		response.BadRequest(c, "invalid date format")
+		return
+	}
+	jobID := newJobID()
+	if err := h.svc.StartStatement(c.Request.Context(), jobID, from, to, services.StatementParams{MerchantID: req.MerchantID}, user); err != nil {
+		response.Internal(c, err.Error())
+		return
+	}
+	response.Created(c, gin.H{"job_id": jobID, "status": string(repositories.JobStatusQueued)})
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestUserAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	auth := NewUserAuth([]byte("0123456789abcdef0123456789abcdef"))
+	auth.now = func() time.Time { return now }
+	r := gin.New()
+	r.Use(auth.Middleware())
+	r.GET("/", func(c *gin.Context) { c.String(http.StatusOK, requestUser(c)) })
+
+	expires := now.Add(time.Minute)
+	for _, tc := range []struct {
+		name, user, expires, sig string
+		status                   int
+		body                     string
+	}{
+		{"anonymous", "", "", "", http.StatusOK, ""},
+		{"signed", "alice", strconv.FormatInt(expires.Unix(), 10), auth.Sign("alice", expires), http.StatusOK, "alice"},
+		{"unsigned", "alice", "", "", http.StatusUnauthorized, ""},
+		{"other user", "bob", strconv.FormatInt(expires.Unix(), 10), auth.Sign("alice", expires), http.StatusUnauthorized, ""},
+		{"moved expiry", "alice", strconv.FormatInt(expires.Unix()+3600, 10), auth.Sign("alice", expires), http.StatusUnauthorized, ""},
+		{"expired", "alice", strconv.FormatInt(now.Unix(), 10), auth.Sign("alice", now), http.StatusUnauthorized, ""},
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		for h, v := range map[string]string{userHeader: tc.user, userExpiresHeader: tc.expires, userSignatureHeader: tc.sig} {
+			if v != "" {
+				req.Header.Set(h, v)
+			}
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tc.status || (tc.status == http.StatusOK && w.Body.String() != tc.body) {
+			t.Errorf("%s: %d %q, want %d %q", tc.name, w.Code, w.Body.String(), tc.status, tc.body)
+		}
+	}
+}
+
+// TestStartersRequireUser checks that no job is created for an anonymous request
+func TestStartersRequireUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for name, start := range map[string]gin.HandlerFunc{
+		"settlement":     (&jobHandler{}).StartSettlement,
+		"payout":         (&payoutHandler{}).StartPayout,
+		"bank file":      (&bankFileHandler{}).StartExport,
+		"statement":      (&statementHandler{}).StartStatement,
+		"reconciliation": (&reconciliationHandler{}).StartReconciliation,
+		"artifact sweep": (&artifactSweepHandler{}).StartSweep,
+	} {
+		r := gin.New()
+		r.POST("/", start)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"from":"2025-01-01","to":"2025-01-31"}`)))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("%s: anonymous request got %d, want 401", name, w.Code)
+		}
+	}
+}
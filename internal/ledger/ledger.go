@@ -0,0 +1,170 @@
+// Package ledger records merchant money movements as balanced double-entry journal entries.
+//
+// Postings are signed: debits are positive and credits negative, so the postings of an entry
+// sum to zero. Merchant payable accounts are liabilities and carry a credit balance, which is
+// the amount owed to the merchant.
+package ledger
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// AccountType classifies an account
+type AccountType string
+
+const (
+	Asset     AccountType = "ASSET"
+	Liability AccountType = "LIABILITY"
+	Revenue   AccountType = "REVENUE"
+	Expense   AccountType = "EXPENSE"
+)
+
+// Platform accounts
+const (
+	AccountCash        = "cash"        // funds held for merchants
+	AccountFeeRevenue  = "fee_revenue" // processing fees earned
+	AccountAdjustments = "adjustments" // manual credits to merchants, net of debits
+)
+
+const merchantPayablePrefix = "merchant_payable:"
+
+// MerchantPayable is the account holding what is owed to a merchant
+func MerchantPayable(merchantID string) string { return merchantPayablePrefix + merchantID }
+
+// TypeOf returns the type of an account code
+func TypeOf(account string) AccountType {
+	switch {
+	case account == AccountCash:
+		return Asset
+	case account == AccountFeeRevenue:
+		return Revenue
+	case account == AccountAdjustments:
+		return Expense
+	case strings.HasPrefix(account, merchantPayablePrefix):
+		return Liability
+	}
+	return ""
+}
+
+// Entry kinds
+const (
+	KindCapture    = "CAPTURE"
+	KindRefund     = "REFUND"
+	KindAdjustment = "ADJUSTMENT"
+	KindPayout     = "PAYOUT"
+)
+
+// Source types; an entry is unique per source and kind, so posting it twice is a no-op
+const (
+	SourceTransaction = "transaction"
+	SourceAdjustment  = "adjustment"
+	SourcePayout      = "payout"
+)
+
+var (
+	ErrEmptyEntry     = errors.New("journal entry has no postings")
+	ErrZeroPosting    = errors.New("journal entry has a zero posting")
+	ErrUnknownAccount = errors.New("journal entry posts to an unknown account")
+)
+
+// Posting is one side of an entry: a positive amount debits the account, a negative one credits it
+type Posting struct {
+	Account     string
+	AmountCents int64
+}
+
+// Entry is one business event; ValueDate is the settlement date it belongs to
+type Entry struct {
+	Kind       string
+	SourceType string
+	SourceID   string
+	MerchantID string
+	ValueDate  time.Time
+	OccurredAt time.Time
+	Postings   []Posting
+}
+
+// Validate checks that the entry posts non-zero amounts to known accounts and balances
+func (e *Entry) Validate() error {
+	if len(e.Postings) == 0 {
+		return ErrEmptyEntry
+	}
+	var sum int64
+	for _, p := range e.Postings {
+		if p.AmountCents == 0 {
+			return ErrZeroPosting
+		}
+		if TypeOf(p.Account) == "" {
+			return fmt.Errorf("%w: %q", ErrUnknownAccount, p.Account)
+		}
+		sum += p.AmountCents
+	}
+	if sum != 0 {
+		return fmt.Errorf("journal entry %s %s/%s does not balance: off by %d", e.Kind, e.SourceType, e.SourceID, sum)
+	}
+	return nil
+}
+
+// postings drops zero amounts, e.g. a fee-free capture
+func postings(ps ...Posting) []Posting {
+	out := ps[:0]
+	for _, p := range ps {
+		if p.AmountCents != 0 {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// Capture records a paid transaction: the gross is received in cash, the fee is earned and the rest is owed to the merchant
+func Capture(merchantID, transactionID string, amountCents, feeCents int64, occurredAt, valueDate time.Time) Entry {
+	return Entry{
+		Kind: KindCapture, SourceType: SourceTransaction, SourceID: transactionID, MerchantID: merchantID,
+		ValueDate: valueDate, OccurredAt: occurredAt,
+		Postings: postings(
+			Posting{AccountCash, amountCents},
+			Posting{MerchantPayable(merchantID), -(amountCents - feeCents)},
+			Posting{AccountFeeRevenue, -feeCents},
+		),
+	}
+}
+
+// Refund reverses a captured transaction in full, including its fee, as settlements leave refunded transactions out
+func Refund(merchantID, transactionID string, amountCents, feeCents int64, occurredAt, valueDate time.Time) Entry {
+	return Entry{
+		Kind: KindRefund, SourceType: SourceTransaction, SourceID: transactionID, MerchantID: merchantID,
+		ValueDate: valueDate, OccurredAt: occurredAt,
+		Postings: postings(
+			Posting{MerchantPayable(merchantID), amountCents - feeCents},
+			Posting{AccountFeeRevenue, feeCents},
+			Posting{AccountCash, -amountCents},
+		),
+	}
+}
+
+// Adjustment records an approved manual credit (positive) or debit (negative) to the merchant
+func Adjustment(merchantID, adjustmentID string, amountCents int64, occurredAt, valueDate time.Time) Entry {
+	return Entry{
+		Kind: KindAdjustment, SourceType: SourceAdjustment, SourceID: adjustmentID, MerchantID: merchantID,
+		ValueDate: valueDate, OccurredAt: occurredAt,
+		Postings: postings(
+			Posting{AccountAdjustments, amountCents},
+			Posting{MerchantPayable(merchantID), -amountCents},
+		),
+	}
+}
+
+// Payout records money paid out to the merchant's bank account
+func Payout(merchantID, payoutID string, amountCents int64, occurredAt time.Time) Entry {
+	return Entry{
+		Kind: KindPayout, SourceType: SourcePayout, SourceID: payoutID, MerchantID: merchantID,
+		ValueDate: occurredAt.UTC(), OccurredAt: occurredAt,
+		Postings: postings(
+			Posting{MerchantPayable(merchantID), amountCents},
+			Posting{AccountCash, -amountCents},
+		),
+	}
+}
@@ -0,0 +1,60 @@
+package ledger
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestEntriesBalance(t *testing.T) {
+	at := time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)
+	day := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		Capture("m-001", "1", 1000, 30, at, day),
+		Capture("m-001", "2", 500, 0, at, day),
+		Refund("m-001", "1", 1000, 30, at, day),
+		Adjustment("m-001", "7", -250, at, day),
+		Payout("m-001", "3", 970, at),
+	}
+	for _, e := range entries {
+		if err := e.Validate(); err != nil {
+			t.Errorf("%s %s: %v", e.Kind, e.SourceID, err)
+		}
+	}
+	if n := len(entries[1].Postings); n != 2 {
+		t.Errorf("fee-free capture has %d postings, want 2", n)
+	}
+
+	// the merchant's payable balance is the sum of what each event owes them
+	var payable int64
+	for _, e := range entries {
+		for _, p := range e.Postings {
+			if p.Account == MerchantPayable("m-001") {
+				payable -= p.AmountCents
+			}
+		}
+	}
+	if want := int64(970 + 500 - 970 - 250 - 970); payable != want {
+		t.Errorf("payable balance = %d, want %d", payable, want)
+	}
+}
+
+func TestValidateRejects(t *testing.T) {
+	cases := map[string]struct {
+		entry Entry
+		want  error
+	}{
+		"empty":   {Entry{}, ErrEmptyEntry},
+		"zero":    {Entry{Postings: []Posting{{AccountCash, 0}}}, ErrZeroPosting},
+		"unknown": {Entry{Postings: []Posting{{"suspense", 10}, {AccountCash, -10}}}, ErrUnknownAccount},
+	}
+	for name, c := range cases {
+		if err := c.entry.Validate(); !errors.Is(err, c.want) {
+			t.Errorf("%s: err = %v, want %v", name, err, c.want)
+		}
+	}
+	unbalanced := Entry{Postings: []Posting{{AccountCash, 10}, {MerchantPayable("m-001"), -9}}}
+	if err := unbalanced.Validate(); err == nil {
+		t.Error("unbalanced entry validated")
+	}
+}
@@ -0,0 +1,233 @@
+// Package manifest describes a completed job's artifacts, with their checksums, and signs the
+// description with Ed25519 so downstream systems can check a file is complete and unchanged.
+package manifest
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Version is the manifest layout written by this package
+const Version = 1
+
+var (
+	ErrUnsigned         = errors.New("MANIFEST_UNSIGNED")
+	ErrBadSignature     = errors.New("MANIFEST_SIGNATURE_INVALID")
+	ErrKeyMismatch      = errors.New("MANIFEST_KEY_MISMATCH")
+	ErrNotInManifest    = errors.New("ARTIFACT_NOT_IN_MANIFEST")
+	ErrChecksumMismatch = errors.New("ARTIFACT_CHECKSUM_MISMATCH")
+)
+
+// Artifact is one file of a job, by base name
+type Artifact struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+}
+
+// Manifest describes a completed job. Rows and Totals are those of the job's result table, when it
+// has one: the number of data rows and the sum of each integer column.
+type Manifest struct {
+	Version   int              `json:"version"`
+	JobID     string           `json:"job_id"`
+	JobType   string           `json:"job_type"`
+	From      string           `json:"from,omitempty"`
+	To        string           `json:"to,omitempty"`
+	Params    json.RawMessage  `json:"params,omitempty"`
+	CreatedAt time.Time        `json:"created_at"`
+	Rows      *int64           `json:"rows,omitempty"`
+	Totals    map[string]int64 `json:"totals,omitempty"`
+	Artifacts []Artifact       `json:"artifacts"`
+	KeyID     string           `json:"key_id,omitempty"`
+	Signature string           `json:"signature,omitempty"`
+}
+
+// payload is what gets signed: the manifest's JSON without its signature. encoding/json writes
+// struct fields in order and map keys sorted, so the same manifest always gives the same bytes.
+func (m *Manifest) payload() ([]byte, error) {
+	c := *m
+	c.Signature = ""
+	return json.Marshal(&c)
+}
+
+// KeyID identifies a public key by the first 8 bytes of its SHA-256, hex encoded
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign records key's id and signs the manifest with it
+func (m *Manifest) Sign(key ed25519.PrivateKey) error {
+	m.KeyID = KeyID(key.Public().(ed25519.PublicKey))
+	payload, err := m.payload()
+	if err != nil {
+		return err
+	}
+	m.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload))
+	return nil
+}
+
+// Verify checks the manifest's signature against pub
+func (m *Manifest) Verify(pub ed25519.PublicKey) error {
+	if m.Signature == "" {
+		return ErrUnsigned
+	}
+	if m.KeyID != KeyID(pub) {
+		return ErrKeyMismatch
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return ErrBadSignature
+	}
+	payload, err := m.payload()
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, payload, sig) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// Artifact returns the manifest entry of the named file
+func (m *Manifest) Artifact(name string) (Artifact, bool) {
+	for _, a := range m.Artifacts {
+		if a.Name == name {
+			return a, true
+		}
+	}
+	return Artifact{}, false
+}
+
+// Check reads an artifact and compares its size and checksum with the manifest entry of name
+func (m *Manifest) Check(name string, r io.Reader) (Artifact, error) {
+	want, ok := m.Artifact(name)
+	if !ok {
+		return Artifact{}, ErrNotInManifest
+	}
+	got, err := Hash(name, r)
+	if err != nil {
+		return Artifact{}, err
+	}
+	if got != want {
+		return got, fmt.Errorf("%w: %s is %d bytes sha256 %s, manifest has %d bytes sha256 %s",
+			ErrChecksumMismatch, name, got.Bytes, got.SHA256, want.Bytes, want.SHA256)
+	}
+	return got, nil
+}
+
+// Hash returns the manifest entry of the content read from r
+func Hash(name string, r io.Reader) (Artifact, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return Artifact{}, err
+	}
+	return Artifact{Name: name, Bytes: n, SHA256: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+// HashFile returns the manifest entry of the file at path, named by its base name
+func HashFile(path string) (Artifact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Artifact{}, err
+	}
+	defer f.Close()
+	return Hash(filepath.Base(path), f)
+}
+
+// Encode returns the manifest as indented JSON
+func Encode(m *Manifest) ([]byte, error) {
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(raw, '\n'), nil
+}
+
+// Decode parses a manifest written by Encode
+func Decode(raw []byte) (*Manifest, error) {
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	if m.Version != Version {
+		return nil, fmt.Errorf("unsupported manifest version %d", m.Version)
+	}
+	return &m, nil
+}
+
+// Write stores the manifest at path
+func Write(path string, m *Manifest) error {
+	raw, err := Encode(m)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, raw, 0o644)
+}
+
+// Read loads a manifest file
+func Read(path string) (*Manifest, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(raw)
+}
+
+// LoadPrivateKey reads a PKCS#8 PEM Ed25519 private key, as written by `openssl genpkey -algorithm ed25519`
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	key, err := loadPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not an Ed25519 private key", path)
+	}
+	return priv, nil
+}
+
+// LoadPublicKey reads an Ed25519 public key from a PKIX PEM file, or derives it from a private key file
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	key, err := loadPEM(path)
+	if err != nil {
+		return nil, err
+	}
+	switch k := key.(type) {
+	case ed25519.PublicKey:
+		return k, nil
+	case ed25519.PrivateKey:
+		return k.Public().(ed25519.PublicKey), nil
+	}
+	return nil, fmt.Errorf("%s: not an Ed25519 key", path)
+}
+
+func loadPEM(path string) (any, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM block", path)
+	}
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	return nil, fmt.Errorf("%s: unexpected PEM block %q", path, block.Type)
+}
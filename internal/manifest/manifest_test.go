@@ -0,0 +1,87 @@
+package manifest
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeKey(t *testing.T, dir string, key ed25519.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestSignedManifestRoundTrip signs a manifest, reads it back from disk and checks that the
+// signature and artifact survive, and that tampering with either is caught.
+func TestSignedManifestRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	_, key, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err = LoadPrivateKey(writeKey(t, dir, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := key.Public().(ed25519.PublicKey)
+
+	artifact := filepath.Join(dir, "job_1.csv")
+	if err := os.WriteFile(artifact, []byte("merchant_id,net\nm-001,970\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	a, err := HashFile(artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := int64(1)
+	m := &Manifest{Version: Version, JobID: "job_1", JobType: "SETTLEMENT", Params: []byte(`{"mode": "full"}`),
+		CreatedAt: time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC), Rows: &rows, Totals: map[string]int64{"net": 970}, Artifacts: []Artifact{a}}
+	if err := m.Sign(key); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "job_1.manifest.json")
+	if err := Write(path, m); err != nil {
+		t.Fatal(err)
+	}
+	got, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := VerifyDir(got, pub, dir); !r.Valid || r.Signature != SignatureValid || len(r.Artifacts) != 1 {
+		t.Fatalf("report = %+v", r)
+	}
+
+	if _, err := got.Check("job_1.csv", strings.NewReader("merchant_id,net\nm-001,9700\n")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("changed artifact err = %v", err)
+	}
+	if _, err := got.Check("job_2.csv", bytes.NewReader(nil)); !errors.Is(err, ErrNotInManifest) {
+		t.Errorf("unknown artifact err = %v", err)
+	}
+	got.Totals["net"] = 9700
+	if err := got.Verify(pub); !errors.Is(err, ErrBadSignature) {
+		t.Errorf("changed manifest err = %v", err)
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := m.Verify(other); !errors.Is(err, ErrKeyMismatch) {
+		t.Errorf("other key err = %v", err)
+	}
+	m.Signature = ""
+	if r := m.NewReport(pub); r.Valid || r.Signature != SignatureUnsigned {
+		t.Errorf("unsigned report = %+v", r)
+	}
+}
@@ -0,0 +1,85 @@
+package manifest
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Signature states of a Report
+const (
+	SignatureValid     = "valid"
+	SignatureInvalid   = "invalid"
+	SignatureUnsigned  = "unsigned"
+	SignatureUnchecked = "unchecked" // no public key to check against
+)
+
+// Check is the outcome of checking one artifact
+type Check struct {
+	Name   string `json:"name"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256"`
+	OK     bool   `json:"ok"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Report is the outcome of verifying a manifest and artifacts against it. It is valid when every
+// artifact matches and the signature is valid, or could not be checked for want of a key.
+type Report struct {
+	JobID          string  `json:"job_id"`
+	KeyID          string  `json:"key_id,omitempty"`
+	Signature      string  `json:"signature"`
+	SignatureError string  `json:"signature_error,omitempty"`
+	Artifacts      []Check `json:"artifacts"`
+	Valid          bool    `json:"valid"`
+}
+
+// NewReport checks the manifest's signature with pub; a nil pub leaves it unchecked
+func (m *Manifest) NewReport(pub ed25519.PublicKey) *Report {
+	r := &Report{JobID: m.JobID, KeyID: m.KeyID, Artifacts: []Check{}, Valid: true}
+	if pub == nil {
+		r.Signature = SignatureUnchecked
+		return r
+	}
+	switch err := m.Verify(pub); {
+	case err == nil:
+		r.Signature = SignatureValid
+	case errors.Is(err, ErrUnsigned):
+		r.Signature, r.Valid = SignatureUnsigned, false
+	default:
+		r.Signature, r.SignatureError, r.Valid = SignatureInvalid, err.Error(), false
+	}
+	return r
+}
+
+// Add records the outcome of checking one artifact
+func (r *Report) Add(name string, got Artifact, err error) {
+	c := Check{Name: name, Bytes: got.Bytes, SHA256: got.SHA256, OK: err == nil}
+	if err != nil {
+		c.Error, r.Valid = err.Error(), false
+	}
+	r.Artifacts = append(r.Artifacts, c)
+}
+
+// CheckFile checks the file at path against the manifest entry of its base name
+func (r *Report) CheckFile(m *Manifest, path string) {
+	name := filepath.Base(path)
+	f, err := os.Open(path)
+	if err != nil {
+		r.Add(name, Artifact{}, err)
+		return
+	}
+	defer f.Close()
+	got, err := m.Check(name, f)
+	r.Add(name, got, err)
+}
+
+// VerifyDir checks the signature and every artifact of the manifest, read from dir
+func VerifyDir(m *Manifest, pub ed25519.PublicKey, dir string) *Report {
+	r := m.NewReport(pub)
+	for _, a := range m.Artifacts {
+		r.CheckFile(m, filepath.Join(dir, a.Name))
+	}
+	return r
+}
@@ -30,16 +30,114 @@ type Transaction struct {
 	PaidAt      time.Time `json:"paid_at"`
 }
 
+type Merchant struct {
+	ID             string    `json:"id"`
+	Timezone       string    `json:"timezone"`
+	Cutoff         *string   `json:"cutoff,omitempty"`
+	PayoutSchedule string    `json:"payout_schedule"`
+	PayoutLagDays  int       `json:"payout_lag_days"`
+	PayoutWeekday  *int      `json:"payout_weekday,omitempty"`
+	ReserveBps     int       `json:"reserve_bps"`
+	ReserveDays    int       `json:"reserve_days"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+// PayoutPeriod groups daily settlements that are paid out together
+type PayoutPeriod struct {
+	PeriodStart    string `json:"period_start"`
+	PeriodEnd      string `json:"period_end"`
+	PayoutDate     string `json:"payout_date"`
+	GrossCents     int64  `json:"gross_cents"`
+	FeeCents       int64  `json:"fee_cents"`
+	NetCents       int64  `json:"net_cents"`
+	PayableCents   int64  `json:"payable_cents"`
+	TxnCount       int64  `json:"txn_count"`
+	SettlementDays int    `json:"settlement_days"`
+}
+
 type Settlement struct {
-	ID          int64     `json:"id"`
-	MerchantID  string    `json:"merchant_id"`
-	Date        time.Time `json:"date"`
-	GrossCents  int64     `json:"gross_cents"`
-	FeeCents    int64     `json:"fee_cents"`
-	NetCents    int64     `json:"net_cents"`
-	TxnCount    int64     `json:"txn_count"`
-	GeneratedAt time.Time `json:"generated_at"`
-	UniqueRunID string    `json:"unique_run_id"`
+	ID              int64     `json:"id"`
+	MerchantID      string    `json:"merchant_id"`
+	Date            time.Time `json:"date"`
+	GrossCents      int64     `json:"gross_cents"`
+	FeeCents        int64     `json:"fee_cents"`
+	AdjustmentCents int64     `json:"adjustment_cents"`
+	NetCents        int64     `json:"net_cents"`
+	ReservedCents   int64     `json:"reserved_cents"`
+	ReleasedCents   int64     `json:"released_cents"`
+	PayableCents    int64     `json:"payable_cents"`
+	TxnCount        int64     `json:"txn_count"`
+	Cutoff          string    `json:"cutoff"`
+	GeneratedAt     time.Time `json:"generated_at"`
+	UniqueRunID     string    `json:"unique_run_id"`
+}
+
+type Payout struct {
+	ID              int64      `json:"id"`
+	MerchantID      string     `json:"merchant_id"`
+	JobID           string     `json:"job_id"`
+	AmountCents     int64      `json:"amount_cents"`
+	SettlementCount int        `json:"settlement_count"`
+	PeriodStart     time.Time  `json:"period_start"`
+	PeriodEnd       time.Time  `json:"period_end"`
+	Status          string     `json:"status"`
+	BankReference   *string    `json:"bank_reference,omitempty"`
+	FailureReason   *string    `json:"failure_reason,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	SentAt          *time.Time `json:"sent_at,omitempty"`
+	ConfirmedAt     *time.Time `json:"confirmed_at,omitempty"`
+	FailedAt        *time.Time `json:"failed_at,omitempty"`
+}
+
+type Adjustment struct {
+	ID              int64      `json:"id"`
+	MerchantID      string     `json:"merchant_id"`
+	AmountCents     int64      `json:"amount_cents"`
+	EffectiveDate   time.Time  `json:"effective_date"`
+	Reason          string     `json:"reason"`
+	Status          string     `json:"status"`
+	ProposedBy      string     `json:"proposed_by"`
+	ReviewedBy      *string    `json:"reviewed_by,omitempty"`
+	ReviewNote      *string    `json:"review_note,omitempty"`
+	ReviewedAt      *time.Time `json:"reviewed_at,omitempty"`
+	SettlementRunID *string    `json:"settlement_run_id,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type Reserve struct {
+	ID              int64      `json:"id"`
+	MerchantID      string     `json:"merchant_id"`
+	SettlementDate  time.Time  `json:"settlement_date"`
+	AmountCents     int64      `json:"amount_cents"`
+	ReleaseDate     time.Time  `json:"release_date"`
+	Status          string     `json:"status"`
+	SettlementRunID string     `json:"settlement_run_id"`
+	ReleaseRunID    *string    `json:"release_run_id,omitempty"`
+	ReleasedAt      *time.Time `json:"released_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// ReserveBalance summarises a merchant's reserves as of a date
+type ReserveBalance struct {
+	MerchantID    string    `json:"merchant_id"`
+	AsOf          string    `json:"as_of"`
+	HeldCents     int64     `json:"held_cents"`
+	DueCents      int64     `json:"due_cents"` // release date reached, waiting for the settlement run of that date
+	ReleasedCents int64     `json:"released_cents"`
+	Reserves      []Reserve `json:"reserves"`
+}
+
+type AuditEntry struct {
+	ID         int64     `json:"id"`
+	EntityType string    `json:"entity_type"`
+	EntityID   string    `json:"entity_id"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	Details    any       `json:"details,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
 }
 
 type Job struct {
@@ -28,7 +28,9 @@ func Error(c *gin.Context, status int, message string) {
 }
 
 // Convenience wrappers
-func BadRequest(c *gin.Context, message string) { Error(c, http.StatusBadRequest, message) }
-func NotFound(c *gin.Context, message string)   { Error(c, http.StatusNotFound, message) }
-func Conflict(c *gin.Context, message string)   { Error(c, http.StatusConflict, message) }
-func Internal(c *gin.Context, message string)   { Error(c, http.StatusInternalServerError, message) }
+func BadRequest(c *gin.Context, message string)   { Error(c, http.StatusBadRequest, message) }
+func Unauthorized(c *gin.Context, message string) { Error(c, http.StatusUnauthorized, message) }
+func Forbidden(c *gin.Context, message string)    { Error(c, http.StatusForbidden, message) }
+func NotFound(c *gin.Context, message string)     { Error(c, http.StatusNotFound, message) }
+func Conflict(c *gin.Context, message string)     { Error(c, http.StatusConflict, message) }
+func Internal(c *gin.Context, message string)     { Error(c, http.StatusInternalServerError, message) }
@@ -0,0 +1,205 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"be/internal/ledger"
+	"be/internal/models"
+)
+
+const (
+	AdjustmentStatusProposed = "PROPOSED"
+	AdjustmentStatusApproved = "APPROVED"
+	AdjustmentStatusRejected = "REJECTED"
+
+	AuditEntityAdjustment = "adjustment"
+)
+
+var (
+	ErrAdjustmentNotProposed = errors.New("ADJUSTMENT_NOT_PROPOSED")
+	ErrSelfReview            = errors.New("SELF_REVIEW_NOT_ALLOWED")
+)
+
+// ApprovedAdjustment is an approved adjustment as needed by settlement aggregation
+type ApprovedAdjustment struct {
+	ID            int64     `db:"id"`
+	MerchantID    string    `db:"merchant_id"`
+	EffectiveDate time.Time `db:"effective_date"`
+	AmountCents   int64     `db:"amount_cents"`
+}
+
+type AdjustmentRepository interface {
+	Create(ctx context.Context, a *models.Adjustment) (*models.Adjustment, error)
+	Review(ctx context.Context, id int64, status, reviewer, note string) (*models.Adjustment, error)
+	Get(ctx context.Context, id int64) (*models.Adjustment, error)
+	List(ctx context.Context, merchantID, status string) ([]models.Adjustment, error)
+	ApprovedInRange(ctx context.Context, from, to time.Time) ([]ApprovedAdjustment, error)
+}
+
+type adjustmentRepository struct{ db *sqlx.DB }
+
+func NewAdjustmentRepository(db *sqlx.DB) AdjustmentRepository { return &adjustmentRepository{db: db} }
+
+const adjustmentColumns = `id, merchant_id, amount_cents, effective_date, reason, status, proposed_by,
+        reviewed_by, review_note, reviewed_at, settlement_run_id, created_at, updated_at`
+
+func scanAdjustment(row rowScanner) (*models.Adjustment, error) {
+	var a models.Adjustment
+	var reviewedBy, note, runID sql.NullString
+	var reviewedAt sql.NullTime
+	if err := row.Scan(&a.ID, &a.MerchantID, &a.AmountCents, &a.EffectiveDate, &a.Reason, &a.Status, &a.ProposedBy,
+		&reviewedBy, &note, &reviewedAt, &runID, &a.CreatedAt, &a.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if reviewedBy.Valid {
+		a.ReviewedBy = &reviewedBy.String
+	}
+	if note.Valid {
+		a.ReviewNote = &note.String
+	}
+	if reviewedAt.Valid {
+		a.ReviewedAt = &reviewedAt.Time
+	}
+	if runID.Valid {
+		a.SettlementRunID = &runID.String
+	}
+	return &a, nil
+}
+
+// Create stores a PROPOSED adjustment and audits the proposal
+func (r *adjustmentRepository) Create(ctx context.Context, a *models.Adjustment) (*models.Adjustment, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	out, err := scanAdjustment(tx.QueryRowxContext(ctx, `INSERT INTO adjustments (merchant_id, amount_cents, effective_date, reason, status, proposed_by)
+        VALUES ($1,$2,$3,$4,$5,$6)
+        RETURNING `+adjustmentColumns, a.MerchantID, a.AmountCents, a.EffectiveDate, a.Reason, AdjustmentStatusProposed, a.ProposedBy))
+	if err != nil {
+		return nil, err
+	}
+	if err := writeAudit(ctx, tx, AuditEntityAdjustment, strconv.FormatInt(out.ID, 10), "PROPOSED", a.ProposedBy, map[string]any{
+		"merchant_id":    out.MerchantID,
+		"amount_cents":   out.AmountCents,
+		"effective_date": out.EffectiveDate.Format("2006-01-02"),
+		"reason":         out.Reason,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Review approves or rejects a PROPOSED adjustment. The reviewer must not be the proposer.
+// An approved adjustment is posted to the ledger in the same transaction.
+func (r *adjustmentRepository) Review(ctx context.Context, id int64, status, reviewer, note string) (*models.Adjustment, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var current, proposer string
+	if err := tx.QueryRowContext(ctx, `SELECT status, proposed_by FROM adjustments WHERE id = $1 FOR UPDATE`, id).Scan(&current, &proposer); err != nil {
+		return nil, err
+	}
+	if current != AdjustmentStatusProposed {
+		return nil, ErrAdjustmentNotProposed
+	}
+	if proposer == reviewer {
+		return nil, ErrSelfReview
+	}
+	out, err := scanAdjustment(tx.QueryRowxContext(ctx, `UPDATE adjustments SET
+           status = $2, reviewed_by = $3, review_note = NULLIF($4, ''), reviewed_at = now(), updated_at = now()
+        WHERE id = $1
+        RETURNING `+adjustmentColumns, id, status, reviewer, note))
+	if err != nil {
+		return nil, err
+	}
+	if status == AdjustmentStatusApproved {
+		entry := ledger.Adjustment(out.MerchantID, strconv.FormatInt(id, 10), out.AmountCents, *out.ReviewedAt, out.EffectiveDate)
+		if _, err := postEntries(ctx, tx, []ledger.Entry{entry}); err != nil {
+			return nil, err
+		}
+		// the day it settles on is recomputed by the next incremental run, or corrected when already settled
+		if _, err := tx.ExecContext(ctx, `INSERT INTO settlement_dirty (merchant_id, day) VALUES ($1, $2::date)
+            ON CONFLICT (merchant_id, day) DO UPDATE SET marked_at = clock_timestamp(), xid = pg_current_xact_id()`,
+			out.MerchantID, out.EffectiveDate.Format("2006-01-02")); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeAudit(ctx, tx, AuditEntityAdjustment, strconv.FormatInt(id, 10), status, reviewer, map[string]any{"note": note}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (r *adjustmentRepository) Get(ctx context.Context, id int64) (*models.Adjustment, error) {
+	return scanAdjustment(r.db.QueryRowxContext(ctx, `SELECT `+adjustmentColumns+` FROM adjustments WHERE id = $1`, id))
+}
+
+// List returns adjustments, newest first, optionally filtered by merchant and status
+func (r *adjustmentRepository) List(ctx context.Context, merchantID, status string) ([]models.Adjustment, error) {
+	rows, err := r.db.QueryxContext(ctx, `SELECT `+adjustmentColumns+` FROM adjustments
+        WHERE ($1 = '' OR merchant_id = $1) AND ($2 = '' OR status = $2)
+        ORDER BY id DESC
+        LIMIT 1000`, merchantID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.Adjustment{}
+	for rows.Next() {
+		a, err := scanAdjustment(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *a)
+	}
+	return out, rows.Err()
+}
+
+// ApprovedInRange returns approved adjustments effective in date range (inclusive)
+func (r *adjustmentRepository) ApprovedInRange(ctx context.Context, from, to time.Time) ([]ApprovedAdjustment, error) {
+	var out []ApprovedAdjustment
+	err := readerFor(ctx, r.db).SelectContext(ctx, &out, `SELECT id, merchant_id, effective_date, amount_cents FROM adjustments
+        WHERE status = $1 AND effective_date BETWEEN $2::date AND $3::date
+        ORDER BY id`, AdjustmentStatusApproved, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	return out, err
+}
+
+// markAdjustmentsSettled records the settlement run that included the adjustments and audits it. Only
+// adjustments on a merchant/day the run wrote are marked; one on a paid day stays unsettled.
+func markAdjustmentsSettled(ctx context.Context, tx *sqlx.Tx, ids []int64, runID string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	var settled []int64
+	if err := tx.SelectContext(ctx, &settled, `UPDATE adjustments SET settlement_run_id = $1, updated_at = now()
+        WHERE id = ANY($2) AND (merchant_id, effective_date) IN (SELECT merchant_id, date FROM settlement_written)
+        RETURNING id`, runID, pq.Array(ids)); err != nil {
+		return err
+	}
+	sort.Slice(settled, func(i, j int) bool { return settled[i] < settled[j] })
+	for _, id := range settled {
+		if err := writeAudit(ctx, tx, AuditEntityAdjustment, strconv.FormatInt(id, 10), "SETTLED", "system", map[string]any{"run_id": runID}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
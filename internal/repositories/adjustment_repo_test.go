@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"be/internal/models"
+)
+
+// TestAdjustmentMakerChecker checks that the proposer cannot approve their own adjustment,
+// that a second user can, that approval marks its day dirty and that every step lands in the audit log.
+func TestAdjustmentMakerChecker(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewAdjustmentRepository(db)
+	ctx := context.Background()
+	const merchant = "m-adjustment-test"
+
+	var id int64
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM audit_log WHERE entity_type = $1 AND entity_id = $2`, AuditEntityAdjustment, strconv.FormatInt(id, 10))
+		_, _ = db.Exec(`DELETE FROM adjustments WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM settlement_dirty WHERE merchant_id = $1`, merchant)
+	}
+	defer cleanup()
+
+	a, err := repo.Create(ctx, &models.Adjustment{
+		MerchantID:    merchant,
+		AmountCents:   -500,
+		EffectiveDate: time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC),
+		Reason:        "chargeback fee",
+		ProposedBy:    "alice",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id = a.ID
+	if a.Status != AdjustmentStatusProposed {
+		t.Fatalf("status = %s, want %s", a.Status, AdjustmentStatusProposed)
+	}
+
+	if _, err := repo.Review(ctx, id, AdjustmentStatusApproved, "alice", ""); !errors.Is(err, ErrSelfReview) {
+		t.Fatalf("self approval: err = %v, want %v", err, ErrSelfReview)
+	}
+	approved, err := repo.Review(ctx, id, AdjustmentStatusApproved, "bob", "ok")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if approved.Status != AdjustmentStatusApproved || approved.ReviewedBy == nil || *approved.ReviewedBy != "bob" {
+		t.Fatalf("unexpected adjustment: %+v", approved)
+	}
+	var dirty bool
+	if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM settlement_dirty WHERE merchant_id = $1 AND day = '2025-01-02')`, merchant).Scan(&dirty); err != nil {
+		t.Fatal(err)
+	}
+	if !dirty {
+		t.Fatal("approval did not mark the effective date dirty")
+	}
+	if _, err := repo.Review(ctx, id, AdjustmentStatusRejected, "carol", ""); !errors.Is(err, ErrAdjustmentNotProposed) {
+		t.Fatalf("second review: err = %v, want %v", err, ErrAdjustmentNotProposed)
+	}
+
+	entries, err := NewAuditRepository(db).List(ctx, AuditEntityAdjustment, strconv.FormatInt(id, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Action != "PROPOSED" || entries[1].Action != AdjustmentStatusApproved {
+		t.Fatalf("unexpected audit trail: %+v", entries)
+	}
+}
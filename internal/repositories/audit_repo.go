@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/jmoiron/sqlx"
+
+	"be/internal/models"
+)
+
+// AuditEntityJob is the audit entity of a job; its downloads are audited
+const AuditEntityJob = "job"
+
+type AuditRepository interface {
+	Record(ctx context.Context, entityType, entityID, action, actor string, details any) error
+	List(ctx context.Context, entityType, entityID string) ([]models.AuditEntry, error)
+}
+
+type auditRepository struct{ db *sqlx.DB }
+
+func NewAuditRepository(db *sqlx.DB) AuditRepository { return &auditRepository{db: db} }
+
+// writeAudit appends an audit entry; pass the transaction that makes the audited change
+// so the entry is committed together with it
+func writeAudit(ctx context.Context, ex sqlx.ExecerContext, entityType, entityID, action, actor string, details any) error {
+	raw, err := json.Marshal(details)
+	if err != nil {
+		return err
+	}
+	_, err = ex.ExecContext(ctx, `INSERT INTO audit_log (entity_type, entity_id, action, actor, details) VALUES ($1,$2,$3,$4,$5)`,
+		entityType, entityID, action, actor, raw)
+	return err
+}
+
+// Record appends an audit entry for an action that changes nothing else, like a download
+func (r *auditRepository) Record(ctx context.Context, entityType, entityID, action, actor string, details any) error {
+	return writeAudit(ctx, r.db, entityType, entityID, action, actor, details)
+}
+
+// List returns an entity's audit trail, oldest first
+func (r *auditRepository) List(ctx context.Context, entityType, entityID string) ([]models.AuditEntry, error) {
+	rows, err := r.db.QueryxContext(ctx, `SELECT id, entity_type, entity_id, action, actor, details, created_at
+        FROM audit_log WHERE entity_type = $1 AND entity_id = $2 ORDER BY id ASC`, entityType, entityID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.AuditEntry{}
+	for rows.Next() {
+		var e models.AuditEntry
+		var details []byte
+		if err := rows.Scan(&e.ID, &e.EntityType, &e.EntityID, &e.Action, &e.Actor, &details, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(details) > 0 {
+			_ = json.Unmarshal(details, &e.Details)
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
@@ -3,15 +3,26 @@ package repositories
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+// ErrJobNotRunning is returned when a job to complete is no longer running or was asked to cancel
+var ErrJobNotRunning = errors.New("JOB_NOT_RUNNING")
+
 type JobStatus string
 
 const (
-	JobTypeSettlement = "SETTLEMENT"
+	JobTypeSettlement     = "SETTLEMENT"
+	JobTypePayout         = "PAYOUT"
+	JobTypeBankFile       = "BANK_FILE"
+	JobTypeStatement      = "STATEMENT"
+	JobTypeReconciliation = "RECONCILIATION"
+	JobTypeArtifactSweep  = "ARTIFACT_SWEEP"
 
 	JobStatusQueued    JobStatus = "QUEUED"
 	JobStatusRunning   JobStatus = "RUNNING"
@@ -21,40 +32,69 @@ const (
 )
 
 type JobRow struct {
-	ID              string         `db:"id"`
-	Type            string         `db:"type"`
-	Status          JobStatus      `db:"status"`
-	CreatedAt       time.Time      `db:"created_at"`
-	UpdatedAt       time.Time      `db:"updated_at"`
-	StartedAt       sql.NullTime   `db:"started_at"`
-	CompletedAt     sql.NullTime   `db:"completed_at"`
-	CanceledAt      sql.NullTime   `db:"canceled_at"`
-	CancelRequested bool           `db:"cancel_requested"`
-	Total           int64          `db:"total"`
-	Processed       int64          `db:"processed"`
-	ResultPath      sql.NullString `db:"result_path"`
-	Error           sql.NullString `db:"error"`
-	FromDate        sql.NullTime   `db:"from_date"`
-	ToDate          sql.NullTime   `db:"to_date"`
+	ID                string         `db:"id"`
+	Type              string         `db:"type"`
+	Status            JobStatus      `db:"status"`
+	CreatedAt         time.Time      `db:"created_at"`
+	UpdatedAt         time.Time      `db:"updated_at"`
+	StartedAt         sql.NullTime   `db:"started_at"`
+	CompletedAt       sql.NullTime   `db:"completed_at"`
+	CanceledAt        sql.NullTime   `db:"canceled_at"`
+	CancelRequested   bool           `db:"cancel_requested"`
+	Total             int64          `db:"total"`
+	Processed         int64          `db:"processed"`
+	ResultPath        sql.NullString `db:"result_path"`
+	Error             sql.NullString `db:"error"`
+	FromDate          sql.NullTime   `db:"from_date"`
+	ToDate            sql.NullTime   `db:"to_date"`
+	Params            []byte         `db:"params"`
+	Summary           []byte         `db:"summary"`
+	SnapshotAt        sql.NullTime   `db:"snapshot_at"`
+	Snapshot          sql.NullString `db:"snapshot"`
+	CreatedBy         sql.NullString `db:"created_by"`
+	ArtifactExpiredAt sql.NullTime   `db:"artifact_expired_at"`
+}
+
+// DecodeParams unmarshals the job's JSON parameters into v; a job without parameters leaves v unchanged
+func (j *JobRow) DecodeParams(v any) error {
+	if len(j.Params) == 0 {
+		return nil
+	}
+	return json.Unmarshal(j.Params, v)
 }
 
 type JobRepository interface {
-	Create(ctx context.Context, id, typ string, total int64, from time.Time, to time.Time) error
+	Create(ctx context.Context, id, typ string, total int64, from time.Time, to time.Time, params any, createdBy string) error
 	SetRunning(ctx context.Context, id string) error
 	SetProgress(ctx context.Context, id string, processed int64) error
 	SetCompleted(ctx context.Context, id string, resultPath string) error
 	SetFailed(ctx context.Context, id string, msg string) error
+	SetSummary(ctx context.Context, id string, summary any) error
+	SetSnapshot(ctx context.Context, id string, at time.Time, snapshot string, total int64) error
 	RequestCancel(ctx context.Context, id string) error
+	SetArtifactExpired(ctx context.Context, id string) error
 	Get(ctx context.Context, id string) (*JobRow, error)
+	GetMany(ctx context.Context, ids []string) (map[string]*JobRow, error)
 	IsCancelRequested(ctx context.Context, id string) (bool, error)
+	HasActive(ctx context.Context, typ, mode string) (bool, error)
 }
 
 type jobRepository struct{ db *sqlx.DB }
 
 func NewJobRepository(db *sqlx.DB) *jobRepository { return &jobRepository{db: db} }
 
-func (r *jobRepository) Create(ctx context.Context, id, typ string, total int64, from time.Time, to time.Time) error {
-	_, err := r.db.ExecContext(ctx, `INSERT INTO jobs (id, type, status, total, from_date, to_date) VALUES ($1,$2,$3,$4,$5,$6)`, id, typ, string(JobStatusQueued), total, from, to)
+// Create inserts a QUEUED job; params is stored as JSON unless nil, and createdBy is the user who
+// started it, empty for system jobs
+func (r *jobRepository) Create(ctx context.Context, id, typ string, total int64, from time.Time, to time.Time, params any, createdBy string) error {
+	var raw []byte
+	if params != nil {
+		var err error
+		if raw, err = json.Marshal(params); err != nil {
+			return err
+		}
+	}
+	_, err := r.db.ExecContext(ctx, `INSERT INTO jobs (id, type, status, total, from_date, to_date, params, created_by) VALUES ($1,$2,$3,$4,$5,$6,$7,NULLIF($8,''))`,
+		id, typ, string(JobStatusQueued), total, from, to, raw, createdBy)
 	return err
 }
 
@@ -69,8 +109,24 @@ func (r *jobRepository) SetProgress(ctx context.Context, id string, processed in
 }
 
 func (r *jobRepository) SetCompleted(ctx context.Context, id string, resultPath string) error {
-	_, err := r.db.ExecContext(ctx, `UPDATE jobs SET status=$1, completed_at=now(), updated_at=now(), result_path=$2 WHERE id=$3`, string(JobStatusCompleted), resultPath, id)
-	return err
+	return setJobCompleted(ctx, r.db, id, resultPath)
+}
+
+// setJobCompleted marks a running job completed unless it was asked to cancel, and fails with
+// ErrJobNotRunning otherwise; it lets other repositories complete a job in their transaction, which
+// then rolls back what a canceled or failed job wrote
+func setJobCompleted(ctx context.Context, db sqlx.ExecerContext, id, resultPath string) error {
+	res, err := db.ExecContext(ctx, `UPDATE jobs SET status=$1, completed_at=now(), updated_at=now(), result_path=$2
+        WHERE id=$3 AND status=$4 AND NOT cancel_requested`, string(JobStatusCompleted), resultPath, id, string(JobStatusRunning))
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrJobNotRunning
+	}
+	return nil
 }
 
 func (r *jobRepository) SetFailed(ctx context.Context, id string, msg string) error {
@@ -78,13 +134,37 @@ func (r *jobRepository) SetFailed(ctx context.Context, id string, msg string) er
 	return err
 }
 
+// SetSnapshot records the snapshot a job reads in and its total counted in that snapshot
+func (r *jobRepository) SetSnapshot(ctx context.Context, id string, at time.Time, snapshot string, total int64) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE jobs SET snapshot_at=$1, snapshot=$2, total=$3, updated_at=now() WHERE id=$4`, at, snapshot, total, id)
+	return err
+}
+
+// SetSummary stores the job's result summary as JSON
+func (r *jobRepository) SetSummary(ctx context.Context, id string, summary any) error {
+	raw, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+	_, err = r.db.ExecContext(ctx, `UPDATE jobs SET summary=$1, updated_at=now() WHERE id=$2`, raw, id)
+	return err
+}
+
 func (r *jobRepository) RequestCancel(ctx context.Context, id string) error {
 	_, err := r.db.ExecContext(ctx, `UPDATE jobs SET cancel_requested=true, canceled_at=now(), updated_at=now() WHERE id=$1`, id)
 	return err
 }
 
+// SetArtifactExpired records that the job's artifacts were deleted after their retention
+func (r *jobRepository) SetArtifactExpired(ctx context.Context, id string) error {
+	_, err := r.db.ExecContext(ctx, `UPDATE jobs SET artifact_expired_at=now(), updated_at=now() WHERE id=$1`, id)
+	return err
+}
+
+const jobColumns = `id,type,status,created_at,updated_at,started_at,completed_at,canceled_at,cancel_requested,total,processed,result_path,error,from_date,to_date,params,summary,snapshot_at,snapshot,created_by,artifact_expired_at`
+
 func (r *jobRepository) Get(ctx context.Context, id string) (*JobRow, error) {
-	row := r.db.QueryRowxContext(ctx, `SELECT id,type,status,created_at,updated_at,started_at,completed_at,canceled_at,cancel_requested,total,processed,result_path,error,from_date,to_date FROM jobs WHERE id=$1`, id)
+	row := r.db.QueryRowxContext(ctx, `SELECT `+jobColumns+` FROM jobs WHERE id=$1`, id)
 	var jr JobRow
 	if err := row.StructScan(&jr); err != nil {
 		return nil, err
@@ -92,8 +172,30 @@ func (r *jobRepository) Get(ctx context.Context, id string) (*JobRow, error) {
 	return &jr, nil
 }
 
+// GetMany returns the jobs with the given ids by id; ids without a job are left out
+func (r *jobRepository) GetMany(ctx context.Context, ids []string) (map[string]*JobRow, error) {
+	var rows []JobRow
+	if err := r.db.SelectContext(ctx, &rows, `SELECT `+jobColumns+` FROM jobs WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	out := make(map[string]*JobRow, len(rows))
+	for i := range rows {
+		out[rows[i].ID] = &rows[i]
+	}
+	return out, nil
+}
+
 func (r *jobRepository) IsCancelRequested(ctx context.Context, id string) (bool, error) {
 	var flag bool
 	err := r.db.QueryRowContext(ctx, `SELECT cancel_requested FROM jobs WHERE id=$1`, id).Scan(&flag)
 	return flag, err
 }
+
+// HasActive reports whether a job of the type with params.mode equal to mode is queued or running
+func (r *jobRepository) HasActive(ctx context.Context, typ, mode string) (bool, error) {
+	var active bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM jobs
+        WHERE type = $1 AND status IN ($2, $3) AND params->>'mode' = $4)`,
+		typ, string(JobStatusQueued), string(JobStatusRunning), mode).Scan(&active)
+	return active, err
+}
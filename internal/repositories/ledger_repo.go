@@ -0,0 +1,212 @@
+package repositories
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"be/internal/ledger"
+)
+
+// LedgerTransactionRow is a transaction to record in the ledger, with the settlement date it belongs to
+type LedgerTransactionRow struct {
+	ID          int64     `db:"id"`
+	MerchantID  string    `db:"merchant_id"`
+	AmountCents int64     `db:"amount_cents"`
+	FeeCents    int64     `db:"fee_cents"`
+	Status      string    `db:"status"`
+	PaidAt      time.Time `db:"paid_at"`
+	ValueDate   time.Time `db:"value_date"`
+}
+
+// LedgerMismatch is a merchant/day where the ledger and the settlements table disagree on net
+type LedgerMismatch struct {
+	MerchantID         string    `db:"merchant_id" json:"merchant_id"`
+	Date               time.Time `db:"date" json:"date"`
+	LedgerNetCents     int64     `db:"ledger_net_cents" json:"ledger_net_cents"`
+	SettlementNetCents int64     `db:"settlement_net_cents" json:"settlement_net_cents"`
+}
+
+type LedgerRepository interface {
+	Post(ctx context.Context, entries ...ledger.Entry) (int, error)
+	Balance(ctx context.Context, account string, at time.Time) (int64, error)
+	CapturesToPost(ctx context.Context, limit int, cutoff time.Duration) ([]LedgerTransactionRow, error)
+	RefundsToPost(ctx context.Context, limit int, cutoff time.Duration) ([]LedgerTransactionRow, error)
+	Mismatches(ctx context.Context, from, to time.Time) ([]LedgerMismatch, error)
+}
+
+type ledgerRepository struct{ db *sqlx.DB }
+
+func NewLedgerRepository(db *sqlx.DB) LedgerRepository { return &ledgerRepository{db: db} }
+
+// postEntries writes journal entries and their postings in tx. Entries already recorded for
+// the same source and kind are skipped; it returns how many were new.
+func postEntries(ctx context.Context, tx *sqlx.Tx, entries []ledger.Entry) (int, error) {
+	if len(entries) == 0 {
+		return 0, nil
+	}
+	accounts := map[string]string{}
+	var kinds, sourceTypes, sourceIDs, merchants, valueDates, occurred []string
+	for i := range entries {
+		e := &entries[i]
+		if err := e.Validate(); err != nil {
+			return 0, err
+		}
+		for _, p := range e.Postings {
+			accounts[p.Account] = e.MerchantID
+		}
+		kinds = append(kinds, e.Kind)
+		sourceTypes = append(sourceTypes, e.SourceType)
+		sourceIDs = append(sourceIDs, e.SourceID)
+		merchants = append(merchants, e.MerchantID)
+		valueDates = append(valueDates, e.ValueDate.Format("2006-01-02"))
+		occurred = append(occurred, e.OccurredAt.Format(time.RFC3339Nano))
+	}
+
+	var codes, types, owners []string
+	for code, merchant := range accounts {
+		typ := ledger.TypeOf(code)
+		if typ != ledger.Liability {
+			merchant = ""
+		}
+		codes = append(codes, code)
+		types = append(types, string(typ))
+		owners = append(owners, merchant)
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO ledger_accounts (code, type, merchant_id)
+        SELECT code, type, NULLIF(merchant_id, '') FROM unnest($1::text[], $2::text[], $3::text[]) AS a(code, type, merchant_id)
+        ON CONFLICT (code) DO NOTHING`, pq.Array(codes), pq.Array(types), pq.Array(owners)); err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.QueryxContext(ctx, `INSERT INTO journal_entries (kind, source_type, source_id, merchant_id, value_date, occurred_at)
+        SELECT * FROM unnest($1::text[], $2::text[], $3::text[], $4::text[], $5::date[], $6::timestamptz[])
+        ON CONFLICT (source_type, source_id, kind) DO NOTHING
+        RETURNING id, kind, source_type, source_id`,
+		pq.Array(kinds), pq.Array(sourceTypes), pq.Array(sourceIDs), pq.Array(merchants), pq.Array(valueDates), pq.Array(occurred))
+	if err != nil {
+		return 0, err
+	}
+	type entryKey struct{ kind, sourceType, sourceID string }
+	ids := map[entryKey]int64{}
+	for rows.Next() {
+		var id int64
+		var k entryKey
+		if err := rows.Scan(&id, &k.kind, &k.sourceType, &k.sourceID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids[k] = id
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var entryIDs, amounts []int64
+	var postingAccounts []string
+	for _, e := range entries {
+		id, ok := ids[entryKey{e.Kind, e.SourceType, e.SourceID}]
+		if !ok {
+			continue
+		}
+		for _, p := range e.Postings {
+			entryIDs = append(entryIDs, id)
+			postingAccounts = append(postingAccounts, p.Account)
+			amounts = append(amounts, p.AmountCents)
+		}
+	}
+	if len(entryIDs) > 0 {
+		if _, err := tx.ExecContext(ctx, `INSERT INTO postings (entry_id, account, amount_cents)
+            SELECT * FROM unnest($1::bigint[], $2::text[], $3::bigint[])`,
+			pq.Array(entryIDs), pq.Array(postingAccounts), pq.Array(amounts)); err != nil {
+			return 0, err
+		}
+	}
+	return len(ids), nil
+}
+
+// Post records journal entries in one transaction; it returns how many were new
+func (r *ledgerRepository) Post(ctx context.Context, entries ...ledger.Entry) (int, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	n, err := postEntries(ctx, tx, entries)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// Balance returns the debit balance of an account from entries that occurred up to at;
+// liabilities such as merchant payables have a negative (credit) balance
+func (r *ledgerRepository) Balance(ctx context.Context, account string, at time.Time) (int64, error) {
+	var balance int64
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(p.amount_cents), 0)
+        FROM postings p JOIN journal_entries j ON j.id = p.entry_id
+        WHERE p.account = $1 AND j.occurred_at <= $2`, account, at).Scan(&balance)
+	return balance, err
+}
+
+// ledgerTransactionColumns selects LedgerTransactionRow columns from transactions t joined with merchants m;
+// the value date follows the settlement job's timezone and cut-off rules ($1 for merchants without a cut-off)
+const ledgerTransactionColumns = `t.id, t.merchant_id, t.amount_cents, t.fee_cents, t.status, t.paid_at,
+        settlement_date(t.paid_at, COALESCE(m.timezone, 'UTC'), COALESCE(m.cutoff, $1::time)) AS value_date`
+
+// CapturesToPost returns paid or refunded transactions whose capture is not in the ledger yet, in id order.
+// It checks the journal rather than following an id cursor, so transactions that commit late with a
+// lower id, or become paid after others were posted, are still picked up.
+func (r *ledgerRepository) CapturesToPost(ctx context.Context, limit int, cutoff time.Duration) ([]LedgerTransactionRow, error) {
+	return r.unposted(ctx, `t.status IN ('PAID', 'REFUNDED')`, ledger.KindCapture, limit, cutoff)
+}
+
+// RefundsToPost returns refunded transactions whose refund is not in the ledger yet, in id order
+func (r *ledgerRepository) RefundsToPost(ctx context.Context, limit int, cutoff time.Duration) ([]LedgerTransactionRow, error) {
+	return r.unposted(ctx, `t.status = 'REFUNDED'`, ledger.KindRefund, limit, cutoff)
+}
+
+// unposted returns transactions matching where that have no journal entry of kind. Transactions
+// without an amount or a fee would post an empty entry, so they are never returned.
+func (r *ledgerRepository) unposted(ctx context.Context, where, kind string, limit int, cutoff time.Duration) ([]LedgerTransactionRow, error) {
+	var out []LedgerTransactionRow
+	err := r.db.SelectContext(ctx, &out, `SELECT `+ledgerTransactionColumns+`
+        FROM transactions t
+        LEFT JOIN merchants m ON m.id = t.merchant_id
+        WHERE `+where+`
+          AND (t.amount_cents <> 0 OR t.fee_cents <> 0)
+          AND NOT EXISTS (SELECT 1 FROM journal_entries j
+                          WHERE j.source_type = $2 AND j.source_id = t.id::text AND j.kind = $3)
+        ORDER BY t.id ASC
+        LIMIT $4`, FormatCutoff(cutoff), ledger.SourceTransaction, kind, limit)
+	return out, err
+}
+
+// Mismatches compares, per merchant and settlement date in range (inclusive), what the ledger
+// owes the merchant from captures, refunds and adjustments with the settlements table's net
+func (r *ledgerRepository) Mismatches(ctx context.Context, from, to time.Time) ([]LedgerMismatch, error) {
+	var out []LedgerMismatch
+	err := r.db.SelectContext(ctx, &out, fmt.Sprintf(`WITH ledger AS (
+            SELECT j.merchant_id, j.value_date AS date, -SUM(p.amount_cents) AS net_cents
+            FROM journal_entries j
+            JOIN postings p ON p.entry_id = j.id AND p.account = '%s' || j.merchant_id
+            WHERE j.kind IN ('CAPTURE', 'REFUND', 'ADJUSTMENT') AND j.value_date BETWEEN $1::date AND $2::date
+            GROUP BY j.merchant_id, j.value_date
+        ), settled AS (
+            SELECT merchant_id, date, net_cents FROM settlements WHERE date BETWEEN $1::date AND $2::date
+        )
+        SELECT COALESCE(l.merchant_id, s.merchant_id) AS merchant_id,
+               COALESCE(l.date, s.date) AS date,
+               COALESCE(l.net_cents, 0) AS ledger_net_cents,
+               COALESCE(s.net_cents, 0) AS settlement_net_cents
+        FROM ledger l
+        FULL OUTER JOIN settled s ON s.merchant_id = l.merchant_id AND s.date = l.date
+        WHERE COALESCE(l.net_cents, 0) <> COALESCE(s.net_cents, 0)
+        ORDER BY 1, 2
+        LIMIT 1000`, ledger.MerchantPayable("")), from.Format("2006-01-02"), to.Format("2006-01-02"))
+	return out, err
+}
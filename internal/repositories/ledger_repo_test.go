@@ -0,0 +1,116 @@
+package repositories
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"be/internal/ledger"
+)
+
+// TestTransactionsToPostFollowJournal checks that captures and refunds are picked up from what the
+// journal is missing, whatever the order transactions were written or paid in.
+func TestTransactionsToPostFollowJournal(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewLedgerRepository(db)
+	ctx := context.Background()
+	const merchant = "m-ledger-test"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM postings WHERE entry_id IN (SELECT id FROM journal_entries WHERE merchant_id = $1)`, merchant)
+		_, _ = db.Exec(`DELETE FROM journal_entries WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM transactions WHERE merchant_id = $1`, merchant)
+	}
+	cleanup()
+	defer cleanup()
+
+	var pendingID, paidID int64
+	if err := db.QueryRow(`INSERT INTO transactions (merchant_id, amount_cents, fee_cents, status, paid_at)
+        VALUES ($1, 1000, 30, 'PENDING', '2025-01-01T10:00:00Z') RETURNING id`, merchant).Scan(&pendingID); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`INSERT INTO transactions (merchant_id, amount_cents, fee_cents, status, paid_at)
+        VALUES ($1, 2000, 60, 'PAID', '2025-01-01T11:00:00Z') RETURNING id`, merchant).Scan(&paidID); err != nil {
+		t.Fatal(err)
+	}
+	// a zero-amount payment has nothing to post and must not hold up the rest
+	if _, err := db.Exec(`INSERT INTO transactions (merchant_id, amount_cents, fee_cents, status, paid_at)
+        VALUES ($1, 0, 0, 'PAID', '2025-01-01T09:00:00Z')`, merchant); err != nil {
+		t.Fatal(err)
+	}
+
+	toPost := func(rows []LedgerTransactionRow) []int64 {
+		var ids []int64
+		for _, r := range rows {
+			if r.MerchantID == merchant {
+				ids = append(ids, r.ID)
+			}
+		}
+		return ids
+	}
+	post := func(rows []LedgerTransactionRow, refund bool) {
+		for _, r := range rows {
+			if r.MerchantID != merchant {
+				continue
+			}
+			id := strconv.FormatInt(r.ID, 10)
+			e := ledger.Capture(r.MerchantID, id, r.AmountCents, r.FeeCents, r.PaidAt, r.ValueDate)
+			if refund {
+				e = ledger.Refund(r.MerchantID, id, r.AmountCents, r.FeeCents, r.PaidAt, r.ValueDate)
+			}
+			if _, err := repo.Post(ctx, e); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	rows, err := repo.CapturesToPost(ctx, 100000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ids := toPost(rows); len(ids) != 1 || ids[0] != paidID {
+		t.Fatalf("captures to post = %v, want [%d]", ids, paidID)
+	}
+	post(rows, false)
+
+	// the lower id is paid after the higher one was posted, then the higher one is refunded
+	if _, err := db.Exec(`UPDATE transactions SET status = 'PAID' WHERE id = $1`, pendingID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`UPDATE transactions SET status = 'REFUNDED' WHERE id = $1`, paidID); err != nil {
+		t.Fatal(err)
+	}
+	if rows, err = repo.CapturesToPost(ctx, 100000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ids := toPost(rows); len(ids) != 1 || ids[0] != pendingID {
+		t.Fatalf("captures to post = %v, want [%d]", ids, pendingID)
+	}
+	post(rows, false)
+	if rows, err = repo.RefundsToPost(ctx, 100000, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ids := toPost(rows); len(ids) != 1 || ids[0] != paidID {
+		t.Fatalf("refunds to post = %v, want [%d]", ids, paidID)
+	}
+	post(rows, true)
+
+	if rows, err = repo.CapturesToPost(ctx, 100000, 0); err != nil {
+		t.Fatal(err)
+	}
+	refunds, err := repo.RefundsToPost(ctx, 100000, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(toPost(rows)) != 0 || len(toPost(refunds)) != 0 {
+		t.Fatalf("left to post: captures %v, refunds %v", toPost(rows), toPost(refunds))
+	}
+	balance, err := repo.Balance(ctx, ledger.MerchantPayable(merchant), time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != -970 {
+		t.Fatalf("balance = %d, want -970", balance)
+	}
+}
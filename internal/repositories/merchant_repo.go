@@ -0,0 +1,72 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+
+	"be/internal/models"
+)
+
+const (
+	PayoutScheduleDaily   = "DAILY"
+	PayoutScheduleWeekly  = "WEEKLY"
+	PayoutScheduleMonthly = "MONTHLY"
+)
+
+type MerchantRepository interface {
+	Get(ctx context.Context, id string) (*models.Merchant, error)
+	Upsert(ctx context.Context, m *models.Merchant) (*models.Merchant, error)
+	// KnownTimezone reports whether Postgres knows the zone name, as settlement dates are computed with it
+	KnownTimezone(ctx context.Context, name string) (bool, error)
+}
+
+type merchantRepository struct{ db *sqlx.DB }
+
+func NewMerchantRepository(db *sqlx.DB) MerchantRepository { return &merchantRepository{db: db} }
+
+const merchantColumns = `id, timezone, cutoff, payout_schedule, payout_lag_days, payout_weekday, reserve_bps, reserve_days, created_at, updated_at`
+
+func scanMerchant(row *sqlx.Row) (*models.Merchant, error) {
+	var m models.Merchant
+	var cutoff sql.NullString
+	var weekday sql.NullInt32
+	if err := row.Scan(&m.ID, &m.Timezone, &cutoff, &m.PayoutSchedule, &m.PayoutLagDays, &weekday, &m.ReserveBps, &m.ReserveDays, &m.CreatedAt, &m.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if cutoff.Valid {
+		m.Cutoff = &cutoff.String
+	}
+	if weekday.Valid {
+		wd := int(weekday.Int32)
+		m.PayoutWeekday = &wd
+	}
+	return &m, nil
+}
+
+func (r *merchantRepository) Get(ctx context.Context, id string) (*models.Merchant, error) {
+	return scanMerchant(r.db.QueryRowxContext(ctx, `SELECT `+merchantColumns+` FROM merchants WHERE id = $1`, id))
+}
+
+// Upsert creates or updates the merchant configuration row
+func (r *merchantRepository) Upsert(ctx context.Context, m *models.Merchant) (*models.Merchant, error) {
+	return scanMerchant(r.db.QueryRowxContext(ctx, `INSERT INTO merchants (id, timezone, cutoff, payout_schedule, payout_lag_days, payout_weekday, reserve_bps, reserve_days)
+        VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+        ON CONFLICT (id) DO UPDATE SET
+           timezone=EXCLUDED.timezone,
+           cutoff=EXCLUDED.cutoff,
+           payout_schedule=EXCLUDED.payout_schedule,
+           payout_lag_days=EXCLUDED.payout_lag_days,
+           payout_weekday=EXCLUDED.payout_weekday,
+           reserve_bps=EXCLUDED.reserve_bps,
+           reserve_days=EXCLUDED.reserve_days,
+           updated_at=now()
+        RETURNING `+merchantColumns, m.ID, m.Timezone, m.Cutoff, m.PayoutSchedule, m.PayoutLagDays, m.PayoutWeekday, m.ReserveBps, m.ReserveDays))
+}
+
+func (r *merchantRepository) KnownTimezone(ctx context.Context, name string) (bool, error) {
+	var ok bool
+	err := r.db.GetContext(ctx, &ok, `SELECT EXISTS (SELECT 1 FROM pg_timezone_names WHERE name = $1)`, name)
+	return ok, err
+}
@@ -0,0 +1,19 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+)
+
+func TestKnownTimezone(t *testing.T) {
+	repo := NewMerchantRepository(setupTestDB(t))
+	for name, want := range map[string]bool{"Asia/Jakarta": true, "UTC": true, "Local": false, "Mars/Olympus": false} {
+		ok, err := repo.KnownTimezone(context.Background(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != want {
+			t.Errorf("KnownTimezone(%q) = %v, want %v", name, ok, want)
+		}
+	}
+}
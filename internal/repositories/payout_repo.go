@@ -0,0 +1,271 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"be/internal/ledger"
+	"be/internal/models"
+)
+
+const (
+	PayoutStatusPending   = "PENDING"
+	PayoutStatusSent      = "SENT"
+	PayoutStatusConfirmed = "CONFIRMED"
+	PayoutStatusFailed    = "FAILED"
+
+	AuditEntityPayout = "payout"
+)
+
+var (
+	ErrInvalidPayoutTransition = errors.New("INVALID_PAYOUT_TRANSITION")
+	ErrPayoutsChanged          = errors.New("PAYOUTS_CHANGED")
+)
+
+// payoutTransitions lists the statuses a payout may move to from each status
+var payoutTransitions = map[string][]string{
+	PayoutStatusPending: {PayoutStatusSent, PayoutStatusFailed},
+	PayoutStatusSent:    {PayoutStatusConfirmed, PayoutStatusFailed},
+}
+
+type PayoutRepository interface {
+	UnpaidMerchants(ctx context.Context, from, to time.Time) ([]string, error)
+	CollectUnpaid(ctx context.Context, jobID, merchantID string, from, to time.Time) (*models.Payout, error)
+	Get(ctx context.Context, id int64) (*models.Payout, error)
+	List(ctx context.Context, merchantID, status string) ([]models.Payout, error)
+	Transition(ctx context.Context, id int64, to string, reference, reason *string, actor string) (*models.Payout, error)
+	Pending(ctx context.Context, from, to time.Time, merchantIDs []string) ([]models.Payout, error)
+	MarkSent(ctx context.Context, jobID, resultPath string, ids []int64) error
+}
+
+type payoutRepository struct{ db *sqlx.DB }
+
+func NewPayoutRepository(db *sqlx.DB) PayoutRepository { return &payoutRepository{db: db} }
+
+const payoutColumns = `id, merchant_id, job_id, amount_cents, settlement_count, period_start, period_end, status,
+        bank_reference, failure_reason, created_at, updated_at, sent_at, confirmed_at, failed_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanPayout(row rowScanner) (*models.Payout, error) {
+	var p models.Payout
+	var ref, reason sql.NullString
+	var sentAt, confirmedAt, failedAt sql.NullTime
+	if err := row.Scan(&p.ID, &p.MerchantID, &p.JobID, &p.AmountCents, &p.SettlementCount, &p.PeriodStart, &p.PeriodEnd, &p.Status,
+		&ref, &reason, &p.CreatedAt, &p.UpdatedAt, &sentAt, &confirmedAt, &failedAt); err != nil {
+		return nil, err
+	}
+	if ref.Valid {
+		p.BankReference = &ref.String
+	}
+	if reason.Valid {
+		p.FailureReason = &reason.String
+	}
+	if sentAt.Valid {
+		p.SentAt = &sentAt.Time
+	}
+	if confirmedAt.Valid {
+		p.ConfirmedAt = &confirmedAt.Time
+	}
+	if failedAt.Valid {
+		p.FailedAt = &failedAt.Time
+	}
+	return &p, nil
+}
+
+// UnpaidMerchants lists merchants with settlement rows in date range (inclusive) not yet in a payout
+func (r *payoutRepository) UnpaidMerchants(ctx context.Context, from, to time.Time) ([]string, error) {
+	var ids []string
+	err := r.db.SelectContext(ctx, &ids, `SELECT DISTINCT merchant_id FROM settlements
+        WHERE payout_id IS NULL AND date BETWEEN $1::date AND $2::date
+        ORDER BY merchant_id`, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	return ids, err
+}
+
+// CollectUnpaid locks the merchant's unpaid settlement rows in range, creates a PENDING payout for them
+// and marks them as included, all in one transaction. It returns nil when there is nothing to pay,
+// so a rerun never pays a settlement row twice. Rows that add up to zero or less are left unpaid and
+// carried forward until later rows make the amount positive.
+func (r *payoutRepository) CollectUnpaid(ctx context.Context, jobID, merchantID string, from, to time.Time) (*models.Payout, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rows []struct {
+		ID           int64     `db:"id"`
+		Date         time.Time `db:"date"`
+		PayableCents int64     `db:"payable_cents"`
+	}
+	if err := tx.SelectContext(ctx, &rows, `SELECT id, date, payable_cents FROM settlements
+        WHERE merchant_id = $1 AND payout_id IS NULL AND date BETWEEN $2::date AND $3::date
+        ORDER BY date
+        FOR UPDATE`, merchantID, from.Format("2006-01-02"), to.Format("2006-01-02")); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, len(rows))
+	var amount int64
+	for i, row := range rows {
+		ids[i] = row.ID
+		amount += row.PayableCents
+	}
+	if amount <= 0 {
+		return nil, nil
+	}
+	p, err := scanPayout(tx.QueryRowxContext(ctx, `INSERT INTO payouts (merchant_id, job_id, amount_cents, settlement_count, period_start, period_end, status)
+        VALUES ($1,$2,$3,$4,$5,$6,$7)
+        RETURNING `+payoutColumns, merchantID, jobID, amount, len(rows), rows[0].Date, rows[len(rows)-1].Date, PayoutStatusPending))
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE settlements SET payout_id = $1 WHERE id = ANY($2) AND payout_id IS NULL`, p.ID, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *payoutRepository) Get(ctx context.Context, id int64) (*models.Payout, error) {
+	return scanPayout(r.db.QueryRowxContext(ctx, `SELECT `+payoutColumns+` FROM payouts WHERE id = $1`, id))
+}
+
+// List returns payouts, newest first, optionally filtered by merchant and status
+func (r *payoutRepository) List(ctx context.Context, merchantID, status string) ([]models.Payout, error) {
+	rows, err := r.db.QueryxContext(ctx, `SELECT `+payoutColumns+` FROM payouts
+        WHERE ($1 = '' OR merchant_id = $1) AND ($2 = '' OR status = $2)
+        ORDER BY id DESC
+        LIMIT 1000`, merchantID, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.Payout{}
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *p)
+	}
+	return out, rows.Err()
+}
+
+// Pending returns the PENDING payouts whose period ends in date range (inclusive), ordered by merchant
+// and id; an empty merchantIDs selects every merchant
+func (r *payoutRepository) Pending(ctx context.Context, from, to time.Time, merchantIDs []string) ([]models.Payout, error) {
+	rows, err := r.db.QueryxContext(ctx, `SELECT `+payoutColumns+` FROM payouts
+        WHERE status = $1 AND period_end BETWEEN $2::date AND $3::date
+          AND (COALESCE(cardinality($4::text[]), 0) = 0 OR merchant_id = ANY($4))
+        ORDER BY merchant_id, id`, PayoutStatusPending, from.Format("2006-01-02"), to.Format("2006-01-02"), pq.Array(merchantIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.Payout{}
+	for rows.Next() {
+		p, err := scanPayout(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *p)
+	}
+	return out, rows.Err()
+}
+
+// MarkSent moves the payouts of a bank file from PENDING to SENT and completes the file's job in one
+// transaction. It fails with ErrPayoutsChanged when any of them is no longer PENDING, e.g. because
+// another bank file took it, so a payout is never sent twice.
+func (r *payoutRepository) MarkSent(ctx context.Context, jobID, resultPath string, ids []int64) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	res, err := tx.ExecContext(ctx, `UPDATE payouts SET status = $1, sent_at = now(), bank_reference = COALESCE(bank_reference, $2), updated_at = now()
+        WHERE id = ANY($3) AND status = $4`, PayoutStatusSent, jobID, pq.Array(ids), PayoutStatusPending)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n != int64(len(ids)) {
+		return ErrPayoutsChanged
+	}
+	if err := setJobCompleted(ctx, tx, jobID, resultPath); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Transition moves a payout to a new status if allowed from its current one.
+// A confirmed payout is posted to the ledger; a failed payout releases its settlement rows so a
+// later payout run can pick them up again. The acting user is audited in the same transaction.
+func (r *payoutRepository) Transition(ctx context.Context, id int64, to string, reference, reason *string, actor string) (*models.Payout, error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var current string
+	if err := tx.QueryRowContext(ctx, `SELECT status FROM payouts WHERE id = $1 FOR UPDATE`, id).Scan(&current); err != nil {
+		return nil, err
+	}
+	allowed := false
+	for _, s := range payoutTransitions[current] {
+		allowed = allowed || s == to
+	}
+	if !allowed {
+		return nil, ErrInvalidPayoutTransition
+	}
+
+	p, err := scanPayout(tx.QueryRowxContext(ctx, `UPDATE payouts SET
+           status = $2,
+           bank_reference = COALESCE($3, bank_reference),
+           failure_reason = COALESCE($4, failure_reason),
+           sent_at = CASE WHEN $2 = 'SENT' THEN now() ELSE sent_at END,
+           confirmed_at = CASE WHEN $2 = 'CONFIRMED' THEN now() ELSE confirmed_at END,
+           failed_at = CASE WHEN $2 = 'FAILED' THEN now() ELSE failed_at END,
+           updated_at = now()
+        WHERE id = $1
+        RETURNING `+payoutColumns, id, to, reference, reason))
+	if err != nil {
+		return nil, err
+	}
+	if to == PayoutStatusConfirmed && p.AmountCents != 0 {
+		entry := ledger.Payout(p.MerchantID, strconv.FormatInt(id, 10), p.AmountCents, *p.ConfirmedAt)
+		if _, err := postEntries(ctx, tx, []ledger.Entry{entry}); err != nil {
+			return nil, err
+		}
+	}
+	if to == PayoutStatusFailed {
+		if _, err := tx.ExecContext(ctx, `UPDATE settlements SET payout_id = NULL WHERE payout_id = $1`, id); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeAudit(ctx, tx, AuditEntityPayout, strconv.FormatInt(id, 10), to, actor, map[string]any{
+		"from": current, "bank_reference": reference, "failure_reason": reason,
+	}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,169 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// TestCollectUnpaidNeverPaysTwice reruns collection over the same range and checks
+// that settlement rows are only included again after their payout failed.
+func TestCollectUnpaidNeverPaysTwice(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPayoutRepository(db)
+	ctx := context.Background()
+	const merchant, jobID = "m-payout-test", "job_payout_test"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM settlements WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM payouts WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM jobs WHERE id = $1`, jobID)
+	}
+	cleanup()
+	defer cleanup()
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 3, 0, 0, 0, 0, time.UTC)
+	if err := NewJobRepository(db).Create(ctx, jobID, JobTypePayout, 1, from, to, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	st := NewSettlementRepository(db)
+	for _, d := range []string{"2025-01-01", "2025-01-02", "2025-01-03"} {
+		if err := st.Upsert(ctx, SettlementRow{MerchantID: merchant, Date: d, GrossCents: 1000, FeeCents: 30, NetCents: 970, PayableCents: 970, TxnCount: 1, Cutoff: "00:00:00", RunID: "run"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := repo.CollectUnpaid(ctx, jobID, merchant, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.AmountCents != 2910 || p.SettlementCount != 3 || p.Status != PayoutStatusPending {
+		t.Fatalf("unexpected payout: %+v", p)
+	}
+
+	// a rerun of the settlement must not change what the payout already includes
+	if err := st.Upsert(ctx, SettlementRow{MerchantID: merchant, Date: "2025-01-01", GrossCents: 5000, NetCents: 5000, PayableCents: 5000, TxnCount: 2, Cutoff: "00:00:00", RunID: "rerun"}); err != nil {
+		t.Fatal(err)
+	}
+	paid, err := st.ListByMerchant(ctx, merchant, from, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paid) != 1 || paid[0].PayableCents != 970 || paid[0].UniqueRunID != "run" {
+		t.Fatalf("paid settlement was overwritten: %+v", paid)
+	}
+
+	again, err := repo.CollectUnpaid(ctx, jobID, merchant, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != nil {
+		t.Fatalf("settlements were collected twice: %+v", again)
+	}
+
+	if _, err := repo.Transition(ctx, p.ID, PayoutStatusConfirmed, nil, nil, "tester"); err != ErrInvalidPayoutTransition {
+		t.Fatalf("expected invalid transition from PENDING to CONFIRMED, got %v", err)
+	}
+	reason := "account closed"
+	if _, err := repo.Transition(ctx, p.ID, PayoutStatusFailed, nil, &reason, "tester"); err != nil {
+		t.Fatal(err)
+	}
+	retry, err := repo.CollectUnpaid(ctx, jobID, merchant, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retry == nil || retry.SettlementCount != 3 {
+		t.Fatalf("expected failed payout's settlements to be collected again, got %+v", retry)
+	}
+}
+
+// TestCollectUnpaidCarriesNonPositive leaves rows that do not add up to a positive amount unpaid
+// until later rows do
+func TestCollectUnpaidCarriesNonPositive(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPayoutRepository(db)
+	ctx := context.Background()
+	const merchant, jobID = "m-payout-carry-test", "job_payout_carry_test"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM settlements WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM payouts WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM jobs WHERE id = $1`, jobID)
+	}
+	cleanup()
+	defer cleanup()
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	if err := NewJobRepository(db).Create(ctx, jobID, JobTypePayout, 1, from, to, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	st := NewSettlementRepository(db)
+	upsert := func(day string, payable int64) {
+		if err := st.Upsert(ctx, SettlementRow{MerchantID: merchant, Date: day, NetCents: payable, PayableCents: payable, Cutoff: "00:00:00", RunID: "run"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	upsert("2025-01-01", -500)
+	if p, err := repo.CollectUnpaid(ctx, jobID, merchant, from, to); err != nil || p != nil {
+		t.Fatalf("negative amount collected: %+v, %v", p, err)
+	}
+	upsert("2025-01-02", 800)
+	p, err := repo.CollectUnpaid(ctx, jobID, merchant, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil || p.AmountCents != 300 || p.SettlementCount != 2 {
+		t.Fatalf("expected the carried debit to net against the next row, got %+v", p)
+	}
+}
+
+// TestMarkSentOnce sends a pending payout with one bank file job and refuses it to a second one
+func TestMarkSentOnce(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewPayoutRepository(db)
+	jobs := NewJobRepository(db)
+	ctx := context.Background()
+	const merchant, payoutJob, fileA, fileB = "m-payout-sent-test", "job_payout_sent_test", "job_bankfile_a_test", "job_bankfile_b_test"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM settlements WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM payouts WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM jobs WHERE id = ANY($1)`, pq.Array([]string{payoutJob, fileA, fileB}))
+	}
+	cleanup()
+	defer cleanup()
+
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, id := range []string{payoutJob, fileA, fileB} {
+		if err := jobs.Create(ctx, id, JobTypePayout, 0, day, day, nil, ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := jobs.SetRunning(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := NewSettlementRepository(db).Upsert(ctx, SettlementRow{MerchantID: merchant, Date: "2025-01-01", NetCents: 970, PayableCents: 970, Cutoff: "00:00:00", RunID: "run"}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := repo.CollectUnpaid(ctx, payoutJob, merchant, day, day)
+	if err != nil || p == nil {
+		t.Fatalf("collect: %+v, %v", p, err)
+	}
+	pending, err := repo.Pending(ctx, day, day, []string{merchant})
+	if err != nil || len(pending) != 1 || pending[0].ID != p.ID {
+		t.Fatalf("pending = %+v, %v", pending, err)
+	}
+	if err := repo.MarkSent(ctx, fileA, fileA+".ach", []int64{p.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MarkSent(ctx, fileB, fileB+".ach", []int64{p.ID}); err != ErrPayoutsChanged {
+		t.Fatalf("second bank file: %v, want ErrPayoutsChanged", err)
+	}
+	if jr, err := jobs.Get(ctx, fileB); err != nil || jr.Status == JobStatusCompleted {
+		t.Fatalf("second bank file job completed: %+v, %v", jr, err)
+	}
+}
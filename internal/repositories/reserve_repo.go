@@ -0,0 +1,153 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"be/internal/models"
+)
+
+const (
+	ReserveStatusHeld     = "HELD"
+	ReserveStatusReleased = "RELEASED"
+)
+
+// ReserveConfig is a merchant's rolling reserve: Bps basis points of positive net held for Days days
+type ReserveConfig struct {
+	MerchantID string `db:"id"`
+	Bps        int64  `db:"reserve_bps"`
+	Days       int    `db:"reserve_days"`
+}
+
+type ReserveRepository interface {
+	Configs(ctx context.Context) (map[string]ReserveConfig, error)
+	DueInRange(ctx context.Context, from, to time.Time) ([]models.Reserve, error)
+	ListByMerchant(ctx context.Context, merchantID string) ([]models.Reserve, error)
+	Totals(ctx context.Context, merchantID string, asOf time.Time) (held, due, released int64, err error)
+}
+
+type reserveRepository struct{ db *sqlx.DB }
+
+func NewReserveRepository(db *sqlx.DB) ReserveRepository { return &reserveRepository{db: db} }
+
+const reserveColumns = `id, merchant_id, settlement_date, amount_cents, release_date, status,
+        settlement_run_id, release_run_id, released_at, created_at`
+
+func scanReserve(row rowScanner) (*models.Reserve, error) {
+	var rs models.Reserve
+	var releaseRun sql.NullString
+	var releasedAt sql.NullTime
+	if err := row.Scan(&rs.ID, &rs.MerchantID, &rs.SettlementDate, &rs.AmountCents, &rs.ReleaseDate, &rs.Status,
+		&rs.SettlementRunID, &releaseRun, &releasedAt, &rs.CreatedAt); err != nil {
+		return nil, err
+	}
+	if releaseRun.Valid {
+		rs.ReleaseRunID = &releaseRun.String
+	}
+	if releasedAt.Valid {
+		rs.ReleasedAt = &releasedAt.Time
+	}
+	return &rs, nil
+}
+
+func (r *reserveRepository) list(ctx context.Context, query string, args ...any) ([]models.Reserve, error) {
+	rows, err := readerFor(ctx, r.db).QueryxContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []models.Reserve{}
+	for rows.Next() {
+		rs, err := scanReserve(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *rs)
+	}
+	return out, rows.Err()
+}
+
+// Configs returns the reserve configuration of every merchant that has one
+func (r *reserveRepository) Configs(ctx context.Context) (map[string]ReserveConfig, error) {
+	var rows []ReserveConfig
+	if err := readerFor(ctx, r.db).SelectContext(ctx, &rows, `SELECT id, reserve_bps, reserve_days FROM merchants
+        WHERE reserve_bps > 0 AND reserve_days > 0`); err != nil {
+		return nil, err
+	}
+	out := make(map[string]ReserveConfig, len(rows))
+	for _, c := range rows {
+		out[c.MerchantID] = c
+	}
+	return out, nil
+}
+
+// keyFilter restricts a query to the merchant/days passed as parallel arrays; empty arrays select all
+func keyFilter(merchantCol, dateCol, merchants, dates string) string {
+	return `(COALESCE(cardinality(` + merchants + `::text[]), 0) = 0 OR (` + merchantCol + `, ` + dateCol + `) IN (
+            SELECT * FROM unnest(` + merchants + `::text[], ` + dates + `::date[])))`
+}
+
+// replaceHeld replaces the still-held reserves of settlement dates in range (inclusive) with the ones
+// the run staged in settlement_stage, so a rerun recomputes them; non-empty keys narrow it to those
+// merchant/days. Reserves that were already released, and those of days in a payout, which the merge
+// kept as paid, are left as they are.
+func replaceHeld(ctx context.Context, tx *sqlx.Tx, from, to time.Time, keys []SettlementKey, runID string) error {
+	keyMerchants, keyDates := splitKeys(keys)
+	if _, err := tx.ExecContext(ctx, `DELETE FROM reserves r WHERE status = $1 AND settlement_date BETWEEN $2::date AND $3::date
+        AND `+keyFilter("merchant_id", "settlement_date", "$4", "$5")+`
+        AND NOT EXISTS (SELECT 1 FROM settlements s
+            WHERE s.merchant_id = r.merchant_id AND s.date = r.settlement_date AND s.payout_id IS NOT NULL)`,
+		ReserveStatusHeld, from.Format("2006-01-02"), to.Format("2006-01-02"), pq.Array(keyMerchants), pq.Array(keyDates)); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO reserves (merchant_id, settlement_date, amount_cents, release_date, settlement_run_id)
+        SELECT st.merchant_id, st.date, st.reserved_cents, st.release_date, $1
+        FROM settlement_stage st JOIN settlement_written w ON w.merchant_id = st.merchant_id AND w.date = st.date
+        WHERE st.release_date IS NOT NULL
+        ON CONFLICT (merchant_id, settlement_date) DO NOTHING`, runID)
+	return err
+}
+
+// DueInRange returns reserves whose release date falls in range (inclusive), released or not
+func (r *reserveRepository) DueInRange(ctx context.Context, from, to time.Time) ([]models.Reserve, error) {
+	return r.list(ctx, `SELECT `+reserveColumns+` FROM reserves
+        WHERE release_date BETWEEN $1::date AND $2::date
+        ORDER BY id`, from.Format("2006-01-02"), to.Format("2006-01-02"))
+}
+
+// markReleased records the settlement run that paid out the reserves due in range (inclusive);
+// non-empty keys narrow it to reserves released on those merchant/days. Only release days the run
+// wrote count: a paid day kept its row, so the reserves due on it stay as they were.
+func markReleased(ctx context.Context, tx *sqlx.Tx, from, to time.Time, keys []SettlementKey, runID string) error {
+	keyMerchants, keyDates := splitKeys(keys)
+	_, err := tx.ExecContext(ctx, `UPDATE reserves SET
+           status = $1, release_run_id = $2, released_at = COALESCE(released_at, now())
+        WHERE release_date BETWEEN $3::date AND $4::date
+          AND `+keyFilter("merchant_id", "release_date", "$5", "$6")+`
+          AND (merchant_id, release_date) IN (SELECT merchant_id, date FROM settlement_written)`,
+		ReserveStatusReleased, runID, from.Format("2006-01-02"), to.Format("2006-01-02"), pq.Array(keyMerchants), pq.Array(keyDates))
+	return err
+}
+
+// ListByMerchant returns a merchant's reserves, newest settlement date first
+func (r *reserveRepository) ListByMerchant(ctx context.Context, merchantID string) ([]models.Reserve, error) {
+	return r.list(ctx, `SELECT `+reserveColumns+` FROM reserves
+        WHERE merchant_id = $1
+        ORDER BY settlement_date DESC
+        LIMIT 1000`, merchantID)
+}
+
+// Totals sums a merchant's reserves: held until a later date, due (release date reached but
+// not yet paid out by a settlement run) and released
+func (r *reserveRepository) Totals(ctx context.Context, merchantID string, asOf time.Time) (held, due, released int64, err error) {
+	err = r.db.QueryRowContext(ctx, `SELECT
+           COALESCE(SUM(amount_cents) FILTER (WHERE status = 'HELD' AND release_date > $2::date), 0),
+           COALESCE(SUM(amount_cents) FILTER (WHERE status = 'HELD' AND release_date <= $2::date), 0),
+           COALESCE(SUM(amount_cents) FILTER (WHERE status = 'RELEASED'), 0)
+        FROM reserves WHERE merchant_id = $1`, merchantID, asOf.Format("2006-01-02")).Scan(&held, &due, &released)
+	return held, due, released, err
+}
@@ -2,28 +2,262 @@ package repositories
 
 import (
 	"context"
+	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+
+	"be/internal/models"
 )
 
+// SettlementRow is one merchant/day aggregate as written by a settlement run
+type SettlementRow struct {
+	MerchantID      string `db:"merchant_id"`
+	Date            string `db:"date"`
+	GrossCents      int64  `db:"gross_cents"`
+	FeeCents        int64  `db:"fee_cents"`
+	AdjustmentCents int64  `db:"adjustment_cents"` // approved adjustments, included in NetCents
+	NetCents        int64  `db:"net_cents"`
+	ReservedCents   int64  `db:"reserved_cents"` // held back from NetCents by the merchant's rolling reserve
+	ReleasedCents   int64  `db:"released_cents"` // earlier reserves released on this date
+	PayableCents    int64  `db:"payable_cents"`  // NetCents - ReservedCents + ReleasedCents
+	TxnCount        int64  `db:"txn_count"`
+	Cutoff          string `db:"cutoff"` // cut-off in effect, as a TIME literal
+	RunID           string `db:"unique_run_id"`
+}
+
+// SettlementKey identifies one merchant/day settlement row
+type SettlementKey struct {
+	MerchantID string `json:"merchant_id"`
+	Date       string `json:"date"`
+}
+
+// DirtyDay is a merchant-local day with transaction changes that were not settled yet;
+// its transactions settle on that day or the next
+type DirtyDay struct {
+	MerchantID string    `db:"merchant_id" json:"merchant_id"`
+	Day        time.Time `db:"day" json:"day"`
+	MarkedAt   time.Time `db:"marked_at" json:"marked_at"`
+}
+
+// LateArrival is a settled merchant/day with transactions changed after its settlement was generated
+type LateArrival struct {
+	MerchantID string    `db:"merchant_id"`
+	Date       time.Time `db:"date"`
+	RunID      string    `db:"unique_run_id"` // run that settled it
+}
+
+// splitKeys returns the merchant ids and dates of keys as parallel arrays for unnest
+func splitKeys(keys []SettlementKey) (merchants, dates []string) {
+	merchants = make([]string, len(keys))
+	dates = make([]string, len(keys))
+	for i, k := range keys {
+		merchants[i], dates[i] = k.MerchantID, k.Date
+	}
+	return merchants, dates
+}
+
 type SettlementRepository interface {
-	Upsert(ctx context.Context, merchantID string, date string, gross, fee, net int64, txnCount int64, runID string) error
+	Upsert(ctx context.Context, row SettlementRow) error
+	WriteRun(ctx context.Context, run SettlementRun, rows func(emit func(SettlementRunRow) error) error) error
+	ListByMerchant(ctx context.Context, merchantID string, from, to time.Time) ([]models.Settlement, error)
+	ListInRange(ctx context.Context, from, to time.Time, merchantIDs []string) ([]models.Settlement, error)
+	DirtyDays(ctx context.Context, from, to time.Time) ([]DirtyDay, error)
+	ClearDirty(ctx context.Context, days []DirtyDay) error
+	LateArrivals(ctx context.Context, limit int) ([]LateArrival, error)
 }
 
 type settlementRepository struct{ db *sqlx.DB }
 
-func NewSettlementRepository(db *sqlx.DB) SettlementRepository { return &settlementRepository{db: db} }
-
-// Upsert merchant/day row
-func (r *settlementRepository) Upsert(ctx context.Context, merchantID string, date string, gross, fee, net int64, txnCount int64, runID string) error {
-	_, err := r.db.ExecContext(ctx, `INSERT INTO settlements (merchant_id, date, gross_cents, fee_cents, net_cents, txn_count, unique_run_id) 
-        VALUES ($1,$2,$3,$4,$5,$6,$7)
-        ON CONFLICT (merchant_id, date) DO UPDATE SET 
+// settlementMerge updates an existing merchant/day with a recomputed row unless the row is already in a
+// payout: what was paid stays as paid, and a later difference shows up in the next correction report
+const settlementMerge = `ON CONFLICT (merchant_id, date) DO UPDATE SET
            gross_cents=EXCLUDED.gross_cents,
            fee_cents=EXCLUDED.fee_cents,
+           adjustment_cents=EXCLUDED.adjustment_cents,
            net_cents=EXCLUDED.net_cents,
+           reserved_cents=EXCLUDED.reserved_cents,
+           released_cents=EXCLUDED.released_cents,
+           payable_cents=EXCLUDED.payable_cents,
            txn_count=EXCLUDED.txn_count,
+           cutoff=EXCLUDED.cutoff,
            unique_run_id=EXCLUDED.unique_run_id,
-           generated_at=now()`, merchantID, date, gross, fee, net, txnCount, runID)
+           generated_at=now()
+        WHERE settlements.payout_id IS NULL`
+
+func NewSettlementRepository(db *sqlx.DB) SettlementRepository { return &settlementRepository{db: db} }
+
+// Upsert merchant/day row; a row already in a payout is left unchanged
+func (r *settlementRepository) Upsert(ctx context.Context, row SettlementRow) error {
+	_, err := r.db.NamedExecContext(ctx, `INSERT INTO settlements (merchant_id, date, gross_cents, fee_cents, adjustment_cents, net_cents, reserved_cents, released_cents, payable_cents, txn_count, cutoff, unique_run_id)
+        VALUES (:merchant_id, :date, :gross_cents, :fee_cents, :adjustment_cents, :net_cents, :reserved_cents, :released_cents, :payable_cents, :txn_count, :cutoff, :unique_run_id)
+        `+settlementMerge, row)
 	return err
 }
+
+// SettlementRun is what a settlement job writes besides its rows when it completes
+type SettlementRun struct {
+	JobID         string
+	ResultPath    string
+	From, To      time.Time
+	Keys          []SettlementKey // merchant/days the run recomputed; empty for the whole range
+	AdjustmentIDs []int64         // approved adjustments the run included
+}
+
+// SettlementRunRow is a settlement row of a run with the reserve it holds, if any
+type SettlementRunRow struct {
+	SettlementRow
+	ReleaseDate string // release date of the ReservedCents held; empty when the row holds none
+}
+
+// WriteRun writes a settlement run and marks its job completed in one transaction, so a crash or a
+// cancel never leaves part of it behind. rows streams the run's rows to emit, which copies each into a
+// staging table as it comes, so a run of any size is never held in memory; rows may do other work
+// before returning, such as finishing the run's files. The staged rows are merged with a single
+// INSERT ... ON CONFLICT, which skips rows already in a payout; the merchant/days it wrote are kept in
+// settlement_written, and only those get their held reserves replaced, their adjustments marked settled
+// and their due reserves marked released, so nothing is recorded as settled on a day that was paid.
+func (r *settlementRepository) WriteRun(ctx context.Context, run SettlementRun, rows func(emit func(SettlementRunRow) error) error) error {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `CREATE TEMP TABLE settlement_stage (
+            merchant_id TEXT, date DATE, gross_cents BIGINT, fee_cents BIGINT, adjustment_cents BIGINT, net_cents BIGINT,
+            reserved_cents BIGINT, released_cents BIGINT, payable_cents BIGINT, txn_count BIGINT, cutoff TIME, unique_run_id TEXT,
+            release_date DATE
+        ) ON COMMIT DROP`); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, pq.CopyIn("settlement_stage", "merchant_id", "date", "gross_cents", "fee_cents", "adjustment_cents",
+		"net_cents", "reserved_cents", "released_cents", "payable_cents", "txn_count", "cutoff", "unique_run_id", "release_date"))
+	if err != nil {
+		return err
+	}
+	err = rows(func(row SettlementRunRow) error {
+		var release any
+		if row.ReleaseDate != "" {
+			release = row.ReleaseDate
+		}
+		_, err := stmt.ExecContext(ctx, row.MerchantID, row.Date, row.GrossCents, row.FeeCents, row.AdjustmentCents,
+			row.NetCents, row.ReservedCents, row.ReleasedCents, row.PayableCents, row.TxnCount, row.Cutoff, row.RunID, release)
+		return err
+	})
+	if err != nil {
+		_ = stmt.Close()
+		return err
+	}
+	// an Exec without arguments flushes the COPY
+	if _, err := stmt.ExecContext(ctx); err != nil {
+		_ = stmt.Close()
+		return err
+	}
+	if err := stmt.Close(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `CREATE TEMP TABLE settlement_written (merchant_id TEXT, date DATE) ON COMMIT DROP`); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `WITH merged AS (
+            INSERT INTO settlements (merchant_id, date, gross_cents, fee_cents, adjustment_cents, net_cents, reserved_cents, released_cents, payable_cents, txn_count, cutoff, unique_run_id)
+            SELECT merchant_id, date, gross_cents, fee_cents, adjustment_cents, net_cents, reserved_cents, released_cents, payable_cents, txn_count, cutoff, unique_run_id
+            FROM settlement_stage
+            `+settlementMerge+`
+            RETURNING merchant_id, date
+        )
+        INSERT INTO settlement_written SELECT merchant_id, date FROM merged`); err != nil {
+		return err
+	}
+	if err := replaceHeld(ctx, tx, run.From, run.To, run.Keys, run.JobID); err != nil {
+		return err
+	}
+	if err := markAdjustmentsSettled(ctx, tx, run.AdjustmentIDs, run.JobID); err != nil {
+		return err
+	}
+	if err := markReleased(ctx, tx, run.From, run.To, run.Keys, run.JobID); err != nil {
+		return err
+	}
+	if err := setJobCompleted(ctx, tx, run.JobID, run.ResultPath); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ListByMerchant returns a merchant's settlement rows in date range (inclusive), ordered by date
+func (r *settlementRepository) ListByMerchant(ctx context.Context, merchantID string, from, to time.Time) ([]models.Settlement, error) {
+	return r.ListInRange(ctx, from, to, []string{merchantID})
+}
+
+// ListInRange returns settlement rows in date range (inclusive) ordered by merchant and date;
+// an empty merchantIDs selects every merchant
+func (r *settlementRepository) ListInRange(ctx context.Context, from, to time.Time, merchantIDs []string) ([]models.Settlement, error) {
+	rows, err := readerFor(ctx, r.db).QueryxContext(ctx, `SELECT id, merchant_id, date, gross_cents, fee_cents, adjustment_cents, net_cents, reserved_cents, released_cents, payable_cents, txn_count, cutoff, generated_at, unique_run_id
+        FROM settlements
+        WHERE date BETWEEN $1::date AND $2::date
+          AND (COALESCE(cardinality($3::text[]), 0) = 0 OR merchant_id = ANY($3))
+        ORDER BY merchant_id ASC, date ASC`, from.Format("2006-01-02"), to.Format("2006-01-02"), pq.Array(merchantIDs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []models.Settlement
+	for rows.Next() {
+		var st models.Settlement
+		if err := rows.Scan(&st.ID, &st.MerchantID, &st.Date, &st.GrossCents, &st.FeeCents, &st.AdjustmentCents, &st.NetCents, &st.ReservedCents, &st.ReleasedCents, &st.PayableCents, &st.TxnCount, &st.Cutoff, &st.GeneratedAt, &st.UniqueRunID); err != nil {
+			return nil, err
+		}
+		out = append(out, st)
+	}
+	return out, rows.Err()
+}
+
+// DirtyDays returns the dirty days whose transactions may settle on a date in range (inclusive)
+func (r *settlementRepository) DirtyDays(ctx context.Context, from, to time.Time) ([]DirtyDay, error) {
+	var out []DirtyDay
+	err := readerFor(ctx, r.db).SelectContext(ctx, &out, `SELECT merchant_id, day, marked_at FROM settlement_dirty
+        WHERE day BETWEEN $1::date - 1 AND $2::date
+        ORDER BY merchant_id, day`, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	return out, err
+}
+
+// ClearDirty removes dirty days once they are settled; a day marked again in the meantime stays
+func (r *settlementRepository) ClearDirty(ctx context.Context, days []DirtyDay) error {
+	if len(days) == 0 {
+		return nil
+	}
+	merchants := make([]string, len(days))
+	dates := make([]string, len(days))
+	marked := make([]string, len(days))
+	for i, d := range days {
+		merchants[i], dates[i], marked[i] = d.MerchantID, d.Day.Format("2006-01-02"), d.MarkedAt.Format(time.RFC3339Nano)
+	}
+	_, err := r.db.ExecContext(ctx, `DELETE FROM settlement_dirty d
+        USING unnest($1::text[], $2::date[], $3::timestamptz[]) AS c(merchant_id, day, marked_at)
+        WHERE d.merchant_id = c.merchant_id AND d.day = c.day AND d.marked_at = c.marked_at`,
+		pq.Array(merchants), pq.Array(dates), pq.Array(marked))
+	return err
+}
+
+// LateArrivals returns settled merchant/days that a dirty day not visible in the settling run's
+// snapshot can settle on (the dirty day itself or the next one), oldest first. A transaction still
+// open when the snapshot was taken is late even if it marked the day before. Rows written outside a
+// snapshot run, and days marked before dirty days recorded their transaction, compare clock times. Paid rows are left out: runs never rewrite
+// them, so a correction could not settle them and would be queued again every time.
+func (r *settlementRepository) LateArrivals(ctx context.Context, limit int) ([]LateArrival, error) {
+	var out []LateArrival
+	err := r.db.SelectContext(ctx, &out, `SELECT s.merchant_id, s.date, s.unique_run_id
+        FROM settlements s
+        LEFT JOIN jobs j ON j.id = s.unique_run_id
+        WHERE s.payout_id IS NULL
+          AND EXISTS (SELECT 1 FROM settlement_dirty d
+            WHERE d.merchant_id = s.merchant_id AND d.day BETWEEN s.date - 1 AND s.date
+              AND CASE WHEN d.xid IS NOT NULL AND NULLIF(j.snapshot, '') IS NOT NULL
+                       THEN NOT pg_visible_in_snapshot(d.xid, j.snapshot::pg_snapshot)
+                       ELSE d.marked_at > COALESCE(j.snapshot_at, s.generated_at) END)
+        ORDER BY s.date, s.merchant_id
+        LIMIT $1`, limit)
+	return out, err
+}
@@ -0,0 +1,258 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// runRows streams rows to WriteRun
+func runRows(rows ...SettlementRow) func(emit func(SettlementRunRow) error) error {
+	return func(emit func(SettlementRunRow) error) error {
+		for _, r := range rows {
+			if err := emit(SettlementRunRow{SettlementRow: r}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// TestWriteRunUpsertsAndCompletes writes a run over an existing row and checks that the rows
+// are merged and the job completed together
+func TestWriteRunUpsertsAndCompletes(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	const merchant, jobID = "m-writerun-test", "job_writerun_test"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM settlements WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM jobs WHERE id = $1`, jobID)
+	}
+	cleanup()
+	defer cleanup()
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2025, 1, 2, 0, 0, 0, 0, time.UTC)
+	jobs := NewJobRepository(db)
+	if err := jobs.Create(ctx, jobID, JobTypeSettlement, 0, from, to, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.SetRunning(ctx, jobID); err != nil {
+		t.Fatal(err)
+	}
+	st := NewSettlementRepository(db)
+	if err := st.Upsert(ctx, SettlementRow{MerchantID: merchant, Date: "2025-01-01", GrossCents: 1, NetCents: 1, PayableCents: 1, TxnCount: 1, Cutoff: "00:00:00", RunID: "old"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows := []SettlementRow{
+		{MerchantID: merchant, Date: "2025-01-01", GrossCents: 1000, FeeCents: 30, NetCents: 970, PayableCents: 970, TxnCount: 1, Cutoff: "00:00:00", RunID: jobID},
+		{MerchantID: merchant, Date: "2025-01-02", GrossCents: 2000, FeeCents: 60, NetCents: 1940, PayableCents: 1940, TxnCount: 2, Cutoff: "17:00:00", RunID: jobID},
+	}
+	run := SettlementRun{JobID: jobID, ResultPath: "out.csv", From: from, To: to}
+	if err := st.WriteRun(ctx, run, runRows(rows...)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := st.ListByMerchant(ctx, merchant, from, to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].NetCents != 970 || got[0].UniqueRunID != jobID || got[1].TxnCount != 2 {
+		t.Fatalf("unexpected settlements: %+v", got)
+	}
+	job, err := jobs.Get(ctx, jobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if job.Status != JobStatusCompleted {
+		t.Fatalf("job status = %s, want %s", job.Status, JobStatusCompleted)
+	}
+
+	// a job that is no longer running writes nothing
+	late := runRows(SettlementRow{MerchantID: merchant, Date: "2025-01-02", NetCents: 1, PayableCents: 1, Cutoff: "00:00:00", RunID: jobID})
+	if err := st.WriteRun(ctx, run, late); !errors.Is(err, ErrJobNotRunning) {
+		t.Fatalf("WriteRun on a completed job = %v, want ErrJobNotRunning", err)
+	}
+	if got, err = st.ListByMerchant(ctx, merchant, to, to); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].NetCents != 1940 {
+		t.Fatalf("settlement written by a finished job: %+v", got)
+	}
+}
+
+// TestWriteRunSkipsPaidDays checks that a run leaves a paid day's row, its held reserve and the
+// adjustments effective on it as they were, while an unpaid day gets all three
+func TestWriteRunSkipsPaidDays(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	const merchant, jobID, payoutJobID = "m-writerun-paid", "job_writerun_paid", "job_writerun_paid_payout"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM settlements WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM payouts WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM reserves WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM adjustments WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM jobs WHERE id IN ($1, $2)`, jobID, payoutJobID)
+	}
+	cleanup()
+	defer cleanup()
+
+	day1 := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	day2 := day1.AddDate(0, 0, 1)
+	jobs := NewJobRepository(db)
+	if err := jobs.Create(ctx, payoutJobID, JobTypePayout, 1, day1, day1, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	st := NewSettlementRepository(db)
+	if err := st.Upsert(ctx, SettlementRow{MerchantID: merchant, Date: "2025-01-01", GrossCents: 1000, NetCents: 1000, ReservedCents: 100, PayableCents: 900, TxnCount: 1, Cutoff: "00:00:00", RunID: "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec(`INSERT INTO reserves (merchant_id, settlement_date, amount_cents, release_date, settlement_run_id) VALUES ($1, '2025-01-01', 100, '2025-01-31', 'old')`, merchant); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := NewPayoutRepository(db).CollectUnpaid(ctx, payoutJobID, merchant, day1, day1); err != nil || p == nil {
+		t.Fatalf("CollectUnpaid = %+v, %v", p, err)
+	}
+	var adjIDs []int64
+	for _, d := range []string{"2025-01-01", "2025-01-02"} {
+		var id int64
+		if err := db.Get(&id, `INSERT INTO adjustments (merchant_id, amount_cents, effective_date, reason, status, proposed_by, reviewed_by)
+            VALUES ($1, 50, $2, 'test', 'APPROVED', 'a', 'b') RETURNING id`, merchant, d); err != nil {
+			t.Fatal(err)
+		}
+		adjIDs = append(adjIDs, id)
+	}
+
+	if err := jobs.Create(ctx, jobID, JobTypeSettlement, 0, day1, day2, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.SetRunning(ctx, jobID); err != nil {
+		t.Fatal(err)
+	}
+	rows := func(emit func(SettlementRunRow) error) error {
+		for _, r := range []SettlementRunRow{
+			{SettlementRow: SettlementRow{MerchantID: merchant, Date: "2025-01-01", GrossCents: 3000, AdjustmentCents: 50, NetCents: 3050, ReservedCents: 305, PayableCents: 2745, TxnCount: 2, Cutoff: "00:00:00", RunID: jobID}, ReleaseDate: "2025-01-31"},
+			{SettlementRow: SettlementRow{MerchantID: merchant, Date: "2025-01-02", GrossCents: 2000, AdjustmentCents: 50, NetCents: 2050, ReservedCents: 205, PayableCents: 1845, TxnCount: 1, Cutoff: "00:00:00", RunID: jobID}, ReleaseDate: "2025-02-01"},
+		} {
+			if err := emit(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	run := SettlementRun{JobID: jobID, ResultPath: "out.csv", From: day1, To: day2, AdjustmentIDs: adjIDs}
+	if err := st.WriteRun(ctx, run, rows); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := st.ListByMerchant(ctx, merchant, day1, day2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].PayableCents != 900 || got[0].UniqueRunID != "old" || got[1].UniqueRunID != jobID {
+		t.Fatalf("settlements = %+v", got)
+	}
+	var reserves []struct {
+		Date   string `db:"settlement_date"`
+		Amount int64  `db:"amount_cents"`
+		RunID  string `db:"settlement_run_id"`
+	}
+	if err := db.Select(&reserves, `SELECT settlement_date::text, amount_cents, settlement_run_id FROM reserves WHERE merchant_id = $1 ORDER BY settlement_date`, merchant); err != nil {
+		t.Fatal(err)
+	}
+	if len(reserves) != 2 || reserves[0].Amount != 100 || reserves[0].RunID != "old" || reserves[1].Amount != 205 {
+		t.Fatalf("reserves = %+v", reserves)
+	}
+	var settledBy []sql.NullString
+	if err := db.Select(&settledBy, `SELECT settlement_run_id FROM adjustments WHERE id = ANY($1) ORDER BY effective_date`, pq.Array(adjIDs)); err != nil {
+		t.Fatal(err)
+	}
+	if len(settledBy) != 2 || settledBy[0].Valid || settledBy[1].String != jobID {
+		t.Fatalf("adjustments settled by %+v", settledBy)
+	}
+}
+
+// TestLateArrivalsSinceSnapshot checks that a change the run's snapshot did not see is reported as
+// late, even when it was marked before the snapshot was taken, until the row is paid
+func TestLateArrivalsSinceSnapshot(t *testing.T) {
+	db := setupTestDB(t)
+	ctx := context.Background()
+	const merchant, jobID, payoutJobID = "m-late-test", "job_late_test", "job_late_test_payout"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM settlements WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM payouts WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM settlement_dirty WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM jobs WHERE id IN ($1, $2)`, jobID, payoutJobID)
+	}
+	cleanup()
+	defer cleanup()
+
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	jobs := NewJobRepository(db)
+	if err := jobs.Create(ctx, jobID, JobTypeSettlement, 0, day, day, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.SetRunning(ctx, jobID); err != nil {
+		t.Fatal(err)
+	}
+	// the day is marked by a transaction that commits only after the snapshot was taken
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tx.Rollback() }()
+	if _, err := tx.Exec(`INSERT INTO settlement_dirty (merchant_id, day, marked_at) VALUES ($1, '2025-01-01', now() - interval '1 hour')`, merchant); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := BeginSnapshot(ctx, db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jobs.SetSnapshot(ctx, jobID, snap.At, snap.Txs, 1); err != nil {
+		t.Fatal(err)
+	}
+	_ = snap.Close()
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	st := NewSettlementRepository(db)
+	row := SettlementRow{MerchantID: merchant, Date: "2025-01-01", GrossCents: 1000, FeeCents: 30, NetCents: 970, PayableCents: 970, TxnCount: 1, Cutoff: "00:00:00", RunID: jobID}
+	if err := st.WriteRun(ctx, SettlementRun{JobID: jobID, ResultPath: "out.csv", From: day, To: day}, runRows(row)); err != nil {
+		t.Fatal(err)
+	}
+
+	isLate := func() bool {
+		late, err := st.LateArrivals(ctx, 1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range late {
+			if l.MerchantID == merchant {
+				return true
+			}
+		}
+		return false
+	}
+	if !isLate() {
+		t.Fatalf("%s not reported as a late arrival", merchant)
+	}
+
+	// a paid row is never rewritten, so it must not keep queueing corrections
+	if err := jobs.Create(ctx, payoutJobID, JobTypePayout, 1, day, day, nil, ""); err != nil {
+		t.Fatal(err)
+	}
+	if p, err := NewPayoutRepository(db).CollectUnpaid(ctx, payoutJobID, merchant, day, day); err != nil || p == nil {
+		t.Fatalf("CollectUnpaid = %+v, %v", p, err)
+	}
+	if isLate() {
+		t.Fatalf("paid row of %s reported as a late arrival", merchant)
+	}
+}
@@ -0,0 +1,87 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// Snapshot is a read-only REPEATABLE READ transaction whose snapshot is exported, so reads on other
+// connections can see exactly the same data. Repository reads made with a context from Context or
+// Fork run in the snapshot; writes never do.
+type Snapshot struct {
+	db *sqlx.DB
+	tx *sqlx.Tx
+
+	ID  string    // pg_export_snapshot() id; only importable while the snapshot is open
+	At  time.Time // when the snapshot's transaction started
+	Txs string    // pg_current_snapshot() as xmin:xmax:xip_list, which identifies the visible transactions
+}
+
+// snapshotReads is the context value that routes reads to a snapshot transaction
+type snapshotReads struct {
+	snap *Snapshot
+	tx   *sqlx.Tx
+}
+
+type snapshotKey struct{}
+
+// BeginSnapshot opens and exports a snapshot; Close releases it
+func BeginSnapshot(ctx context.Context, db *sqlx.DB) (*Snapshot, error) {
+	tx, err := db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{db: db, tx: tx}
+	if err := tx.QueryRowContext(ctx, `SELECT pg_export_snapshot(), now(), pg_current_snapshot()::text`).Scan(&s.ID, &s.At, &s.Txs); err != nil {
+		_ = tx.Rollback()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Context returns ctx with repository reads routed to the snapshot's own transaction.
+// That transaction is one connection, so reads with this context must not run concurrently.
+func (s *Snapshot) Context(ctx context.Context) context.Context {
+	return context.WithValue(ctx, snapshotKey{}, snapshotReads{snap: s, tx: s.tx})
+}
+
+// Close ends the snapshot's transaction
+func (s *Snapshot) Close() error { return s.tx.Rollback() }
+
+// ForkSnapshot returns a context whose reads run in a new transaction importing the snapshot of ctx,
+// for reads that run concurrently with others. Without a snapshot in ctx it returns ctx as is.
+// The returned func ends the forked transaction.
+func ForkSnapshot(ctx context.Context) (context.Context, func(), error) {
+	sr, ok := ctx.Value(snapshotKey{}).(snapshotReads)
+	if !ok {
+		return ctx, func() {}, nil
+	}
+	tx, err := sr.snap.db.BeginTxx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `SET TRANSACTION SNAPSHOT `+pq.QuoteLiteral(sr.snap.ID)); err != nil {
+		_ = tx.Rollback()
+		return nil, nil, err
+	}
+	return context.WithValue(ctx, snapshotKey{}, snapshotReads{snap: sr.snap, tx: tx}), func() { _ = tx.Rollback() }, nil
+}
+
+// reader is what repository reads need from *sqlx.DB or *sqlx.Tx
+type reader interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+	QueryxContext(ctx context.Context, query string, args ...any) (*sqlx.Rows, error)
+	SelectContext(ctx context.Context, dest any, query string, args ...any) error
+}
+
+// readerFor returns the snapshot transaction of ctx, or db when there is none
+func readerFor(ctx context.Context, db *sqlx.DB) reader {
+	if sr, ok := ctx.Value(snapshotKey{}).(snapshotReads); ok {
+		return sr.tx
+	}
+	return db
+}
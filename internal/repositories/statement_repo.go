@@ -0,0 +1,62 @@
+package repositories
+
+import (
+	"context"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// StatementEntryRow is one booked movement on a merchant account: settlement net is credited
+// on its settlement date, settled adjustments are booked separately on their effective date and
+// confirmed payouts are debited on their confirmation date
+type StatementEntryRow struct {
+	BookingDate time.Time `db:"booking_date"`
+	AmountCents int64     `db:"amount_cents"`
+	Kind        string    `db:"kind"`
+	Reference   string    `db:"reference"`
+	Description string    `db:"description"`
+}
+
+// accountActivity selects every movement of merchant $1 as StatementEntryRow columns
+const accountActivity = `
+        SELECT date AS booking_date, net_cents - adjustment_cents AS amount_cents, 'SETTLEMENT' AS kind,
+               'STL-' || to_char(date, 'YYYYMMDD') AS reference,
+               'Settlement ' || to_char(date, 'YYYY-MM-DD') || ', ' || txn_count || ' transactions' AS description
+        FROM settlements WHERE merchant_id = $1
+        UNION ALL
+        SELECT effective_date, amount_cents, 'ADJUSTMENT',
+               'ADJ-' || id,
+               'Adjustment ' || id || ': ' || reason
+        FROM adjustments WHERE merchant_id = $1 AND status = 'APPROVED' AND settlement_run_id IS NOT NULL
+        UNION ALL
+        SELECT (confirmed_at AT TIME ZONE 'UTC')::date, -amount_cents, 'PAYOUT',
+               'PAYOUT-' || id,
+               'Payout ' || id || COALESCE(' bank reference ' || bank_reference, '')
+        FROM payouts WHERE merchant_id = $1 AND status = 'CONFIRMED'`
+
+type StatementRepository interface {
+	OpeningBalance(ctx context.Context, merchantID string, from time.Time) (int64, error)
+	Entries(ctx context.Context, merchantID string, from, to time.Time) ([]StatementEntryRow, error)
+}
+
+type statementRepository struct{ db *sqlx.DB }
+
+func NewStatementRepository(db *sqlx.DB) StatementRepository { return &statementRepository{db: db} }
+
+// OpeningBalance sums every movement booked before from
+func (r *statementRepository) OpeningBalance(ctx context.Context, merchantID string, from time.Time) (int64, error) {
+	var balance int64
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(amount_cents), 0) FROM (`+accountActivity+`) a
+        WHERE booking_date < $2::date`, merchantID, from.Format("2006-01-02")).Scan(&balance)
+	return balance, err
+}
+
+// Entries returns the movements booked in date range (inclusive), ordered by date
+func (r *statementRepository) Entries(ctx context.Context, merchantID string, from, to time.Time) ([]StatementEntryRow, error) {
+	var rows []StatementEntryRow
+	err := r.db.SelectContext(ctx, &rows, `SELECT booking_date, amount_cents, kind, reference, description FROM (`+accountActivity+`) a
+        WHERE booking_date BETWEEN $2::date AND $3::date
+        ORDER BY booking_date, kind DESC, reference`, merchantID, from.Format("2006-01-02"), to.Format("2006-01-02"))
+	return rows, err
+}
@@ -6,20 +6,56 @@ import (
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type TransactionRow struct {
-	ID          int64     `db:"id"`
-	MerchantID  string    `db:"merchant_id"`
-	AmountCents int64     `db:"amount_cents"`
-	FeeCents    int64     `db:"fee_cents"`
-	Status      string    `db:"status"`
-	PaidAt      time.Time `db:"paid_at"`
+	ID            int64     `db:"id"`
+	MerchantID    string    `db:"merchant_id"`
+	AmountCents   int64     `db:"amount_cents"`
+	FeeCents      int64     `db:"fee_cents"`
+	Status        string    `db:"status"`
+	PaidAt        time.Time `db:"paid_at"`
+	Timezone      string    `db:"timezone"`
+	CutoffSeconds int64     `db:"cutoff_seconds"` // effective cut-off, seconds after local midnight
+}
+
+// SettlementTotalRow is the sum of one merchant/day's PAID transactions, aggregated in Postgres
+type SettlementTotalRow struct {
+	MerchantID    string `db:"merchant_id"`
+	Date          string `db:"date"`
+	GrossCents    int64  `db:"gross_cents"`
+	FeeCents      int64  `db:"fee_cents"`
+	TxnCount      int64  `db:"txn_count"`
+	CutoffSeconds int64  `db:"cutoff_seconds"` // effective cut-off, seconds after local midnight
+}
+
+// paidInRange selects PAID transactions whose settlement day falls within [$3, $4].
+// The settlement day follows the merchant's timezone and cut-off ($5 when the merchant has none).
+// The paid_at bounds ($1, $2) are a coarse UTC window so the paid_at index can still be used;
+// timezone offsets and cut-offs shift a day by less than two days either way.
+const paidInRange = `t.status='PAID'
+            AND t.paid_at >= $1 AND t.paid_at < $2
+            AND settlement_date(t.paid_at, COALESCE(m.timezone, 'UTC'), COALESCE(m.cutoff, $5::time)) BETWEEN $3::date AND $4::date`
+
+// rangeArgs returns the query arguments for paidInRange
+func rangeArgs(from, to time.Time, cutoff time.Duration) []any {
+	day := func(t time.Time) string { return t.Format("2006-01-02") }
+	return []any{from.Add(-48 * time.Hour), to.Add(48 * time.Hour), day(from), day(to), FormatCutoff(cutoff)}
+}
+
+// FormatCutoff renders a time-of-day offset as a Postgres TIME literal
+func FormatCutoff(d time.Duration) string {
+	return time.Time{}.Add(d).Format("15:04:05")
 }
 
 type TransactionRepository interface {
-	CountInRange(ctx context.Context, from, to time.Time) (int64, error)
-	StreamBatches(ctx context.Context, from, to time.Time, batchSize int, fn func([]TransactionRow) error) error
+	CountInRange(ctx context.Context, from, to time.Time, cutoff time.Duration) (int64, error)
+	StreamBatches(ctx context.Context, from, to time.Time, cutoff time.Duration, batchSize int, fn func([]TransactionRow) error) error
+	StreamKeys(ctx context.Context, keys []SettlementKey, cutoff time.Duration, keysPerBatch int, fn func([]TransactionRow) error) error
+	AggregateDays(ctx context.Context, from, to time.Time, cutoff time.Duration, fn func([]SettlementTotalRow) error) error
+	AggregateKeys(ctx context.Context, keys []SettlementKey, cutoff time.Duration, keysPerBatch int, fn func([]SettlementTotalRow) error) error
+	BeginSnapshot(ctx context.Context) (*Snapshot, error)
 }
 
 type transactionRepository struct{ db *sqlx.DB }
@@ -28,25 +64,35 @@ func NewTransactionRepository(db *sqlx.DB) TransactionRepository {
 	return &transactionRepository{db: db}
 }
 
-// Count in date range (inclusive)
-func (r *transactionRepository) CountInRange(ctx context.Context, from, to time.Time) (int64, error) {
+// BeginSnapshot opens a snapshot that settlement reads can run in; see Snapshot
+func (r *transactionRepository) BeginSnapshot(ctx context.Context) (*Snapshot, error) {
+	return BeginSnapshot(ctx, r.db)
+}
+
+// Count in settlement date range (inclusive); cutoff applies to merchants without their own
+func (r *transactionRepository) CountInRange(ctx context.Context, from, to time.Time, cutoff time.Duration) (int64, error) {
 	var cnt int64
-	err := r.db.QueryRowContext(ctx, `SELECT COUNT(1) FROM transactions WHERE paid_at >= $1 AND paid_at < $2 AND status='PAID'`, from, to.Add(24*time.Hour)).Scan(&cnt)
+	err := readerFor(ctx, r.db).QueryRowContext(ctx, `SELECT COUNT(1) FROM transactions t
+            LEFT JOIN merchants m ON m.id = t.merchant_id
+            WHERE `+paidInRange, rangeArgs(from, to, cutoff)...).Scan(&cnt)
 	return cnt, err
 }
 
 // StreamBatches yields transactions in batches via callback to avoid loading all in memory
-func (r *transactionRepository) StreamBatches(ctx context.Context, from, to time.Time, batchSize int, fn func([]TransactionRow) error) error {
+func (r *transactionRepository) StreamBatches(ctx context.Context, from, to time.Time, cutoff time.Duration, batchSize int, fn func([]TransactionRow) error) error {
 	var lastID int64 = 0
-	end := to.Add(24 * time.Hour)
+	args := rangeArgs(from, to, cutoff)
 	for {
 		log.Printf("Fetching transaction row from id: %d limit: %d\n", lastID, batchSize)
-		log.Printf("Streaming from %v to %v (end=%v)\n", from, to, end)
-		rows, err := r.db.QueryxContext(ctx, `SELECT id, merchant_id, amount_cents, fee_cents, status, paid_at 
-            FROM transactions 
-            WHERE paid_at >= $1 AND paid_at < $2 AND status='PAID' AND id > $3 
-            ORDER BY id ASC 
-            LIMIT $4`, from, end, lastID, batchSize)
+		log.Printf("Streaming from %v to %v\n", from, to)
+		rows, err := readerFor(ctx, r.db).QueryxContext(ctx, `SELECT t.id, t.merchant_id, t.amount_cents, t.fee_cents, t.status, t.paid_at,
+                COALESCE(m.timezone, 'UTC') AS timezone,
+                EXTRACT(EPOCH FROM COALESCE(m.cutoff, $5::time))::bigint AS cutoff_seconds
+            FROM transactions t
+            LEFT JOIN merchants m ON m.id = t.merchant_id
+            WHERE `+paidInRange+` AND t.id > $6
+            ORDER BY t.id ASC
+            LIMIT $7`, append(args, lastID, batchSize)...)
 		if err != nil {
 			log.Println("Error querying transactions:", err)
 			return err
@@ -77,3 +123,76 @@ func (r *transactionRepository) StreamBatches(ctx context.Context, from, to time
 		}
 	}
 }
+
+// StreamKeys yields the PAID transactions settling on the given merchant/days, keysPerBatch keys at a time
+func (r *transactionRepository) StreamKeys(ctx context.Context, keys []SettlementKey, cutoff time.Duration, keysPerBatch int, fn func([]TransactionRow) error) error {
+	for start := 0; start < len(keys); start += keysPerBatch {
+		merchants, dates := splitKeys(keys[start:min(start+keysPerBatch, len(keys))])
+		var batch []TransactionRow
+		// the paid_at window around each day keeps the merchant/paid_at index usable
+		if err := readerFor(ctx, r.db).SelectContext(ctx, &batch, `SELECT t.id, t.merchant_id, t.amount_cents, t.fee_cents, t.status, t.paid_at,
+                COALESCE(m.timezone, 'UTC') AS timezone,
+                EXTRACT(EPOCH FROM COALESCE(m.cutoff, $3::time))::bigint AS cutoff_seconds
+            FROM unnest($1::text[], $2::date[]) AS k(merchant_id, day)
+            JOIN transactions t ON t.merchant_id = k.merchant_id
+                AND t.paid_at >= k.day - INTERVAL '2 days' AND t.paid_at < k.day + INTERVAL '3 days'
+            LEFT JOIN merchants m ON m.id = t.merchant_id
+            WHERE t.status = 'PAID'
+              AND settlement_date(t.paid_at, COALESCE(m.timezone, 'UTC'), COALESCE(m.cutoff, $3::time)) = k.day
+            ORDER BY t.id`, pq.Array(merchants), pq.Array(dates), FormatCutoff(cutoff)); err != nil {
+			return err
+		}
+		if err := fn(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AggregateDays sums PAID transactions per merchant and settlement date in Postgres, one settlement
+// date (inclusive range) per query, and yields each date's rows via callback
+func (r *transactionRepository) AggregateDays(ctx context.Context, from, to time.Time, cutoff time.Duration, fn func([]SettlementTotalRow) error) error {
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		var rows []SettlementTotalRow
+		if err := readerFor(ctx, r.db).SelectContext(ctx, &rows, `SELECT t.merchant_id, to_char($4::date, 'YYYY-MM-DD') AS date,
+                SUM(t.amount_cents)::bigint AS gross_cents, SUM(t.fee_cents)::bigint AS fee_cents, COUNT(*) AS txn_count,
+                EXTRACT(EPOCH FROM COALESCE(m.cutoff, $5::time))::bigint AS cutoff_seconds
+            FROM transactions t
+            LEFT JOIN merchants m ON m.id = t.merchant_id
+            WHERE `+paidInRange+`
+            GROUP BY t.merchant_id, m.cutoff
+            ORDER BY t.merchant_id`, rangeArgs(day, day, cutoff)...); err != nil {
+			return err
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AggregateKeys sums the PAID transactions settling on the given merchant/days in Postgres,
+// keysPerBatch keys at a time
+func (r *transactionRepository) AggregateKeys(ctx context.Context, keys []SettlementKey, cutoff time.Duration, keysPerBatch int, fn func([]SettlementTotalRow) error) error {
+	for start := 0; start < len(keys); start += keysPerBatch {
+		merchants, dates := splitKeys(keys[start:min(start+keysPerBatch, len(keys))])
+		var rows []SettlementTotalRow
+		if err := readerFor(ctx, r.db).SelectContext(ctx, &rows, `SELECT k.merchant_id, to_char(k.day, 'YYYY-MM-DD') AS date,
+                SUM(t.amount_cents)::bigint AS gross_cents, SUM(t.fee_cents)::bigint AS fee_cents, COUNT(*) AS txn_count,
+                EXTRACT(EPOCH FROM COALESCE(m.cutoff, $3::time))::bigint AS cutoff_seconds
+            FROM unnest($1::text[], $2::date[]) AS k(merchant_id, day)
+            JOIN transactions t ON t.merchant_id = k.merchant_id
+                AND t.paid_at >= k.day - INTERVAL '2 days' AND t.paid_at < k.day + INTERVAL '3 days'
+            LEFT JOIN merchants m ON m.id = t.merchant_id
+            WHERE t.status = 'PAID'
+              AND settlement_date(t.paid_at, COALESCE(m.timezone, 'UTC'), COALESCE(m.cutoff, $3::time)) = k.day
+            GROUP BY k.merchant_id, k.day, m.cutoff
+            ORDER BY k.merchant_id, k.day`, pq.Array(merchants), pq.Array(dates), FormatCutoff(cutoff)); err != nil {
+			return err
+		}
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package repositories
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// TestCountInRangeUsesMerchantTimezone checks that range selection follows the
+// merchant's local day across the New York spring-forward transition.
+func TestCountInRangeUsesMerchantTimezone(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewTransactionRepository(db)
+	ctx := context.Background()
+	const merchant = "m-tz-test"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM transactions WHERE merchant_id = $1`, merchant)
+		_, _ = db.Exec(`DELETE FROM merchants WHERE id = $1`, merchant)
+	}
+	cleanup()
+	defer cleanup()
+
+	if _, err := db.Exec(`INSERT INTO merchants (id, timezone) VALUES ($1, 'America/New_York')`, merchant); err != nil {
+		t.Fatal(err)
+	}
+	// 2025-03-09 local runs from 05:00Z to 04:00Z the next day (23 hours)
+	paid := []string{
+		"2025-03-09T04:59:59Z", // 2025-03-08 local
+		"2025-03-09T05:00:00Z", // 2025-03-09 local
+		"2025-03-10T03:59:59Z", // 2025-03-09 local
+		"2025-03-10T04:00:00Z", // 2025-03-10 local
+	}
+	for _, p := range paid {
+		if _, err := db.Exec(`INSERT INTO transactions (merchant_id, amount_cents, fee_cents, status, paid_at) VALUES ($1, 1000, 30, 'PAID', $2)`, merchant, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	day := time.Date(2025, 3, 9, 0, 0, 0, 0, time.UTC)
+	var got int64
+	err := repo.StreamBatches(ctx, day, day, 0, 10, func(rows []TransactionRow) error {
+		for _, r := range rows {
+			if r.MerchantID == merchant {
+				got++
+				if r.Timezone != "America/New_York" {
+					t.Errorf("expected merchant timezone on row, got %q", r.Timezone)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 2 {
+		t.Fatalf("expected 2 transactions on local day 2025-03-09, got %d", got)
+	}
+}
+
+// TestSnapshotHidesLaterInserts checks that reads in a snapshot, and in a fork of it, do not see
+// transactions committed after the snapshot was taken.
+func TestSnapshotHidesLaterInserts(t *testing.T) {
+	db := setupTestDB(t)
+	repo := NewTransactionRepository(db)
+	ctx := context.Background()
+	const merchant = "m-snapshot-test"
+
+	cleanup := func() {
+		_, _ = db.Exec(`DELETE FROM transactions WHERE merchant_id = $1`, merchant)
+	}
+	cleanup()
+	defer cleanup()
+
+	day := time.Date(2031, 6, 1, 0, 0, 0, 0, time.UTC)
+	insert := func() {
+		if _, err := db.Exec(`INSERT INTO transactions (merchant_id, amount_cents, fee_cents, status, paid_at) VALUES ($1, 1000, 30, 'PAID', '2031-06-01T12:00:00Z')`, merchant); err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert()
+
+	snap, err := repo.BeginSnapshot(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = snap.Close() }()
+	if snap.ID == "" || snap.Txs == "" {
+		t.Fatalf("snapshot not recorded: %+v", snap)
+	}
+	insert()
+
+	count := func(ctx context.Context) int64 {
+		n, err := repo.CountInRange(ctx, day, day, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+	if got := count(snap.Context(ctx)); got != 1 {
+		t.Errorf("snapshot count = %d, want 1", got)
+	}
+	forked, done, err := ForkSnapshot(snap.Context(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	if got := count(forked); got != 1 {
+		t.Errorf("forked snapshot count = %d, want 1", got)
+	}
+	if got := count(ctx); got != 2 {
+		t.Errorf("count outside snapshot = %d, want 2", got)
+	}
+}
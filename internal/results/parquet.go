@@ -0,0 +1,91 @@
+package results
+
+import (
+	"io"
+	"reflect"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// parquetFormat writes an Apache Parquet file of REQUIRED INT64 and UTF8 string columns with
+// parquet-go. Rows are buffered until a row group fills and the group is then written out, so a
+// writer holds at most one row group; the footer lists the row groups on Close.
+type parquetFormat struct{}
+
+func (parquetFormat) Name() string        { return "parquet" }
+func (parquetFormat) Extension() string   { return ".parquet" }
+func (parquetFormat) ContentType() string { return "application/vnd.apache.parquet" }
+
+// parquetRowGroupRows is how many rows a row group holds before it is written out
+const parquetRowGroupRows = 64 << 10
+
+type parquetWriter struct {
+	cols []Column
+	pw   *parquet.Writer
+	row  []parquet.Row
+}
+
+func (parquetFormat) NewWriter(w io.Writer, cols []Column) (ResultWriter, error) {
+	return newParquetWriter(w, cols, parquetRowGroupRows), nil
+}
+
+func newParquetWriter(w io.Writer, cols []Column, groupRows int64) *parquetWriter {
+	schema := parquet.NewSchema("schema", newParquetColumns(cols))
+	pw := parquet.NewWriter(w, schema, parquet.MaxRowsPerRowGroup(groupRows), parquet.CreatedBy("be settlement results", "", ""))
+	return &parquetWriter{cols: cols, pw: pw, row: make([]parquet.Row, 1)}
+}
+
+func (w *parquetWriter) WriteRow(r Row) error {
+	if err := checkRow(w.cols, r); err != nil {
+		return err
+	}
+	row := w.row[0][:0]
+	for i, v := range r {
+		switch v := v.(type) {
+		case int64:
+			row = append(row, parquet.Int64Value(v).Level(0, 0, i))
+		case string:
+			row = append(row, parquet.ByteArrayValue([]byte(v)).Level(0, 0, i))
+		}
+	}
+	w.row[0] = row
+	_, err := w.pw.WriteRows(w.row)
+	return err
+}
+
+// Close writes the last row group and the footer
+func (w *parquetWriter) Close() error { return w.pw.Close() }
+
+// parquetColumns is the schema root. parquet.Group orders its fields by name, so the fields are
+// kept here in the order of the result's columns.
+type parquetColumns struct {
+	parquet.Group
+	fields []parquet.Field
+}
+
+func newParquetColumns(cols []Column) parquetColumns {
+	g := parquetColumns{Group: parquet.Group{}}
+	for _, c := range cols {
+		node := parquet.String()
+		if c.Int {
+			node = parquet.Int(64)
+		}
+		g.Group[c.Name] = node
+		g.fields = append(g.fields, parquetColumn{Node: node, name: c.Name})
+	}
+	return g
+}
+
+func (g parquetColumns) Fields() []parquet.Field { return g.fields }
+
+// parquetColumn is a field of parquetColumns
+type parquetColumn struct {
+	parquet.Node
+	name string
+}
+
+func (c parquetColumn) Name() string { return c.name }
+
+func (c parquetColumn) Value(base reflect.Value) reflect.Value {
+	return base.MapIndex(reflect.ValueOf(c.name))
+}
@@ -0,0 +1,135 @@
+package results
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/xuri/excelize/v2"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// TestGolden compares written files with the ones in testdata; the reader tests below check the same
+// output with parquet-go and excelize, so a golden file is only rewritten (-update) once they pass
+func TestGolden(t *testing.T) {
+	for _, format := range []string{"parquet", "xlsx"} {
+		t.Run(format, func(t *testing.T) {
+			got := write(t, format)
+			golden := filepath.Join("testdata", "results."+format)
+			if *update {
+				if err := os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("%s differs from %s; rerun with -update after checking it with a reader", format, golden)
+			}
+		})
+	}
+}
+
+// readParquet reads every row group of a file with parquet-go, one line per row
+func readParquet(t *testing.T, data []byte) (groups int, rows []string) {
+	t.Helper()
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rg := range f.RowGroups() {
+		rr := rg.Rows()
+		buf := make([]parquet.Row, 16)
+		for {
+			n, err := rr.ReadRows(buf)
+			for _, r := range buf[:n] {
+				var vals []string
+				for _, v := range r {
+					if v.Kind() == parquet.Int64 {
+						vals = append(vals, fmt.Sprint(v.Int64()))
+					} else {
+						vals = append(vals, v.String())
+					}
+				}
+				rows = append(rows, strings.Join(vals, ","))
+			}
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		_ = rr.Close()
+	}
+	return len(f.RowGroups()), rows
+}
+
+func TestParquetReader(t *testing.T) {
+	data := write(t, "parquet")
+	f, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range f.Schema().Fields() {
+		names = append(names, c.Name())
+	}
+	if got := strings.Join(names, ","); got != "merchant_id,date,net" {
+		t.Fatalf("columns = %s, want the result's column order", got)
+	}
+	groups, rows := readParquet(t, data)
+	if want := "m-001,2025-01-01,970 m-002,2025-01-01,-5"; groups != 1 || strings.Join(rows, " ") != want {
+		t.Fatalf("groups = %d, rows = %v, want %s", groups, rows, want)
+	}
+}
+
+// TestParquetRowGroups writes more rows than a row group holds and reads them back
+func TestParquetRowGroups(t *testing.T) {
+	for _, n := range []int{0, 4, 5} {
+		var buf bytes.Buffer
+		w := newParquetWriter(&buf, testCols, 2)
+		var want []string
+		for i := 0; i < n; i++ {
+			if err := w.WriteRow(Row{fmt.Sprintf("m-%03d", i), "2025-01-01", int64(i)}); err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, fmt.Sprintf("m-%03d,2025-01-01,%d", i, i))
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		groups, rows := readParquet(t, buf.Bytes())
+		if groups != (n+1)/2 || strings.Join(rows, " ") != strings.Join(want, " ") {
+			t.Fatalf("%d rows: groups = %d, rows = %v", n, groups, rows)
+		}
+	}
+}
+
+func TestXLSXReader(t *testing.T) {
+	f, err := excelize.OpenReader(bytes.NewReader(write(t, "xlsx")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	rows, err := f.GetRows("Results")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(rows); got != "[[merchant_id date net] [m-001 2025-01-01 970] [m-002 2025-01-01 -5]]" {
+		t.Fatalf("rows = %s", got)
+	}
+	if typ, _ := f.GetCellType("Results", "C2"); typ != excelize.CellTypeUnset && typ != excelize.CellTypeNumber {
+		t.Errorf("C2 type = %v, want a number", typ)
+	}
+}
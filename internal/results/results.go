@@ -0,0 +1,106 @@
+// Package results writes job result tables in the file formats a job can produce.
+package results
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Column describes one result column; Int columns hold int64 values, the others strings
+type Column struct {
+	Name string
+	Int  bool
+}
+
+// Row is one result row with a value per column, a string or an int64
+type Row []any
+
+// ResultWriter writes a table in one file format. The header is written when it is created,
+// rows are streamed with WriteRow, and the file is complete after Close, which does not close
+// the underlying writer.
+type ResultWriter interface {
+	WriteRow(Row) error
+	Close() error
+}
+
+// Format creates writers of one file format
+type Format interface {
+	Name() string
+	Extension() string
+	ContentType() string
+	NewWriter(w io.Writer, cols []Column) (ResultWriter, error)
+}
+
+var (
+	mu      sync.RWMutex
+	formats = map[string]Format{}
+)
+
+// Register makes a format available under its name and serves its extension with its content type
+func Register(f Format) {
+	mu.Lock()
+	defer mu.Unlock()
+	formats[f.Name()] = f
+	_ = mime.AddExtensionType(f.Extension(), f.ContentType())
+}
+
+// Get returns the format registered under name
+func Get(name string) (Format, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	f, ok := formats[name]
+	return f, ok
+}
+
+// Formats lists the registered format names
+func Formats() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make([]string, 0, len(formats))
+	for f := range formats {
+		out = append(out, f)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func init() {
+	Register(csvFormat{})
+	Register(ndjsonFormat{})
+	Register(parquetFormat{})
+	Register(xlsxFormat{})
+}
+
+// checkRow validates a row against the columns
+func checkRow(cols []Column, r Row) error {
+	if len(r) != len(cols) {
+		return fmt.Errorf("row has %d values, want %d", len(r), len(cols))
+	}
+	for i, c := range cols {
+		switch r[i].(type) {
+		case int64:
+			if !c.Int {
+				return fmt.Errorf("column %s: int64 value in a string column", c.Name)
+			}
+		case string:
+			if c.Int {
+				return fmt.Errorf("column %s: string value in an int column", c.Name)
+			}
+		default:
+			return fmt.Errorf("column %s: unsupported value %T", c.Name, r[i])
+		}
+	}
+	return nil
+}
+
+// text renders a value as the text formats write it
+func text(v any) string {
+	if n, ok := v.(int64); ok {
+		return strconv.FormatInt(n, 10)
+	}
+	return v.(string)
+}
@@ -0,0 +1,66 @@
+package results
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+var testCols = []Column{{Name: "merchant_id"}, {Name: "date"}, {Name: "net", Int: true}}
+
+var testRows = []Row{
+	{"m-001", "2025-01-01", int64(970)},
+	{"m-002", "2025-01-01", int64(-5)},
+}
+
+func write(t *testing.T, format string) []byte {
+	t.Helper()
+	f, ok := Get(format)
+	if !ok {
+		t.Fatalf("format %s not registered", format)
+	}
+	var buf bytes.Buffer
+	w, err := f.NewWriter(&buf, testCols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range testRows {
+		if err := w.WriteRow(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestTextFormats(t *testing.T) {
+	if got, want := string(write(t, "csv")), "merchant_id,date,net\nm-001,2025-01-01,970\nm-002,2025-01-01,-5\n"; got != want {
+		t.Errorf("csv = %q, want %q", got, want)
+	}
+	want := `{"merchant_id":"m-001","date":"2025-01-01","net":970}` + "\n" + `{"merchant_id":"m-002","date":"2025-01-01","net":-5}` + "\n"
+	if got := string(write(t, "ndjson")); got != want {
+		t.Errorf("ndjson = %q, want %q", got, want)
+	}
+}
+
+func TestRejectsMistypedRow(t *testing.T) {
+	f, _ := Get("csv")
+	w, err := f.NewWriter(io.Discard, testCols)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WriteRow(Row{"m-001", "2025-01-01", "970"}); err == nil {
+		t.Error("string in an int column was accepted")
+	}
+}
+
+// TestDeterministic checks that the binary formats give byte-identical files for the same rows
+func TestDeterministic(t *testing.T) {
+	for _, format := range []string{"parquet", "xlsx"} {
+		if !bytes.Equal(write(t, format), write(t, format)) {
+			t.Errorf("%s output is not deterministic", format)
+		}
+	}
+}
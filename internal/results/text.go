@@ -0,0 +1,94 @@
+package results
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+)
+
+type csvFormat struct{}
+
+func (csvFormat) Name() string        { return "csv" }
+func (csvFormat) Extension() string   { return ".csv" }
+func (csvFormat) ContentType() string { return "text/csv; charset=utf-8" }
+
+type csvWriter struct {
+	cols []Column
+	w    *csv.Writer
+	rec  []string
+}
+
+func (csvFormat) NewWriter(w io.Writer, cols []Column) (ResultWriter, error) {
+	cw := &csvWriter{cols: cols, w: csv.NewWriter(w), rec: make([]string, len(cols))}
+	for i, c := range cols {
+		cw.rec[i] = c.Name
+	}
+	if err := cw.w.Write(cw.rec); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *csvWriter) WriteRow(r Row) error {
+	if err := checkRow(cw.cols, r); err != nil {
+		return err
+	}
+	for i, v := range r {
+		cw.rec[i] = text(v)
+	}
+	return cw.w.Write(cw.rec)
+}
+
+func (cw *csvWriter) Close() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// ndjsonFormat writes one JSON object per row, with keys in column order
+type ndjsonFormat struct{}
+
+func (ndjsonFormat) Name() string        { return "ndjson" }
+func (ndjsonFormat) Extension() string   { return ".ndjson" }
+func (ndjsonFormat) ContentType() string { return "application/x-ndjson" }
+
+type ndjsonWriter struct {
+	cols []Column
+	keys [][]byte
+	w    *bufio.Writer
+}
+
+func (ndjsonFormat) NewWriter(w io.Writer, cols []Column) (ResultWriter, error) {
+	nw := &ndjsonWriter{cols: cols, w: bufio.NewWriter(w), keys: make([][]byte, len(cols))}
+	for i, c := range cols {
+		key, err := json.Marshal(c.Name)
+		if err != nil {
+			return nil, err
+		}
+		nw.keys[i] = key
+	}
+	return nw, nil
+}
+
+func (nw *ndjsonWriter) WriteRow(r Row) error {
+	if err := checkRow(nw.cols, r); err != nil {
+		return err
+	}
+	_ = nw.w.WriteByte('{')
+	for i, v := range r {
+		if i > 0 {
+			_ = nw.w.WriteByte(',')
+		}
+		_, _ = nw.w.Write(nw.keys[i])
+		_ = nw.w.WriteByte(':')
+		val, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, _ = nw.w.Write(val)
+	}
+	_, err := nw.w.WriteString("}\n")
+	return err
+}
+
+func (nw *ndjsonWriter) Close() error { return nw.w.Flush() }
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"be/internal/models"
+	"be/internal/repositories"
+)
+
+var ErrInvalidAdjustment = errors.New("INVALID_ADJUSTMENT")
+
+// AdjustmentProposal is a requested manual credit (positive) or debit (negative)
+type AdjustmentProposal struct {
+	MerchantID    string
+	AmountCents   int64
+	EffectiveDate time.Time
+	Reason        string
+}
+
+type AdjustmentService interface {
+	Propose(ctx context.Context, p AdjustmentProposal, user string) (*models.Adjustment, error)
+	Approve(ctx context.Context, id int64, user, note string) (*models.Adjustment, error)
+	Reject(ctx context.Context, id int64, user, note string) (*models.Adjustment, error)
+	Get(ctx context.Context, id int64) (*models.Adjustment, error)
+	List(ctx context.Context, merchantID, status string) ([]models.Adjustment, error)
+	Audit(ctx context.Context, id int64) ([]models.AuditEntry, error)
+}
+
+type adjustmentService struct {
+	repo  repositories.AdjustmentRepository
+	audit repositories.AuditRepository
+}
+
+func NewAdjustmentService(repo repositories.AdjustmentRepository, audit repositories.AuditRepository) AdjustmentService {
+	return &adjustmentService{repo: repo, audit: audit}
+}
+
+// Propose records a PROPOSED adjustment; it is settled only once another user approves it
+func (s *adjustmentService) Propose(ctx context.Context, p AdjustmentProposal, user string) (*models.Adjustment, error) {
+	if p.MerchantID == "" || p.AmountCents == 0 || p.EffectiveDate.IsZero() || strings.TrimSpace(p.Reason) == "" || user == "" {
+		return nil, ErrInvalidAdjustment
+	}
+	return s.repo.Create(ctx, &models.Adjustment{
+		MerchantID:    p.MerchantID,
+		AmountCents:   p.AmountCents,
+		EffectiveDate: p.EffectiveDate,
+		Reason:        strings.TrimSpace(p.Reason),
+		ProposedBy:    user,
+	})
+}
+
+func (s *adjustmentService) Approve(ctx context.Context, id int64, user, note string) (*models.Adjustment, error) {
+	return s.review(ctx, id, repositories.AdjustmentStatusApproved, user, note)
+}
+
+func (s *adjustmentService) Reject(ctx context.Context, id int64, user, note string) (*models.Adjustment, error) {
+	return s.review(ctx, id, repositories.AdjustmentStatusRejected, user, note)
+}
+
+func (s *adjustmentService) review(ctx context.Context, id int64, status, user, note string) (*models.Adjustment, error) {
+	if user == "" {
+		return nil, ErrInvalidAdjustment
+	}
+	return s.repo.Review(ctx, id, status, user, note)
+}
+
+func (s *adjustmentService) Get(ctx context.Context, id int64) (*models.Adjustment, error) {
+	return s.repo.Get(ctx, id)
+}
+
+func (s *adjustmentService) List(ctx context.Context, merchantID, status string) ([]models.Adjustment, error) {
+	return s.repo.List(ctx, merchantID, status)
+}
+
+// Audit returns the adjustment's audit trail
+func (s *adjustmentService) Audit(ctx context.Context, id int64) ([]models.AuditEntry, error) {
+	if _, err := s.repo.Get(ctx, id); err != nil {
+		return nil, err
+	}
+	return s.audit.List(ctx, repositories.AuditEntityAdjustment, strconv.FormatInt(id, 10))
+}
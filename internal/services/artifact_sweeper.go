@@ -0,0 +1,395 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"be/internal/bankfile"
+	"be/internal/repositories"
+	"be/internal/results"
+	"be/internal/storage"
+)
+
+var ErrInvalidRetention = errors.New("INVALID_RETENTION")
+
+// Artifact sweep modes
+const (
+	SweepModeManual    = "manual"    // started through the API
+	SweepModeScheduled = "scheduled" // queued by Watch
+)
+
+// Reasons an artifact is swept
+const (
+	SweepExpired   = "expired"    // a completed job's artifacts past its type's retention
+	SweepFailedJob = "failed_job" // left behind by a failed or cancelled job
+	SweepOrphan    = "orphan"     // no job row for it
+	SweepTemp      = "temp"       // a temp file left by a writer that died before finishing the artifact
+)
+
+// sweepGrace is how old an artifact without a job row or a temp file, or a failed job, must be before
+// it is deleted, so nothing a job is still writing or cleaning up is swept
+const sweepGrace = time.Hour
+
+// retentionJobTypes are the job types a retention may be set for
+var retentionJobTypes = []string{
+	repositories.JobTypeSettlement, repositories.JobTypePayout, repositories.JobTypeBankFile,
+	repositories.JobTypeStatement, repositories.JobTypeReconciliation, repositories.JobTypeArtifactSweep,
+}
+
+// RetentionPolicy is how long completed jobs keep their artifacts, per job type; zero keeps them forever
+type RetentionPolicy struct {
+	Default time.Duration
+	ByType  map[string]time.Duration
+}
+
+// ParseRetention parses a comma separated list of TYPE=duration, with * for every other type,
+// e.g. "SETTLEMENT=90d,RECONCILIATION=30d,*=365d". Durations are days (90d) or Go durations
+// (12h); 0 keeps a type's artifacts forever. An empty policy keeps everything.
+func ParseRetention(s string) (RetentionPolicy, error) {
+	p := RetentionPolicy{ByType: map[string]time.Duration{}}
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		typ, value, ok := strings.Cut(entry, "=")
+		typ = strings.ToUpper(strings.TrimSpace(typ))
+		if !ok {
+			return RetentionPolicy{}, fmt.Errorf("%w: %q is not TYPE=duration", ErrInvalidRetention, entry)
+		}
+		d, err := parseRetentionDuration(strings.TrimSpace(value))
+		if err != nil {
+			return RetentionPolicy{}, fmt.Errorf("%w: %s: %v", ErrInvalidRetention, typ, err)
+		}
+		switch {
+		case typ == "*":
+			p.Default = d
+		case slices.Contains(retentionJobTypes, typ):
+			p.ByType[typ] = d
+		default:
+			return RetentionPolicy{}, fmt.Errorf("%w: unknown job type %q", ErrInvalidRetention, typ)
+		}
+	}
+	return p, nil
+}
+
+func parseRetentionDuration(v string) (time.Duration, error) {
+	var d time.Duration
+	if days, ok := strings.CutSuffix(v, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", v)
+		}
+		d = time.Duration(n) * 24 * time.Hour
+	} else if v == "0" {
+		return 0, nil
+	} else {
+		var err error
+		if d, err = time.ParseDuration(v); err != nil {
+			return 0, err
+		}
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("negative duration %q", v)
+	}
+	return d, nil
+}
+
+// For returns the retention of a job type's artifacts; zero keeps them forever
+func (p RetentionPolicy) For(typ string) time.Duration {
+	if d, ok := p.ByType[typ]; ok {
+		return d
+	}
+	return p.Default
+}
+
+// ArtifactSweepParams are the job parameters of an ARTIFACT_SWEEP job
+type ArtifactSweepParams struct {
+	Mode   string `json:"mode"`
+	DryRun bool   `json:"dry_run,omitempty"` // report what would be deleted without deleting it
+}
+
+// ArtifactSweepSummary reports what a sweep deleted, or would delete on a dry run
+type ArtifactSweepSummary struct {
+	DryRun       bool  `json:"dry_run"`
+	Scanned      int   `json:"scanned"`
+	Deleted      int   `json:"deleted_files"`
+	DeletedBytes int64 `json:"deleted_bytes"`
+	ExpiredJobs  int   `json:"expired_jobs"`
+	Orphans      int   `json:"orphans"`
+	FailedJobs   int   `json:"failed_jobs"`
+	TempFiles    int   `json:"temp_files"`
+	Errors       int   `json:"errors"`
+}
+
+// sweepItem is an artifact the sweep deletes
+type sweepItem struct {
+	obj    storage.ObjectInfo
+	jobID  string
+	job    *repositories.JobRow // nil for orphans
+	reason string
+	err    error
+}
+
+type ArtifactSweeper interface {
+	StartSweep(ctx context.Context, id string, dryRun bool, requestedBy string) error
+	Watch(ctx context.Context, interval time.Duration)
+}
+
+type artifactSweeper struct {
+	jobs   repositories.JobRepository
+	jobSvc JobService
+	store  storage.ArtifactStore
+	policy RetentionPolicy
+}
+
+func NewArtifactSweeper(jobs repositories.JobRepository, jobSvc JobService, store storage.ArtifactStore, policy RetentionPolicy) ArtifactSweeper {
+	s := &artifactSweeper{jobs: jobs, jobSvc: jobSvc, store: store, policy: policy}
+	jobSvc.Register(repositories.JobTypeArtifactSweep, s.run)
+	return s
+}
+
+// StartSweep records an ARTIFACT_SWEEP job over every stored artifact and enqueues it
+func (s *artifactSweeper) StartSweep(ctx context.Context, id string, dryRun bool, requestedBy string) error {
+	return s.start(ctx, id, ArtifactSweepParams{Mode: SweepModeManual, DryRun: dryRun}, requestedBy)
+}
+
+func (s *artifactSweeper) start(ctx context.Context, id string, params ArtifactSweepParams, requestedBy string) error {
+	objs, err := s.store.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	if err := s.jobs.Create(ctx, id, repositories.JobTypeArtifactSweep, int64(len(objs)), today, today, params, requestedBy); err != nil {
+		return err
+	}
+	s.jobSvc.Enqueue(id)
+	return nil
+}
+
+// Watch queues a sweep every interval unless a scheduled one is still queued or running
+func (s *artifactSweeper) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		active, err := s.jobs.HasActive(ctx, repositories.JobTypeArtifactSweep, SweepModeScheduled)
+		if err != nil {
+			log.Printf("Artifact sweep: %v", err)
+			continue
+		}
+		if active {
+			continue
+		}
+		id := NewJobID() + "_sweep"
+		if err := s.start(ctx, id, ArtifactSweepParams{Mode: SweepModeScheduled}, ""); err != nil {
+			log.Printf("Artifact sweep: %v", err)
+		}
+	}
+}
+
+// run deletes the artifacts planSweep picks, marks completed jobs whose artifacts are all gone as
+// expired, and writes one CSV line per artifact it deleted or failed to delete
+func (s *artifactSweeper) run(ctx context.Context, job *repositories.JobRow) error {
+	var params ArtifactSweepParams
+	if err := job.DecodeParams(&params); err != nil {
+		return err
+	}
+	objs, err := s.store.List(ctx, "")
+	if err != nil {
+		return err
+	}
+	var temps []storage.ObjectInfo
+	if tl, ok := s.store.(storage.TempLister); ok {
+		if temps, err = tl.ListTemp(ctx); err != nil {
+			return err
+		}
+	}
+	ids := make([]string, 0)
+	seen := map[string]bool{}
+	for _, o := range append(objs, temps...) {
+		if id := artifactJobID(o.Key); !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	jobs, err := s.jobs.GetMany(ctx, ids)
+	if err != nil {
+		return err
+	}
+	items := planSweep(objs, temps, jobs, s.policy, job.ID, time.Now())
+
+	summary := ArtifactSweepSummary{DryRun: params.DryRun, Scanned: len(objs) + len(temps)}
+	failed := map[string]bool{} // jobs with an artifact that could not be deleted
+	for i := range items {
+		it := &items[i]
+		if i%100 == 0 {
+			if cancelled, _ := s.jobs.IsCancelRequested(ctx, job.ID); cancelled {
+				log.Printf("Job %s: cancel requested", job.ID)
+				return errJobCanceled
+			}
+			_ = s.jobs.SetProgress(ctx, job.ID, int64(i))
+		}
+		if !params.DryRun {
+			it.err = s.store.Delete(ctx, it.obj.Key)
+		}
+		if it.err != nil {
+			failed[it.jobID] = true
+			summary.Errors++
+			log.Printf("Job %s: failed to delete artifact %s: %v", job.ID, it.obj.Key, it.err)
+			continue
+		}
+		summary.Deleted++
+		summary.DeletedBytes += it.obj.Size
+		switch it.reason {
+		case SweepOrphan:
+			summary.Orphans++
+		case SweepFailedJob:
+			summary.FailedJobs++
+		case SweepTemp:
+			summary.TempFiles++
+		}
+	}
+	marked := map[string]bool{}
+	for _, it := range items {
+		if it.reason != SweepExpired || failed[it.jobID] || marked[it.jobID] {
+			continue
+		}
+		marked[it.jobID] = true
+		if it.job.ArtifactExpiredAt.Valid {
+			continue
+		}
+		summary.ExpiredJobs++
+		if params.DryRun {
+			continue
+		}
+		if err := s.jobs.SetArtifactExpired(ctx, it.jobID); err != nil {
+			return err
+		}
+	}
+	_ = s.jobs.SetProgress(ctx, job.ID, int64(len(objs)))
+
+	outKey := job.ID + ".csv"
+	if err := storage.Put(ctx, s.store, outKey, func(w io.Writer) error { return writeSweepReport(w, items, params.DryRun) }); err != nil {
+		return err
+	}
+	if err := s.jobs.SetSummary(ctx, job.ID, summary); err != nil {
+		return err
+	}
+	log.Printf("Job %s: swept %d of %d artifacts (%d bytes), %d jobs expired, %d errors",
+		job.ID, summary.Deleted, summary.Scanned, summary.DeletedBytes, summary.ExpiredJobs, summary.Errors)
+	return s.jobs.SetCompleted(ctx, job.ID, outKey)
+}
+
+// artifactJobID returns the job an artifact key belongs to: the key up to its first dot, without
+// the _merchants suffix of per-merchant archives. A temp file belongs to the job of its artifact.
+func artifactJobID(key string) string {
+	id, _, _ := strings.Cut(strings.TrimPrefix(key, storage.TempPrefix), ".")
+	return strings.TrimSuffix(id, "_merchants")
+}
+
+// jobIDPrefix starts the id of every job NewJobID makes
+const jobIDPrefix = "job_"
+
+// isJobArtifact reports whether key is named like an artifact a job writes: a job id followed by the
+// suffix of a result file, manifest, preview, per-merchant archive, bank file or statement archive.
+// Only such keys can be orphans, so objects of others in a shared store, such as an S3 bucket
+// without a prefix, are never deleted.
+func isJobArtifact(key string) bool {
+	id := artifactJobID(key)
+	if !strings.HasPrefix(id, jobIDPrefix) {
+		return false
+	}
+	keys := []string{ManifestKey(id), PreviewKey(id), ArchiveKey(id, ArchiveZip), ArchiveKey(id, ArchiveTarGz), id + ".zip"}
+	for _, f := range results.Formats() {
+		keys = append(keys, ResultKey(id, f))
+	}
+	for _, f := range bankfile.Formats() {
+		e, _ := bankfile.Get(f)
+		keys = append(keys, id+"."+e.Extension())
+	}
+	return slices.Contains(keys, key)
+}
+
+// planSweep picks the artifacts to delete at now: those of completed jobs past their retention (and
+// any left over from jobs already expired), those of jobs that failed more than sweepGrace ago, and
+// job artifacts older than sweepGrace without a job row. Queued and running jobs, including the sweep
+// itself, are never touched. Temp files are deleted once they were not written to for sweepGrace,
+// whatever their job's state, as a live writer keeps its file fresh. Items are in key order.
+func planSweep(objs, temps []storage.ObjectInfo, jobs map[string]*repositories.JobRow, policy RetentionPolicy, self string, now time.Time) []sweepItem {
+	var items []sweepItem
+	for _, o := range temps {
+		if now.Sub(o.ModTime) >= sweepGrace {
+			id := artifactJobID(o.Key)
+			items = append(items, sweepItem{obj: o, jobID: id, job: jobs[id], reason: SweepTemp})
+		}
+	}
+	for _, o := range objs {
+		id := artifactJobID(o.Key)
+		if id == self {
+			continue
+		}
+		jr := jobs[id]
+		reason := ""
+		switch {
+		case jr == nil:
+			if isJobArtifact(o.Key) && now.Sub(o.ModTime) >= sweepGrace {
+				reason = SweepOrphan
+			}
+		case jr.Status == repositories.JobStatusFailed || jr.Status == repositories.JobStatusCanceled:
+			if now.Sub(jr.UpdatedAt) >= sweepGrace {
+				reason = SweepFailedJob
+			}
+		case jr.Status == repositories.JobStatusCompleted:
+			if jr.ArtifactExpiredAt.Valid {
+				reason = SweepExpired
+				break
+			}
+			done := jr.UpdatedAt
+			if jr.CompletedAt.Valid {
+				done = jr.CompletedAt.Time
+			}
+			if keep := policy.For(jr.Type); keep > 0 && now.Sub(done) >= keep {
+				reason = SweepExpired
+			}
+		}
+		if reason != "" {
+			items = append(items, sweepItem{obj: o, jobID: id, job: jr, reason: reason})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].obj.Key < items[j].obj.Key })
+	return items
+}
+
+// writeSweepReport writes one CSV line per swept artifact
+func writeSweepReport(w io.Writer, items []sweepItem, dryRun bool) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"key", "job_id", "job_type", "reason", "bytes", "modified_at", "deleted", "error"})
+	for _, it := range items {
+		typ := ""
+		if it.job != nil {
+			typ = it.job.Type
+		}
+		errMsg := ""
+		if it.err != nil {
+			errMsg = it.err.Error()
+		}
+		_ = cw.Write([]string{it.obj.Key, it.jobID, typ, it.reason, strconv.FormatInt(it.obj.Size, 10),
+			it.obj.ModTime.UTC().Format(time.RFC3339), strconv.FormatBool(!dryRun && it.err == nil), errMsg})
+	}
+	cw.Flush()
+	return cw.Error()
+}
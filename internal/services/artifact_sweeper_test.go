@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"be/internal/repositories"
+	"be/internal/storage"
+)
+
+func TestParseRetention(t *testing.T) {
+	p, err := ParseRetention("SETTLEMENT=90d, reconciliation=12h,*=365d,PAYOUT=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := 24 * time.Hour
+	for typ, want := range map[string]time.Duration{
+		repositories.JobTypeSettlement:     90 * day,
+		repositories.JobTypeReconciliation: 12 * time.Hour,
+		repositories.JobTypePayout:         0,
+		repositories.JobTypeStatement:      365 * day,
+	} {
+		if got := p.For(typ); got != want {
+			t.Errorf("For(%s) = %v, want %v", typ, got, want)
+		}
+	}
+	if p, err := ParseRetention(""); err != nil || p.For(repositories.JobTypeSettlement) != 0 {
+		t.Fatalf("empty policy = %+v, %v", p, err)
+	}
+	for _, bad := range []string{"SETTLEMENT", "SETTLEMNT=1d", "SETTLEMENT=-1d", "SETTLEMENT=xd", "*=soon"} {
+		if _, err := ParseRetention(bad); !errors.Is(err, ErrInvalidRetention) {
+			t.Errorf("ParseRetention(%q) = %v, want ErrInvalidRetention", bad, err)
+		}
+	}
+}
+
+func TestArtifactJobID(t *testing.T) {
+	for key, want := range map[string]string{
+		"job_20250101000000.csv":                            "job_20250101000000",
+		"job_20250101000000.manifest.json":                  "job_20250101000000",
+		"job_20250101000000_merchants.tar.gz":               "job_20250101000000",
+		"job_20250101000000_correction.csv":                 "job_20250101000000_correction",
+		"job_20250101000000_sweep.manifest.json":            "job_20250101000000_sweep",
+		"job_20250101000000_0123456789abcdef_merchants.zip": "job_20250101000000_0123456789abcdef",
+		".tmp-job_20250101000000.csv-123456":                "job_20250101000000",
+		"stray":                                             "stray",
+	} {
+		if got := artifactJobID(key); got != want {
+			t.Errorf("artifactJobID(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestPlanSweep(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+	day := 24 * time.Hour
+	completed := func(id, typ string, at time.Time) *repositories.JobRow {
+		return &repositories.JobRow{ID: id, Type: typ, Status: repositories.JobStatusCompleted, UpdatedAt: at, CompletedAt: sql.NullTime{Time: at, Valid: true}}
+	}
+	jobs := map[string]*repositories.JobRow{
+		"old":     completed("old", repositories.JobTypeSettlement, ago(91*day)),
+		"recent":  completed("recent", repositories.JobTypeSettlement, ago(89*day)),
+		"forever": completed("forever", repositories.JobTypePayout, ago(1000*day)),
+		"failed":  {ID: "failed", Type: repositories.JobTypeSettlement, Status: repositories.JobStatusFailed, UpdatedAt: ago(2 * time.Hour)},
+		"failing": {ID: "failing", Type: repositories.JobTypeSettlement, Status: repositories.JobStatusFailed, UpdatedAt: ago(time.Minute)},
+		"running": {ID: "running", Type: repositories.JobTypeSettlement, Status: repositories.JobStatusRunning, UpdatedAt: ago(100 * day)},
+		"sweep":   {ID: "sweep", Type: repositories.JobTypeArtifactSweep, Status: repositories.JobStatusRunning},
+	}
+	jobs["gone"] = completed("gone", repositories.JobTypePayout, ago(day))
+	jobs["gone"].ArtifactExpiredAt = sql.NullTime{Time: ago(day), Valid: true}
+	objs := []storage.ObjectInfo{
+		{Key: "old.csv", Size: 10, ModTime: ago(91 * day)},
+		{Key: "old_merchants.zip", Size: 20, ModTime: ago(91 * day)},
+		{Key: "recent.csv", ModTime: ago(89 * day)},
+		{Key: "forever.csv", ModTime: ago(1000 * day)},
+		{Key: "failed.csv", ModTime: ago(2 * time.Hour)},
+		{Key: "failing.csv", ModTime: ago(time.Minute)},
+		{Key: "running.csv", ModTime: ago(100 * day)},
+		{Key: "sweep.csv", ModTime: ago(100 * day)},
+		{Key: "gone.manifest.json", ModTime: ago(day)},
+		{Key: "job_20250101000000_0123456789abcdef.csv", ModTime: ago(2 * time.Hour)},
+		{Key: "job_20250101000000_0123456789abcdef_merchants.tar.gz", ModTime: ago(2 * time.Hour)},
+		{Key: "job_20250101000000_fedcba9876543210.csv", ModTime: ago(time.Minute)},
+		// not written by a job: other data in a shared bucket stays
+		{Key: "orphan.csv", ModTime: ago(2 * time.Hour)},
+		{Key: "job_20250101000000_0123456789abcdef.bak", ModTime: ago(2 * time.Hour)},
+		{Key: "backups/job_20250101000000_0123456789abcdef.csv", ModTime: ago(2 * time.Hour)},
+	}
+	policy := RetentionPolicy{ByType: map[string]time.Duration{repositories.JobTypeSettlement: 90 * day}}
+	temps := []storage.ObjectInfo{
+		{Key: ".tmp-running.csv-1", Size: 5, ModTime: ago(2 * time.Hour)},
+		{Key: ".tmp-running.csv-2", ModTime: ago(time.Minute)},
+	}
+	items := planSweep(objs, temps, jobs, policy, "sweep", now)
+
+	var got []string
+	for _, it := range items {
+		got = append(got, it.obj.Key+":"+it.reason)
+	}
+	want := ".tmp-running.csv-1:temp failed.csv:failed_job gone.manifest.json:expired job_20250101000000_0123456789abcdef.csv:orphan job_20250101000000_0123456789abcdef_merchants.tar.gz:orphan old.csv:expired old_merchants.zip:expired"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("planSweep = %v\nwant %s", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := writeSweepReport(&buf, items[1:2], true); err != nil {
+		t.Fatal(err)
+	}
+	report := "key,job_id,job_type,reason,bytes,modified_at,deleted,error\n" +
+		"failed.csv,failed,SETTLEMENT,failed_job,0,2025-06-01T10:00:00Z,false,\n"
+	if buf.String() != report {
+		t.Fatalf("report = %q", buf.String())
+	}
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"be/internal/bankfile"
+	"be/internal/repositories"
+	"be/internal/storage"
+)
+
+var (
+	ErrUnknownBankFormat = errors.New("UNKNOWN_BANK_FILE_FORMAT")
+	ErrBankConfigMissing = errors.New("BANK_CONFIG_NOT_LOADED")
+	errNothingToPayOut   = errors.New("no pending payouts in the selected range")
+)
+
+const (
+	bankFileDescription   = "SETTLEMENT"
+	defaultEffectiveDelay = 24 * time.Hour
+)
+
+// BankFileParams are the job parameters of a BANK_FILE export
+type BankFileParams struct {
+	Format        string   `json:"format"`
+	MerchantIDs   []string `json:"merchant_ids,omitempty"`
+	EffectiveDate string   `json:"effective_date,omitempty"`
+}
+
+type BankFileService interface {
+	StartExport(ctx context.Context, id string, from, to time.Time, params BankFileParams, requestedBy string) error
+}
+
+type bankFileService struct {
+	jobs    repositories.JobRepository
+	payouts repositories.PayoutRepository
+	jobSvc  JobService
+	cfg     *bankfile.Config
+	store   storage.ArtifactStore
+}
+
+// NewBankFileService registers the BANK_FILE job runner; cfg may be nil when no bank configuration is loaded
+func NewBankFileService(jobs repositories.JobRepository, payouts repositories.PayoutRepository, jobSvc JobService, cfg *bankfile.Config, store storage.ArtifactStore) BankFileService {
+	bs := &bankFileService{jobs: jobs, payouts: payouts, jobSvc: jobSvc, cfg: cfg, store: store}
+	jobSvc.Register(repositories.JobTypeBankFile, bs.run)
+	return bs
+}
+
+// StartExport validates the parameters, records a BANK_FILE job and enqueues it
+func (s *bankFileService) StartExport(ctx context.Context, id string, from, to time.Time, params BankFileParams, requestedBy string) error {
+	if s.cfg == nil {
+		return ErrBankConfigMissing
+	}
+	if _, ok := bankfile.Get(params.Format); !ok {
+		return ErrUnknownBankFormat
+	}
+	if params.EffectiveDate != "" {
+		if _, err := time.Parse("2006-01-02", params.EffectiveDate); err != nil {
+			return fmt.Errorf("invalid effective_date: %w", err)
+		}
+	}
+	if err := s.jobs.Create(ctx, id, repositories.JobTypeBankFile, 0, from, to, params, requestedBy); err != nil {
+		return err
+	}
+	s.jobSvc.Enqueue(id)
+	return nil
+}
+
+// run writes one credit per PENDING payout of the selected merchants whose period ends in range, and marks
+// the payouts SENT as the job completes. Payouts, not settlement rows, are exported: a settlement row is in
+// at most one payout and a payout is sent at most once, so nothing is paid twice.
+func (s *bankFileService) run(ctx context.Context, job *repositories.JobRow) error {
+	var params BankFileParams
+	if err := job.DecodeParams(&params); err != nil {
+		return err
+	}
+	exporter, ok := bankfile.Get(params.Format)
+	if !ok {
+		return ErrUnknownBankFormat
+	}
+	from, to := job.FromDate.Time, job.ToDate.Time
+	payouts, err := s.payouts.Pending(ctx, from, to, params.MerchantIDs)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	batch := &bankfile.Batch{
+		ID:            job.ID,
+		Originator:    s.cfg.Originator,
+		CreatedAt:     now,
+		EffectiveDate: now.Add(defaultEffectiveDelay),
+		Description:   bankFileDescription,
+	}
+	if params.EffectiveDate != "" {
+		batch.EffectiveDate, _ = time.Parse("2006-01-02", params.EffectiveDate)
+	}
+	var missing []string
+	ids := make([]int64, 0, len(payouts))
+	for _, p := range payouts {
+		if p.AmountCents <= 0 {
+			continue
+		}
+		account, ok := s.cfg.Merchants[p.MerchantID]
+		if !ok {
+			missing = append(missing, p.MerchantID)
+			continue
+		}
+		reference := fmt.Sprintf("Payout %d settlement %s to %s", p.ID, p.PeriodStart.Format("2006-01-02"), p.PeriodEnd.Format("2006-01-02"))
+		batch.Entries = append(batch.Entries, bankfile.Entry{MerchantID: p.MerchantID, Account: account, AmountCents: p.AmountCents, Reference: reference, PaymentID: strconv.FormatInt(p.ID, 10)})
+		ids = append(ids, p.ID)
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no bank details configured for merchants %v", missing)
+	}
+	if len(batch.Entries) == 0 {
+		return errNothingToPayOut
+	}
+	_ = s.jobs.SetProgress(ctx, job.ID, int64(len(ids)))
+
+	var buf bytes.Buffer
+	if err := exporter.Export(&buf, batch); err != nil {
+		return err
+	}
+	outKey := fmt.Sprintf("%s.%s", job.ID, exporter.Extension())
+	err = storage.Put(ctx, s.store, outKey, func(w io.Writer) error {
+		_, err := buf.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.payouts.MarkSent(ctx, job.ID, outKey, ids); err != nil {
+		_ = s.store.Delete(context.WithoutCancel(ctx), outKey)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/csv"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"be/internal/manifest"
+	"be/internal/repositories"
+	"be/internal/storage"
+)
+
+var ErrManifestNotFound = errors.New("MANIFEST_NOT_FOUND")
+
+// manifestSuffix ends the key of a job's manifest, next to its artifacts
+const manifestSuffix = ".manifest.json"
+
+// ManifestKey returns the manifest of a job
+func ManifestKey(jobID string) string {
+	return jobID + manifestSuffix
+}
+
+// tableStats are the row count and integer column totals of a job's result table
+type tableStats struct {
+	rows   int64
+	totals map[string]int64
+}
+
+// jobKeys lists the artifacts a job stored: <id>.<ext> and its per-merchant archive, with its
+// manifest unless withManifest is false. <id>_<suffix> jobs, like corrections, are not included.
+func jobKeys(ctx context.Context, store storage.ArtifactStore, jobID string, withManifest bool) ([]string, error) {
+	var keys []string
+	for _, prefix := range []string{jobID + ".", jobID + "_merchants."} {
+		found, err := store.List(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, o := range found {
+			if withManifest || !strings.HasSuffix(o.Key, manifestSuffix) {
+				keys = append(keys, o.Key)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// writeManifest records the job's artifacts, with their checksums read back from the store, and
+// signs the manifest when a signing key is configured
+func (s *jobService) writeManifest(ctx context.Context, jr *repositories.JobRow, keys []string, stats *tableStats) error {
+	m := &manifest.Manifest{
+		Version:   manifest.Version,
+		JobID:     jr.ID,
+		JobType:   jr.Type,
+		Params:    jr.Params,
+		CreatedAt: time.Now().UTC().Truncate(time.Second),
+		Artifacts: make([]manifest.Artifact, 0, len(keys)),
+	}
+	if jr.FromDate.Valid {
+		m.From = jr.FromDate.Time.Format("2006-01-02")
+	}
+	if jr.ToDate.Valid {
+		m.To = jr.ToDate.Time.Format("2006-01-02")
+	}
+	if stats != nil {
+		m.Rows, m.Totals = &stats.rows, stats.totals
+	}
+	for _, key := range keys {
+		a, err := hashArtifact(ctx, s.store, key)
+		if err != nil {
+			return err
+		}
+		m.Artifacts = append(m.Artifacts, a)
+	}
+	if s.signer != nil {
+		if err := m.Sign(s.signer); err != nil {
+			return err
+		}
+	}
+	raw, err := manifest.Encode(m)
+	if err != nil {
+		return err
+	}
+	return storage.Put(ctx, s.store, ManifestKey(jr.ID), func(w io.Writer) error {
+		_, err := w.Write(raw)
+		return err
+	})
+}
+
+func hashArtifact(ctx context.Context, store storage.ArtifactStore, key string) (manifest.Artifact, error) {
+	rc, _, err := store.Open(ctx, key)
+	if err != nil {
+		return manifest.Artifact{}, err
+	}
+	defer rc.Close()
+	return manifest.Hash(path.Base(key), rc)
+}
+
+// sealJob writes the manifest of a job run by a registered runner, after the runner completed it.
+// A CSV result is read back for its row count and totals.
+func (s *jobService) sealJob(ctx context.Context, id string) error {
+	jr, err := s.jobs.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+	if jr.Status != repositories.JobStatusCompleted {
+		return nil
+	}
+	keys, err := jobKeys(ctx, s.store, id, false)
+	if err != nil {
+		return err
+	}
+	var stats *tableStats
+	if jr.ResultPath.Valid && path.Ext(jr.ResultPath.String) == ".csv" {
+		rc, _, err := s.store.Open(ctx, path.Base(jr.ResultPath.String))
+		if err != nil {
+			return err
+		}
+		stats, err = csvStats(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return s.writeManifest(ctx, jr, keys, stats)
+}
+
+// csvStats counts the data rows of a CSV file and sums each column whose values are all integers
+func csvStats(f io.Reader) (*tableStats, error) {
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+	header, err := r.Read()
+	if errors.Is(err, io.EOF) {
+		return &tableStats{totals: map[string]int64{}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sums := make([]int64, len(header))
+	numeric := make([]bool, len(header))
+	for i := range numeric {
+		numeric[i] = true
+	}
+	stats := &tableStats{totals: map[string]int64{}}
+	for {
+		rec, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		stats.rows++
+		for i := range header {
+			if !numeric[i] {
+				continue
+			}
+			if i >= len(rec) {
+				numeric[i] = false
+				continue
+			}
+			n, err := strconv.ParseInt(rec[i], 10, 64)
+			if err != nil {
+				numeric[i] = false
+				continue
+			}
+			sums[i] += n
+		}
+	}
+	if stats.rows > 0 {
+		for i, name := range header {
+			if numeric[i] {
+				stats.totals[name] = sums[i]
+			}
+		}
+	}
+	return stats, nil
+}
+
+// VerifyJob checks a job's manifest signature and its artifacts. With r, it checks that content
+// as the artifact named name; otherwise it checks every artifact of the manifest as stored.
+func (s *jobService) VerifyJob(ctx context.Context, jobID, name string, r io.Reader) (*manifest.Report, error) {
+	rc, _, err := s.store.Open(ctx, ManifestKey(jobID))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrManifestNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var raw bytes.Buffer
+	_, err = raw.ReadFrom(rc)
+	rc.Close()
+	if err != nil {
+		return nil, err
+	}
+	m, err := manifest.Decode(raw.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	var pub ed25519.PublicKey
+	if s.signer != nil {
+		pub = s.signer.Public().(ed25519.PublicKey)
+	}
+	report := m.NewReport(pub)
+	if r != nil {
+		got, err := m.Check(path.Base(name), r)
+		report.Add(path.Base(name), got, err)
+		return report, nil
+	}
+	for _, a := range m.Artifacts {
+		rc, _, err := s.store.Open(ctx, a.Name)
+		if err != nil {
+			report.Add(a.Name, manifest.Artifact{}, err)
+			continue
+		}
+		got, err := m.Check(a.Name, rc)
+		rc.Close()
+		report.Add(a.Name, got, err)
+	}
+	return report, nil
+}
@@ -0,0 +1,17 @@
+package services
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCSVStats(t *testing.T) {
+	data := "merchant_id,day,net,issue\nm-001,2025-01-01,970,\nm-002,2025-01-01,-30,MISMATCH\n"
+	st, err := csvStats(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.rows != 2 || len(st.totals) != 1 || st.totals["net"] != 940 {
+		t.Fatalf("stats = %+v", st)
+	}
+}
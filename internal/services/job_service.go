@@ -2,53 +2,172 @@ package services
 
 import (
 	"context"
-	"encoding/csv"
-	"fmt"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
 	"log"
-	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
+	"be/internal/manifest"
+	"be/internal/models"
 	"be/internal/repositories"
+	"be/internal/storage"
 )
 
+var (
+	errJobCanceled           = errors.New("job canceled")
+	ErrInvalidSettlementMode = errors.New("INVALID_SETTLEMENT_MODE")
+	ErrInvalidAggregation    = errors.New("INVALID_AGGREGATION")
+)
+
+// NewJobID returns a job id: its start time, for reading, and 64 random bits, so ids can be neither
+// guessed nor repeated by jobs started in the same second
+func NewJobID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return "job_" + time.Now().Format("20060102150405") + "_" + hex.EncodeToString(b[:])
+}
+
+// Settlement modes
+const (
+	SettlementModeFull        = "full"        // rescan every transaction in range
+	SettlementModeIncremental = "incremental" // recompute only merchant/days with changed transactions
+	SettlementModeCorrection  = "correction"  // re-settle already settled merchant/days that got late transactions
+)
+
+// SettlementParams are the job parameters of a SETTLEMENT job
+type SettlementParams struct {
+	Mode        string   `json:"mode,omitempty"`
+	Aggregation string   `json:"aggregation,omitempty"` // AggregationStream (default) or AggregationSQL
+	Formats     []string `json:"formats,omitempty"`     // result formats, the first is the primary result; CSV when empty
+	Archive     string   `json:"archive,omitempty"`     // ArchiveZip or ArchiveTarGz to also split the result per merchant
+
+	// set on correction jobs only
+	Keys     []repositories.SettlementKey `json:"keys,omitempty"`     // merchant/days to re-settle
+	Corrects []string                     `json:"corrects,omitempty"` // runs that settled the keys
+}
+
 type JobService interface {
-	StartSettlement(ctx context.Context, id string, from, to time.Time) error
+	StartSettlement(ctx context.Context, id string, from, to time.Time, params SettlementParams, requestedBy string) error
 	Enqueue(id string)
+	Register(typ string, run JobRunner)
+	WatchLateArrivals(ctx context.Context, interval time.Duration)
+	MerchantArtifact(ctx context.Context, jobID, merchantID string) (*Artifact, error)
+	VerifyJob(ctx context.Context, jobID, name string, r io.Reader) (*manifest.Report, error)
+	PreviewResults(ctx context.Context, jobID string, q PreviewQuery) (*ResultPreview, error)
 }
 
+// JobRunner executes a queued job of one type. The job is already RUNNING when it is called;
+// the runner marks it completed, and a returned error marks it failed.
+type JobRunner func(ctx context.Context, job *repositories.JobRow) error
+
 type jobService struct {
-	jobs    repositories.JobRepository
-	txRepo  repositories.TransactionRepository
-	stRepo  repositories.SettlementRepository
-	workers int
+	jobs   repositories.JobRepository
+	stRepo repositories.SettlementRepository
+	calc   *settlementCalculator
+	signer ed25519.PrivateKey // signs job manifests; nil leaves them unsigned
 
 	jobQueue chan string
-	outDir   string
+	store    storage.ArtifactStore
+	previews previewCache
+
+	mu      sync.RWMutex
+	runners map[string]JobRunner
 }
 
-func NewJobService(j repositories.JobRepository, t repositories.TransactionRepository, s repositories.SettlementRepository, workers int) JobService {
-	js := &jobService{jobs: j, txRepo: t, stRepo: s, workers: workers, jobQueue: make(chan string, 32), outDir: "./tmp/settlements"}
+func NewJobService(j repositories.JobRepository, t repositories.TransactionRepository, s repositories.SettlementRepository, a repositories.AdjustmentRepository, rsv repositories.ReserveRepository, workers, partitions int, memBudget int64, cutoff time.Duration, store storage.ArtifactStore, signer ed25519.PrivateKey) JobService {
+	calc := &settlementCalculator{txRepo: t, adjRepo: a, rsvRepo: rsv, workers: workers, partitions: partitions, memBudget: memBudget, cutoff: cutoff}
+	js := &jobService{jobs: j, stRepo: s, calc: calc, signer: signer, jobQueue: make(chan string, 32), store: store, runners: map[string]JobRunner{}}
 	go js.loop()
 	return js
 }
 
 func (s *jobService) loop() {
 	for id := range s.jobQueue {
-		s.process(context.Background(), id)
+		s.dispatch(context.Background(), id)
 	}
 }
 
 func (s *jobService) Enqueue(id string) { s.jobQueue <- id }
 
-// StartSettlement prepares the job row and enqueues it
-func (s *jobService) StartSettlement(ctx context.Context, id string, from, to time.Time) error {
-	total, err := s.txRepo.CountInRange(ctx, from, to)
+// Register adds the runner for a job type other than settlement
+func (s *jobService) Register(typ string, run JobRunner) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.runners[typ] = run
+}
+
+// dispatch runs a queued job with the runner registered for its type
+func (s *jobService) dispatch(ctx context.Context, id string) {
+	jr, err := s.jobs.Get(ctx, id)
 	if err != nil {
+		log.Printf("Job %s: load failed: %v", id, err)
+		return
+	}
+	if jr.Type == repositories.JobTypeSettlement {
+		_ = s.process(ctx, id)
+		return
+	}
+	s.mu.RLock()
+	run, ok := s.runners[jr.Type]
+	s.mu.RUnlock()
+	if !ok {
+		_ = s.jobs.SetFailed(ctx, id, "unknown job type "+jr.Type)
+		return
+	}
+	if err := s.jobs.SetRunning(ctx, id); err != nil {
+		log.Printf("Job %s: set running failed: %v", id, err)
+		return
+	}
+	start := time.Now()
+	if err := run(ctx, jr); err != nil {
+		log.Printf("Job %s failed after %v: %v", id, time.Since(start), err)
+		if errors.Is(err, repositories.ErrJobNotRunning) {
+			// canceled while running; the runner could not complete it
+			err = errJobCanceled
+		}
+		_ = s.jobs.SetFailed(ctx, id, err.Error())
+		return
+	}
+	// runners complete their jobs themselves, so the manifest follows the completion
+	if err := s.sealJob(ctx, id); err != nil {
+		log.Printf("Job %s: writing manifest failed: %v", id, err)
+	}
+	log.Printf("Job %s finished in %v", id, time.Since(start))
+}
+
+// StartSettlement prepares the job row and enqueues it. An incremental job counts
+// transactions as it goes, since its scope is only known when it runs.
+func (s *jobService) StartSettlement(ctx context.Context, id string, from, to time.Time, params SettlementParams, requestedBy string) error {
+	switch params.Aggregation {
+	case "", AggregationStream, AggregationSQL:
+	default:
+		return ErrInvalidAggregation
+	}
+	var err error
+	if params.Formats, err = normalizeFormats(params.Formats); err != nil {
 		return err
 	}
-	if err := s.jobs.Create(ctx, id, repositories.JobTypeSettlement, total, from, to); err != nil {
+	switch params.Archive {
+	case "", ArchiveZip, ArchiveTarGz:
+	default:
+		return ErrInvalidArchive
+	}
+	var total int64
+	switch params.Mode {
+	case "", SettlementModeFull:
+		params.Mode = SettlementModeFull
+		if total, err = s.calc.txRepo.CountInRange(ctx, from, to, s.calc.cutoff); err != nil {
+			return err
+		}
+	case SettlementModeIncremental:
+	default:
+		return ErrInvalidSettlementMode
+	}
+	if err := s.jobs.Create(ctx, id, repositories.JobTypeSettlement, total, from, to, params, requestedBy); err != nil {
 		return err
 	}
 	s.Enqueue(id)
@@ -59,18 +178,19 @@ func (s *jobService) checkCancel(ctx context.Context, id string) bool {
 	cancelled, _ := s.jobs.IsCancelRequested(ctx, id)
 	if cancelled {
 		log.Printf("Job %s: cancel requested", id)
-		_ = s.jobs.SetFailed(ctx, id, "job canceled")
-		outPath := filepath.Join(s.outDir, fmt.Sprintf("%s.csv", id))
-		err := os.Remove(outPath)
-		if err != nil {
-			log.Printf("Job %s: failed to remove output file %s: %v", id, outPath, err)
+		_ = s.jobs.SetFailed(ctx, id, errJobCanceled.Error())
+		keys, _ := jobKeys(ctx, s.store, id, true)
+		for _, key := range keys {
+			if err := s.store.Delete(ctx, key); err != nil {
+				log.Printf("Job %s: failed to remove artifact %s: %v", id, key, err)
+			}
 		}
 		return true
 	}
 	return false
 }
 
-// process performs the job: stream transactions in batches, fan-out to workers to aggregate, then write CSV, upsert settlements, and update job status
+// process performs the job: compute the settlement rows of the job's range, then write CSV, upsert settlements, and update job status
 func (s *jobService) process(parentCtx context.Context, id string) error {
 	ctx, cancel := context.WithCancel(parentCtx)
 	defer cancel()
@@ -103,73 +223,6 @@ func (s *jobService) process(parentCtx context.Context, id string) error {
 		}
 		log.Printf("Job %s finished in %v", id, time.Since(start))
 	}()
-	_ = os.MkdirAll(s.outDir, 0o755)
-	outPath := filepath.Join(s.outDir, fmt.Sprintf("%s.csv", id))
-	f, err := os.Create(outPath)
-	if err != nil {
-		_ = s.jobs.SetFailed(ctx, id, err.Error())
-		return err
-	}
-	defer f.Close()
-	w := csv.NewWriter(f)
-	defer w.Flush()
-	_ = w.Write([]string{"merchant_id", "date", "gross", "fee", "net", "txn_count"})
-
-	type key struct{ merchant, day string }
-	agg := make(map[key]struct {
-		gross, fee, net int64
-		count           int64
-	})
-	var mu sync.Mutex
-
-	batches := make(chan []repositories.TransactionRow, s.workers)
-	var wg sync.WaitGroup
-	worker := func() {
-		defer wg.Done()
-		log.Println("Worker started")
-		for {
-			select {
-			case <-ctx.Done():
-				log.Println("Worker exiting due to cancel")
-				return
-			case batch, ok := <-batches:
-				if !ok {
-					return
-				}
-				local := make(map[key]struct {
-					gross, fee, net int64
-					count           int64
-				})
-				for _, t := range batch {
-					day := t.PaidAt.Format("2006-01-02")
-					k := key{merchant: t.MerchantID, day: day}
-					v := local[k]
-					v.gross += t.AmountCents
-					v.fee += t.FeeCents
-					v.net += t.AmountCents - t.FeeCents
-					v.count++
-					local[k] = v
-				}
-				mu.Lock()
-				for k, v := range local {
-					a := agg[k]
-					a.gross += v.gross
-					a.fee += v.fee
-					a.net += v.net
-					a.count += v.count
-					agg[k] = a
-				}
-				mu.Unlock()
-			}
-		}
-	}
-
-	for i := 0; i < s.workers; i++ {
-		wg.Add(1)
-		go worker()
-	}
-
-	processed := int64(0)
 	jr, _ := s.jobs.Get(ctx, id)
 	var from, to time.Time
 	if jr != nil && jr.FromDate.Valid {
@@ -182,43 +235,270 @@ func (s *jobService) process(parentCtx context.Context, id string) error {
 	} else {
 		to = time.Date(2025, 1, 31, 0, 0, 0, 0, time.UTC)
 	}
-	go func() {
-		defer close(batches)
-		_ = s.txRepo.StreamBatches(ctx, from, to, 10000, func(ts []repositories.TransactionRow) error {
-			// Check cancel after every batch fetched
-			if s.checkCancel(parentCtx, id) {
-				cancel()
-				return context.Canceled
-			}
-			batches <- ts
-			processed += int64(len(ts))
-			_ = s.jobs.SetProgress(ctx, id, processed)
-			return nil
-		})
+	var params SettlementParams
+	if jr != nil {
+		_ = jr.DecodeParams(&params)
+	}
+	var keys []repositories.SettlementKey
+	var dirty []repositories.DirtyDay
+	correction := params.Mode == SettlementModeCorrection
+	cols := settlementColumns
+	if correction {
+		keys = params.Keys
+		cols = correctionColumns()
+	}
+	if len(params.Formats) == 0 {
+		params.Formats = []string{DefaultResultFormat}
+	}
+	w, err := createResultFiles(ctx, s.store, id, params.Formats, cols)
+	if err != nil {
+		_ = s.jobs.SetFailed(ctx, id, err.Error())
+		return err
+	}
+	completed := false
+	defer func() {
+		if !completed {
+			w.remove()
+		}
 	}()
-	wg.Wait()
+	outPath := w.primary()
 
-	// Write CSV and upsert settlements
-	for k, v := range agg {
-		if ctx.Err() != nil {
-			log.Printf("Job %s canceled before finalizing results", id)
-			_ = s.jobs.SetFailed(ctx, id, "job canceled")
-			return ctx.Err()
+	// Every read of the run happens in one REPEATABLE READ snapshot, so the total, the aggregates and
+	// the stored rows agree with each other; the snapshot is recorded on the job
+	snap, err := s.calc.txRepo.BeginSnapshot(ctx)
+	if err != nil {
+		_ = s.jobs.SetFailed(ctx, id, err.Error())
+		return err
+	}
+	defer func() { _ = snap.Close() }()
+	readCtx := snap.Context(ctx)
+	var total int64
+	if jr != nil {
+		total = jr.Total
+	}
+	if params.Mode == SettlementModeFull || params.Mode == "" {
+		if total, err = s.calc.txRepo.CountInRange(readCtx, from, to, s.calc.cutoff); err != nil {
+			_ = s.jobs.SetFailed(ctx, id, err.Error())
+			return err
+		}
+	}
+	if err := s.jobs.SetSnapshot(ctx, id, snap.At, snap.Txs, total); err != nil {
+		_ = s.jobs.SetFailed(ctx, id, err.Error())
+		return err
+	}
+
+	// dirty days are read in the snapshot, so the ones cleared afterwards are exactly those it settled
+	if !correction {
+		if dirty, err = s.stRepo.DirtyDays(readCtx, from, to); err != nil {
+			_ = s.jobs.SetFailed(ctx, id, err.Error())
+			return err
+		}
+	}
+	if params.Mode == SettlementModeIncremental {
+		keys = dirtyKeys(dirty, from, to)
+		log.Printf("Job %s: %d dirty days, %d merchant/days to recompute", id, len(dirty), len(keys))
+	}
+	result, err := s.calc.withStrategy(params.Aggregation).compute(readCtx, from, to, keys, func(processed int64) error {
+		// Check cancel after every batch fetched
+		if s.checkCancel(parentCtx, id) {
+			cancel()
+			return context.Canceled
+		}
+		_ = s.jobs.SetProgress(ctx, id, processed)
+		return nil
+	})
+	if err != nil {
+		// a canceled job was already marked failed by checkCancel
+		if ctx.Err() == nil {
+			_ = s.jobs.SetFailed(ctx, id, err.Error())
+		}
+		return err
+	}
+
+	defer func() { _ = result.close() }()
+
+	var stored map[settlementKey]models.Settlement
+	if keys != nil {
+		if stored, err = s.keepStoredDays(readCtx, from, to, result); err != nil {
+			_ = s.jobs.SetFailed(ctx, id, err.Error())
+			return err
 		}
+	}
+	_ = snap.Close()
 
-		if err := w.Write([]string{k.merchant, k.day, fmt.Sprintf("%d", v.gross), fmt.Sprintf("%d", v.fee), fmt.Sprintf("%d", v.net), fmt.Sprintf("%d", v.count)}); err != nil {
+	var archive *merchantArchive
+	closeArchive := func() error { return nil }
+	if params.Archive != "" && !correction {
+		archiveKey := ArchiveKey(id, params.Archive)
+		af, err := s.store.Create(ctx, archiveKey)
+		if err != nil {
 			_ = s.jobs.SetFailed(ctx, id, err.Error())
 			return err
 		}
-		if err := s.stRepo.Upsert(ctx, k.merchant, k.day, v.gross, v.fee, v.net, v.count, id); err != nil {
+		w.track(archiveKey)
+		defer func() { _ = af.Abort() }()
+		if archive, err = newMerchantArchive(af, id, params.Archive, params.Formats[0]); err != nil {
 			_ = s.jobs.SetFailed(ctx, id, err.Error())
 			return err
 		}
+		closeArchive = func() error {
+			if err := archive.Close(); err != nil {
+				return err
+			}
+			return af.Close()
+		}
+	}
+	if jr == nil {
+		jr = &repositories.JobRow{ID: id, Type: repositories.JobTypeSettlement}
 	}
 
-	if err := s.jobs.SetCompleted(ctx, id, outPath); err != nil {
+	// The rows stream in merchant/date order from the aggregation into the result files, the archive and
+	// the settlements staging table at once, so the same data always gives byte-identical files and no run
+	// is held in memory whole; a correction writes the delta against the stored rows instead. The rows,
+	// reserves and adjustments land together with the job's completion, so a crash never leaves a
+	// half-written run and a job canceled meanwhile writes nothing.
+	var deltas []settlementDelta
+	run := repositories.SettlementRun{JobID: id, ResultPath: outPath, From: from, To: to, Keys: result.scope, AdjustmentIDs: result.adjustmentIDs}
+	err = s.stRepo.WriteRun(ctx, run, func(emit func(repositories.SettlementRunRow) error) error {
+		err := result.each(func(k settlementKey, v settlementTotals) error {
+			if err := emit(result.runRow(k, v, id)); err != nil {
+				return err
+			}
+			if correction {
+				if d, changed := diffSettlement(k, stored[k], v); changed {
+					deltas = append(deltas, d)
+				}
+				return nil
+			}
+			if err := w.WriteRow(settlementRecord(k, v)); err != nil {
+				return err
+			}
+			if archive != nil {
+				return archive.WriteRow(k, v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if correction {
+			if err := writeCorrectionReport(w, deltas); err != nil {
+				return err
+			}
+			if err := s.jobs.SetSummary(ctx, id, summarizeCorrection(params.Corrects, deltas)); err != nil {
+				return err
+			}
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+		if err := closeArchive(); err != nil {
+			return err
+		}
+		// the manifest is in place before the job completes, so every completed settlement has one
+		w.track(ManifestKey(id))
+		if err := s.writeManifest(ctx, jr, w.artifacts(), w.stats()); err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			log.Printf("Job %s canceled before finalizing results", id)
+			return errJobCanceled
+		}
+		return nil
+	})
+	if err != nil {
+		msg := err.Error()
+		if errors.Is(err, repositories.ErrJobNotRunning) {
+			msg = errJobCanceled.Error()
+		}
+		_ = s.jobs.SetFailed(ctx, id, msg)
 		return err
 	}
+	completed = true
 
+	// Dirty days are cleared only once the rows are written; one left behind by a crash is just recomputed
+	var clearErr error
+	switch {
+	case correction:
+		// dirty days stay for the next incremental run, which may also cover unsettled neighbours
+	case keys != nil:
+		clearErr = s.stRepo.ClearDirty(ctx, dirty)
+	default:
+		clearErr = s.stRepo.ClearDirty(ctx, settledDirtyDays(dirty, from, to))
+	}
+	if clearErr != nil {
+		log.Printf("Job %s: clearing dirty days failed: %v", id, clearErr)
+	}
 	return nil
 }
+
+// dirtyKeys maps dirty days to the settlement days in range their transactions can settle on:
+// the day itself, or the next one when paid at or after the cut-off
+func dirtyKeys(dirty []repositories.DirtyDay, from, to time.Time) []repositories.SettlementKey {
+	fromDay, toDay := from.Format("2006-01-02"), to.Format("2006-01-02")
+	seen := map[repositories.SettlementKey]bool{}
+	keys := []repositories.SettlementKey{}
+	for _, d := range dirty {
+		for _, day := range []time.Time{d.Day, d.Day.AddDate(0, 0, 1)} {
+			k := repositories.SettlementKey{MerchantID: d.MerchantID, Date: day.Format("2006-01-02")}
+			if k.Date < fromDay || k.Date > toDay || seen[k] {
+				continue
+			}
+			seen[k] = true
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// settledDirtyDays keeps the dirty days a full run over range (inclusive) settled completely: those
+// whose transactions settle within it whether paid before or after the cut-off
+func settledDirtyDays(dirty []repositories.DirtyDay, from, to time.Time) []repositories.DirtyDay {
+	fromDay, toDay := from.Format("2006-01-02"), to.Format("2006-01-02")
+	var out []repositories.DirtyDay
+	for _, d := range dirty {
+		if day := d.Day.Format("2006-01-02"); day >= fromDay && day < toDay {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// keepStoredDays zero-fills recomputed merchant/days that have a settlements row but no longer any
+// activity, so the stored row is corrected instead of left stale. It returns the stored rows in scope.
+func (s *jobService) keepStoredDays(ctx context.Context, from, to time.Time, result *computedSettlement) (map[settlementKey]models.Settlement, error) {
+	merchants := map[string]bool{}
+	for _, k := range result.scope {
+		merchants[k.MerchantID] = true
+	}
+	ids := make([]string, 0, len(merchants))
+	for m := range merchants {
+		ids = append(ids, m)
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	stored, err := s.stRepo.ListInRange(ctx, from, to, ids)
+	if err != nil {
+		return nil, err
+	}
+	inScope := make(map[settlementKey]bool, len(result.scope))
+	for _, k := range result.scope {
+		inScope[settlementKey{merchant: k.MerchantID, day: k.Date}] = true
+	}
+	out := map[settlementKey]models.Settlement{}
+	for _, st := range stored {
+		k := settlementKey{merchant: st.MerchantID, day: st.Date.Format("2006-01-02")}
+		if !inScope[k] {
+			continue
+		}
+		out[k] = st
+		if _, ok := result.extra[k]; ok {
+			continue
+		}
+		// a day that turns out to have transactions takes its cut-off from them instead
+		cutoff, _ := ParseCutoff(st.Cutoff)
+		result.extra[k] = settlementTotals{cutoff: int64(cutoff / time.Second)}
+	}
+	return out, nil
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"be/internal/ledger"
+	"be/internal/repositories"
+)
+
+const ledgerSyncBatch = 5000
+
+// LedgerCheck reports where the ledger disagrees with the settlements table
+type LedgerCheck struct {
+	From       string                        `json:"from"`
+	To         string                        `json:"to"`
+	Consistent bool                          `json:"consistent"`
+	Mismatches []repositories.LedgerMismatch `json:"mismatches"`
+}
+
+type LedgerService interface {
+	Sync(ctx context.Context) (int, error)
+	Follow(ctx context.Context, interval time.Duration)
+	MerchantBalance(ctx context.Context, merchantID string, at time.Time) (int64, error)
+	Check(ctx context.Context, from, to time.Time) (*LedgerCheck, error)
+}
+
+type ledgerService struct {
+	repo   repositories.LedgerRepository
+	cutoff time.Duration // global cut-off for merchants without their own
+}
+
+func NewLedgerService(repo repositories.LedgerRepository, cutoff time.Duration) LedgerService {
+	return &ledgerService{repo: repo, cutoff: cutoff}
+}
+
+// Sync records captures and refunds of transactions that the ledger has not recorded yet.
+// Adjustments and payouts are posted by their own workflows when they are approved and confirmed.
+func (s *ledgerService) Sync(ctx context.Context) (int, error) {
+	posted := 0
+	for {
+		rows, err := s.repo.CapturesToPost(ctx, ledgerSyncBatch, s.cutoff)
+		if err != nil {
+			return posted, err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		entries := make([]ledger.Entry, 0, len(rows))
+		for _, t := range rows {
+			entries = append(entries, ledger.Capture(t.MerchantID, strconv.FormatInt(t.ID, 10), t.AmountCents, t.FeeCents, t.PaidAt, t.ValueDate))
+			if t.Status == "REFUNDED" {
+				entries = append(entries, refundEntry(t))
+			}
+		}
+		n, err := s.repo.Post(ctx, entries...)
+		posted += n
+		if err != nil {
+			return posted, err
+		}
+		if len(rows) < ledgerSyncBatch {
+			break
+		}
+	}
+
+	// transactions refunded after their capture was recorded
+	for {
+		rows, err := s.repo.RefundsToPost(ctx, ledgerSyncBatch, s.cutoff)
+		if err != nil || len(rows) == 0 {
+			return posted, err
+		}
+		entries := make([]ledger.Entry, 0, len(rows))
+		for _, t := range rows {
+			entries = append(entries, refundEntry(t))
+		}
+		n, err := s.repo.Post(ctx, entries...)
+		posted += n
+		if err != nil || len(rows) < ledgerSyncBatch {
+			return posted, err
+		}
+	}
+}
+
+// refundEntry books a refund on the settlement date of the refunded transaction. Transactions
+// carry no refund time, so it occurs at the transaction's own paid_at, which keeps balances
+// as of a past time the same however late the ledger catches up.
+func refundEntry(t repositories.LedgerTransactionRow) ledger.Entry {
+	return ledger.Refund(t.MerchantID, strconv.FormatInt(t.ID, 10), t.AmountCents, t.FeeCents, t.PaidAt, t.ValueDate)
+}
+
+// Follow syncs the ledger every interval until ctx is done
+func (s *ledgerService) Follow(ctx context.Context, interval time.Duration) {
+	for {
+		start := time.Now()
+		if n, err := s.Sync(ctx); err != nil {
+			log.Printf("Ledger sync failed after posting %d entries: %v", n, err)
+		} else if n > 0 {
+			log.Printf("Ledger sync posted %d entries in %v", n, time.Since(start))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// MerchantBalance returns what is owed to the merchant from entries that occurred up to at
+func (s *ledgerService) MerchantBalance(ctx context.Context, merchantID string, at time.Time) (int64, error) {
+	balance, err := s.repo.Balance(ctx, ledger.MerchantPayable(merchantID), at)
+	return -balance, err
+}
+
+// Check compares the ledger with the settlements table for settlement dates in range (inclusive)
+func (s *ledgerService) Check(ctx context.Context, from, to time.Time) (*LedgerCheck, error) {
+	mismatches, err := s.repo.Mismatches(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	if mismatches == nil {
+		mismatches = []repositories.LedgerMismatch{}
+	}
+	return &LedgerCheck{
+		From:       from.Format("2006-01-02"),
+		To:         to.Format("2006-01-02"),
+		Consistent: len(mismatches) == 0,
+		Mismatches: mismatches,
+	}, nil
+}
@@ -0,0 +1,168 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"be/internal/models"
+	"be/internal/repositories"
+)
+
+var (
+	ErrInvalidTimezone = errors.New("INVALID_TIMEZONE")
+	ErrInvalidSchedule = errors.New("INVALID_PAYOUT_SCHEDULE")
+	ErrInvalidReserve  = errors.New("INVALID_RESERVE")
+)
+
+// MerchantSettings is the configurable part of a merchant
+type MerchantSettings struct {
+	Timezone       string
+	Cutoff         string
+	PayoutSchedule string
+	PayoutLagDays  *int
+	PayoutWeekday  *int
+	ReserveBps     *int
+	ReserveDays    *int
+}
+
+type MerchantService interface {
+	Get(ctx context.Context, id string) (*models.Merchant, error)
+	Configure(ctx context.Context, id string, settings MerchantSettings) (*models.Merchant, error)
+	PayoutSchedule(ctx context.Context, id string, from, to time.Time) ([]models.PayoutPeriod, error)
+	Reserves(ctx context.Context, id string, asOf time.Time) (*models.ReserveBalance, error)
+}
+
+type merchantService struct {
+	repo     repositories.MerchantRepository
+	stRepo   repositories.SettlementRepository
+	rsvRepo  repositories.ReserveRepository
+	holidays *HolidayCalendar
+}
+
+func NewMerchantService(repo repositories.MerchantRepository, stRepo repositories.SettlementRepository, rsvRepo repositories.ReserveRepository, holidays *HolidayCalendar) MerchantService {
+	return &merchantService{repo: repo, stRepo: stRepo, rsvRepo: rsvRepo, holidays: holidays}
+}
+
+func (s *merchantService) Get(ctx context.Context, id string) (*models.Merchant, error) {
+	return s.repo.Get(ctx, id)
+}
+
+// Configure validates and stores the merchant settings.
+// An empty cut-off makes the merchant follow the global cut-off.
+func (s *merchantService) Configure(ctx context.Context, id string, settings MerchantSettings) (*models.Merchant, error) {
+	m := defaultMerchant(id)
+	if settings.Timezone != "" {
+		m.Timezone = settings.Timezone
+	}
+	if err := s.checkTimezone(ctx, m.Timezone); err != nil {
+		return nil, err
+	}
+	if settings.Cutoff != "" {
+		d, err := ParseCutoff(settings.Cutoff)
+		if err != nil {
+			return nil, err
+		}
+		c := repositories.FormatCutoff(d)
+		m.Cutoff = &c
+	}
+	if settings.PayoutSchedule != "" {
+		m.PayoutSchedule = settings.PayoutSchedule
+	}
+	if settings.PayoutLagDays != nil {
+		m.PayoutLagDays = *settings.PayoutLagDays
+	}
+	m.PayoutWeekday = settings.PayoutWeekday
+	if err := validateSchedule(m); err != nil {
+		return nil, err
+	}
+	if settings.ReserveBps != nil {
+		m.ReserveBps = *settings.ReserveBps
+	}
+	if settings.ReserveDays != nil {
+		m.ReserveDays = *settings.ReserveDays
+	}
+	if m.ReserveBps < 0 || m.ReserveBps > 10000 || m.ReserveDays < 0 || (m.ReserveBps > 0 && m.ReserveDays == 0) {
+		return nil, ErrInvalidReserve
+	}
+	return s.repo.Upsert(ctx, m)
+}
+
+// checkTimezone accepts IANA zone names both Go and Postgres know. "Local" is the server's zone in Go
+// but not a zone in Postgres, and each side has names the other lacks.
+func (s *merchantService) checkTimezone(ctx context.Context, name string) error {
+	if name == "" || name == "Local" {
+		return ErrInvalidTimezone
+	}
+	if _, err := time.LoadLocation(name); err != nil {
+		return ErrInvalidTimezone
+	}
+	ok, err := s.repo.KnownTimezone(ctx, name)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidTimezone
+	}
+	return nil
+}
+
+// PayoutSchedule groups the merchant's settlements in range into payable periods
+func (s *merchantService) PayoutSchedule(ctx context.Context, id string, from, to time.Time) ([]models.PayoutPeriod, error) {
+	m, err := s.repo.Get(ctx, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		m = defaultMerchant(id)
+	} else if err != nil {
+		return nil, err
+	}
+	settlements, err := s.stRepo.ListByMerchant(ctx, id, from, to)
+	if err != nil {
+		return nil, err
+	}
+	return buildPayoutSchedule(m, settlements, s.holidays), nil
+}
+
+// Reserves returns the merchant's reserve totals as of a date along with its latest reserves
+func (s *merchantService) Reserves(ctx context.Context, id string, asOf time.Time) (*models.ReserveBalance, error) {
+	held, due, released, err := s.rsvRepo.Totals(ctx, id, asOf)
+	if err != nil {
+		return nil, err
+	}
+	reserves, err := s.rsvRepo.ListByMerchant(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return &models.ReserveBalance{
+		MerchantID:    id,
+		AsOf:          asOf.Format("2006-01-02"),
+		HeldCents:     held,
+		DueCents:      due,
+		ReleasedCents: released,
+		Reserves:      reserves,
+	}, nil
+}
+
+// defaultMerchant is the configuration of merchants without a merchants row
+func defaultMerchant(id string) *models.Merchant {
+	return &models.Merchant{ID: id, Timezone: "UTC", PayoutSchedule: repositories.PayoutScheduleDaily, PayoutLagDays: 1}
+}
+
+func validateSchedule(m *models.Merchant) error {
+	if m.PayoutLagDays < 0 {
+		return ErrInvalidSchedule
+	}
+	switch m.PayoutSchedule {
+	case repositories.PayoutScheduleDaily, repositories.PayoutScheduleMonthly:
+		if m.PayoutWeekday != nil {
+			return ErrInvalidSchedule
+		}
+	case repositories.PayoutScheduleWeekly:
+		if m.PayoutWeekday == nil || *m.PayoutWeekday < 0 || *m.PayoutWeekday > 6 {
+			return ErrInvalidSchedule
+		}
+	default:
+		return ErrInvalidSchedule
+	}
+	return nil
+}
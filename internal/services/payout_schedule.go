@@ -0,0 +1,122 @@
+package services
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"be/internal/models"
+	"be/internal/repositories"
+)
+
+// HolidayCalendar holds the non-business dates on which no payout is made.
+// A nil calendar only skips weekends.
+type HolidayCalendar struct {
+	dates map[string]struct{}
+}
+
+// LoadHolidayCalendar reads one YYYY-MM-DD date per line; blank lines and lines starting with # are ignored.
+// Anything after the date on a line is treated as a description.
+func LoadHolidayCalendar(path string) (*HolidayCalendar, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	cal := &HolidayCalendar{dates: map[string]struct{}{}}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		day := strings.Fields(line)[0]
+		if _, err := time.Parse("2006-01-02", day); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid date %q", path, n, day)
+		}
+		cal.dates[day] = struct{}{}
+	}
+	return cal, sc.Err()
+}
+
+// IsBusinessDay reports whether d is neither a weekend nor a holiday
+func (c *HolidayCalendar) IsBusinessDay(d time.Time) bool {
+	if wd := d.Weekday(); wd == time.Saturday || wd == time.Sunday {
+		return false
+	}
+	if c == nil {
+		return true
+	}
+	_, holiday := c.dates[d.Format("2006-01-02")]
+	return !holiday
+}
+
+// nextBusinessDay returns d if it is a business day, otherwise the first business day after it
+func (c *HolidayCalendar) nextBusinessDay(d time.Time) time.Time {
+	for !c.IsBusinessDay(d) {
+		d = d.AddDate(0, 0, 1)
+	}
+	return d
+}
+
+// addBusinessDays returns the n-th business day after d; n == 0 rolls d forward to a business day
+func (c *HolidayCalendar) addBusinessDays(d time.Time, n int) time.Time {
+	for ; n > 0; n-- {
+		d = d.AddDate(0, 0, 1)
+		for !c.IsBusinessDay(d) {
+			d = d.AddDate(0, 0, 1)
+		}
+	}
+	return c.nextBusinessDay(d)
+}
+
+// payoutPeriod returns the period containing the settlement day and the date it is paid out
+func payoutPeriod(m *models.Merchant, day time.Time, cal *HolidayCalendar) (start, end, payout time.Time) {
+	switch m.PayoutSchedule {
+	case repositories.PayoutScheduleWeekly:
+		weekday := time.Monday
+		if m.PayoutWeekday != nil {
+			weekday = time.Weekday(*m.PayoutWeekday)
+		}
+		// the period runs up to the day before the next payout weekday
+		offset := (int(weekday) - int(day.Weekday()) + 7) % 7
+		if offset == 0 {
+			offset = 7
+		}
+		payDay := day.AddDate(0, 0, offset)
+		return payDay.AddDate(0, 0, -7), payDay.AddDate(0, 0, -1), cal.nextBusinessDay(payDay)
+	case repositories.PayoutScheduleMonthly:
+		start = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+		end = start.AddDate(0, 1, -1)
+		return start, end, cal.addBusinessDays(end, m.PayoutLagDays)
+	default:
+		return day, day, cal.addBusinessDays(day, m.PayoutLagDays)
+	}
+}
+
+// buildPayoutSchedule groups date-ordered daily settlements into payable periods
+func buildPayoutSchedule(m *models.Merchant, settlements []models.Settlement, cal *HolidayCalendar) []models.PayoutPeriod {
+	out := []models.PayoutPeriod{}
+	for _, st := range settlements {
+		day := time.Date(st.Date.Year(), st.Date.Month(), st.Date.Day(), 0, 0, 0, 0, time.UTC)
+		start, end, payout := payoutPeriod(m, day, cal)
+		key := start.Format("2006-01-02")
+		if len(out) == 0 || out[len(out)-1].PeriodStart != key {
+			out = append(out, models.PayoutPeriod{
+				PeriodStart: key,
+				PeriodEnd:   end.Format("2006-01-02"),
+				PayoutDate:  payout.Format("2006-01-02"),
+			})
+		}
+		p := &out[len(out)-1]
+		p.GrossCents += st.GrossCents
+		p.FeeCents += st.FeeCents
+		p.NetCents += st.NetCents
+		p.PayableCents += st.PayableCents
+		p.TxnCount += st.TxnCount
+		p.SettlementDays++
+	}
+	return out
+}
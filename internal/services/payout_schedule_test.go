@@ -0,0 +1,95 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"be/internal/models"
+	"be/internal/repositories"
+)
+
+func day(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func testCalendar(t *testing.T) *HolidayCalendar {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "holidays.txt")
+	content := "# test holidays\n\n2025-01-29 Lunar New Year\n2025-03-31\n"
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cal, err := LoadHolidayCalendar(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cal
+}
+
+func TestLoadHolidayCalendarRejectsBadDates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "holidays.txt")
+	if err := os.WriteFile(path, []byte("2025-13-01\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadHolidayCalendar(path); err == nil {
+		t.Fatal("expected error for invalid date")
+	}
+}
+
+func TestPayoutPeriod(t *testing.T) {
+	cal := testCalendar(t)
+	monday, saturday := int(time.Monday), int(time.Saturday)
+	cases := []struct {
+		name                string
+		merchant            models.Merchant
+		day                 string
+		start, end, payDate string
+	}{
+		{"daily T+1", models.Merchant{PayoutSchedule: repositories.PayoutScheduleDaily, PayoutLagDays: 1}, "2025-01-06", "2025-01-06", "2025-01-06", "2025-01-07"},
+		{"daily T+1 skips weekend", models.Merchant{PayoutSchedule: repositories.PayoutScheduleDaily, PayoutLagDays: 1}, "2025-01-10", "2025-01-10", "2025-01-10", "2025-01-13"},
+		{"daily T+2 skips holiday", models.Merchant{PayoutSchedule: repositories.PayoutScheduleDaily, PayoutLagDays: 2}, "2025-01-27", "2025-01-27", "2025-01-27", "2025-01-30"},
+		{"daily T+0 on saturday", models.Merchant{PayoutSchedule: repositories.PayoutScheduleDaily, PayoutLagDays: 0}, "2025-01-11", "2025-01-11", "2025-01-11", "2025-01-13"},
+		{"weekly on monday", models.Merchant{PayoutSchedule: repositories.PayoutScheduleWeekly, PayoutWeekday: &monday}, "2025-01-08", "2025-01-06", "2025-01-12", "2025-01-13"},
+		{"weekly day of payout starts new period", models.Merchant{PayoutSchedule: repositories.PayoutScheduleWeekly, PayoutWeekday: &monday}, "2025-01-13", "2025-01-13", "2025-01-19", "2025-01-20"},
+		{"weekly on holiday monday rolls forward", models.Merchant{PayoutSchedule: repositories.PayoutScheduleWeekly, PayoutWeekday: &monday}, "2025-03-25", "2025-03-24", "2025-03-30", "2025-04-01"},
+		{"weekly on saturday rolls to monday", models.Merchant{PayoutSchedule: repositories.PayoutScheduleWeekly, PayoutWeekday: &saturday}, "2025-01-08", "2025-01-04", "2025-01-10", "2025-01-13"},
+		{"monthly T+1", models.Merchant{PayoutSchedule: repositories.PayoutScheduleMonthly, PayoutLagDays: 1}, "2025-03-15", "2025-03-01", "2025-03-31", "2025-04-01"},
+		{"monthly T+0 on weekend month end", models.Merchant{PayoutSchedule: repositories.PayoutScheduleMonthly, PayoutLagDays: 0}, "2025-05-02", "2025-05-01", "2025-05-31", "2025-06-02"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			start, end, payout := payoutPeriod(&tc.merchant, day(tc.day), cal)
+			got := [3]string{start.Format("2006-01-02"), end.Format("2006-01-02"), payout.Format("2006-01-02")}
+			want := [3]string{tc.start, tc.end, tc.payDate}
+			if got != want {
+				t.Fatalf("payoutPeriod(%s) = %v, want %v", tc.day, got, want)
+			}
+		})
+	}
+}
+
+func TestBuildPayoutScheduleGroupsWeeks(t *testing.T) {
+	monday := int(time.Monday)
+	m := &models.Merchant{PayoutSchedule: repositories.PayoutScheduleWeekly, PayoutWeekday: &monday}
+	var settlements []models.Settlement
+	for d := day("2025-01-10"); !d.After(day("2025-01-14")); d = d.AddDate(0, 0, 1) {
+		settlements = append(settlements, models.Settlement{Date: d, GrossCents: 1000, FeeCents: 30, NetCents: 970, TxnCount: 2})
+	}
+	periods := buildPayoutSchedule(m, settlements, nil)
+	if len(periods) != 2 {
+		t.Fatalf("expected 2 periods, got %d: %+v", len(periods), periods)
+	}
+	first, second := periods[0], periods[1]
+	if first.PeriodStart != "2025-01-06" || first.PayoutDate != "2025-01-13" || first.SettlementDays != 3 || first.NetCents != 2910 {
+		t.Fatalf("unexpected first period: %+v", first)
+	}
+	if second.PeriodStart != "2025-01-13" || second.PayoutDate != "2025-01-20" || second.SettlementDays != 2 || second.TxnCount != 4 {
+		t.Fatalf("unexpected second period: %+v", second)
+	}
+}
@@ -0,0 +1,178 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"be/internal/models"
+	"be/internal/repositories"
+	"be/internal/storage"
+)
+
+// PayoutResult is one line of a bank result file applied to a payout
+type PayoutResult struct {
+	Line     int    `json:"line"`
+	PayoutID int64  `json:"payout_id"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+}
+
+// PayoutResultSummary reports how a bank result file was applied
+type PayoutResultSummary struct {
+	Applied int            `json:"applied"`
+	Failed  int            `json:"failed"`
+	Results []PayoutResult `json:"results"`
+}
+
+type PayoutService interface {
+	StartPayout(ctx context.Context, id string, from, to time.Time, requestedBy string) error
+	Get(ctx context.Context, id int64) (*models.Payout, error)
+	List(ctx context.Context, merchantID, status string) ([]models.Payout, error)
+	Transition(ctx context.Context, id int64, status string, reference, reason *string, actor string) (*models.Payout, error)
+	ApplyResults(ctx context.Context, r io.Reader, actor string) (*PayoutResultSummary, error)
+}
+
+type payoutService struct {
+	repo   repositories.PayoutRepository
+	jobs   repositories.JobRepository
+	jobSvc JobService
+	store  storage.ArtifactStore
+}
+
+func NewPayoutService(repo repositories.PayoutRepository, jobs repositories.JobRepository, jobSvc JobService, store storage.ArtifactStore) PayoutService {
+	ps := &payoutService{repo: repo, jobs: jobs, jobSvc: jobSvc, store: store}
+	jobSvc.Register(repositories.JobTypePayout, ps.run)
+	return ps
+}
+
+// StartPayout prepares a PAYOUT job over unpaid settlements in range and enqueues it
+func (s *payoutService) StartPayout(ctx context.Context, id string, from, to time.Time, requestedBy string) error {
+	merchants, err := s.repo.UnpaidMerchants(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	if err := s.jobs.Create(ctx, id, repositories.JobTypePayout, int64(len(merchants)), from, to, nil, requestedBy); err != nil {
+		return err
+	}
+	s.jobSvc.Enqueue(id)
+	return nil
+}
+
+// run collects each merchant's unpaid settlements into a payout and writes the payout list as CSV
+func (s *payoutService) run(ctx context.Context, job *repositories.JobRow) error {
+	from, to := job.FromDate.Time, job.ToDate.Time
+	merchants, err := s.repo.UnpaidMerchants(ctx, from, to)
+	if err != nil {
+		return err
+	}
+
+	outKey := job.ID + ".csv"
+	f, err := s.store.Create(ctx, outKey)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = f.Abort() }()
+	w := csv.NewWriter(f)
+	_ = w.Write([]string{"payout_id", "merchant_id", "amount_cents", "settlement_count", "period_start", "period_end"})
+
+	for i, merchantID := range merchants {
+		if cancelled, _ := s.jobs.IsCancelRequested(ctx, job.ID); cancelled {
+			log.Printf("Job %s: cancel requested", job.ID)
+			return errJobCanceled
+		}
+		p, err := s.repo.CollectUnpaid(ctx, job.ID, merchantID, from, to)
+		if err != nil {
+			return err
+		}
+		if p != nil {
+			_ = w.Write([]string{strconv.FormatInt(p.ID, 10), p.MerchantID, strconv.FormatInt(p.AmountCents, 10), strconv.Itoa(p.SettlementCount),
+				p.PeriodStart.Format("2006-01-02"), p.PeriodEnd.Format("2006-01-02")})
+		}
+		_ = s.jobs.SetProgress(ctx, job.ID, int64(i+1))
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return s.jobs.SetCompleted(ctx, job.ID, outKey)
+}
+
+func (s *payoutService) Get(ctx context.Context, id int64) (*models.Payout, error) {
+	return s.repo.Get(ctx, id)
+}
+
+func (s *payoutService) List(ctx context.Context, merchantID, status string) ([]models.Payout, error) {
+	return s.repo.List(ctx, merchantID, status)
+}
+
+func (s *payoutService) Transition(ctx context.Context, id int64, status string, reference, reason *string, actor string) (*models.Payout, error) {
+	return s.repo.Transition(ctx, id, status, reference, reason, actor)
+}
+
+// ApplyResults applies a bank result CSV with the header payout_id,status,bank_reference,failure_reason.
+// Each line is applied on its own, audited as actor; invalid lines are reported without stopping the rest.
+func (s *payoutService) ApplyResults(ctx context.Context, r io.Reader, actor string) (*PayoutResultSummary, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		cols[strings.TrimSpace(strings.ToLower(h))] = i
+	}
+	if _, ok := cols["payout_id"]; !ok {
+		return nil, fmt.Errorf("missing payout_id column")
+	}
+	if _, ok := cols["status"]; !ok {
+		return nil, fmt.Errorf("missing status column")
+	}
+	field := func(rec []string, name string) *string {
+		i, ok := cols[name]
+		if !ok || i >= len(rec) || strings.TrimSpace(rec[i]) == "" {
+			return nil
+		}
+		v := strings.TrimSpace(rec[i])
+		return &v
+	}
+
+	summary := &PayoutResultSummary{Results: []PayoutResult{}}
+	for line := 2; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		res := PayoutResult{Line: line}
+		if v := field(rec, "status"); v != nil {
+			res.Status = strings.ToUpper(*v)
+		}
+		if v := field(rec, "payout_id"); v != nil {
+			res.PayoutID, err = strconv.ParseInt(*v, 10, 64)
+		}
+		if err != nil || res.PayoutID == 0 {
+			res.Error = "invalid payout_id"
+		} else if _, err := s.repo.Transition(ctx, res.PayoutID, res.Status, field(rec, "bank_reference"), field(rec, "failure_reason"), actor); err != nil {
+			res.Error = err.Error()
+		}
+		if res.Error == "" {
+			summary.Applied++
+		} else {
+			summary.Failed++
+		}
+		summary.Results = append(summary.Results, res)
+	}
+	return summary, nil
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"context"
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"be/internal/models"
+	"be/internal/repositories"
+	"be/internal/storage"
+)
+
+// Reconciliation issues
+const (
+	ReconMissing  = "MISSING"  // recomputed, but no settlements row
+	ReconExtra    = "EXTRA"    // settlements row, but nothing recomputed for it
+	ReconMismatch = "MISMATCH" // both exist with different amounts
+)
+
+// ReconciliationSummary is shown on the job status endpoint of a RECONCILIATION job
+type ReconciliationSummary struct {
+	Days               int   `json:"days"`
+	Matched            int   `json:"matched"`
+	Missing            int   `json:"missing"`
+	Extra              int   `json:"extra"`
+	Mismatched         int   `json:"mismatched"`
+	StoredNetCents     int64 `json:"stored_net_cents"`
+	RecomputedNetCents int64 `json:"recomputed_net_cents"`
+}
+
+// reconDiff is one merchant/day that does not reconcile
+type reconDiff struct {
+	key        settlementKey
+	issue      string
+	fields     []string
+	stored     settlementTotals
+	recomputed settlementTotals
+}
+
+type ReconciliationService interface {
+	StartReconciliation(ctx context.Context, id string, from, to time.Time, requestedBy string) error
+}
+
+type reconciliationService struct {
+	jobs   repositories.JobRepository
+	stRepo repositories.SettlementRepository
+	jobSvc JobService
+	calc   *settlementCalculator
+	store  storage.ArtifactStore
+}
+
+// NewReconciliationService registers the RECONCILIATION job runner. It recomputes settlements with the
+// settlement job's rules from the same repositories and never writes to settlements.
+func NewReconciliationService(jobs repositories.JobRepository, t repositories.TransactionRepository, s repositories.SettlementRepository, a repositories.AdjustmentRepository, rsv repositories.ReserveRepository, jobSvc JobService, workers, partitions int, memBudget int64, cutoff time.Duration, store storage.ArtifactStore) ReconciliationService {
+	rs := &reconciliationService{
+		jobs:   jobs,
+		stRepo: s,
+		jobSvc: jobSvc,
+		calc:   &settlementCalculator{txRepo: t, adjRepo: a, rsvRepo: rsv, workers: workers, partitions: partitions, memBudget: memBudget, cutoff: cutoff},
+		store:  store,
+	}
+	jobSvc.Register(repositories.JobTypeReconciliation, rs.run)
+	return rs
+}
+
+// StartReconciliation records a RECONCILIATION job over settlement dates in range and enqueues it
+func (s *reconciliationService) StartReconciliation(ctx context.Context, id string, from, to time.Time, requestedBy string) error {
+	total, err := s.calc.txRepo.CountInRange(ctx, from, to, s.calc.cutoff)
+	if err != nil {
+		return err
+	}
+	if err := s.jobs.Create(ctx, id, repositories.JobTypeReconciliation, total, from, to, nil, requestedBy); err != nil {
+		return err
+	}
+	s.jobSvc.Enqueue(id)
+	return nil
+}
+
+// run recomputes the range, diffs it against the stored settlements and writes the differences as CSV
+func (s *reconciliationService) run(ctx context.Context, job *repositories.JobRow) error {
+	from, to := job.FromDate.Time, job.ToDate.Time
+
+	// The recompute and the stored rows are read in one REPEATABLE READ snapshot, as settlement runs
+	// are, so a run committing in between never shows up as a difference
+	snap, err := s.calc.txRepo.BeginSnapshot(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = snap.Close() }()
+	readCtx := snap.Context(ctx)
+	total, err := s.calc.txRepo.CountInRange(readCtx, from, to, s.calc.cutoff)
+	if err != nil {
+		return err
+	}
+	if err := s.jobs.SetSnapshot(ctx, job.ID, snap.At, snap.Txs, total); err != nil {
+		return err
+	}
+	result, err := s.calc.compute(readCtx, from, to, nil, func(processed int64) error {
+		if canceled, _ := s.jobs.IsCancelRequested(ctx, job.ID); canceled {
+			return errJobCanceled
+		}
+		return s.jobs.SetProgress(ctx, job.ID, processed)
+	})
+	if err != nil {
+		return err
+	}
+	defer func() { _ = result.close() }()
+	stored, err := s.stRepo.ListInRange(readCtx, from, to, nil)
+	if err != nil {
+		return err
+	}
+	_ = snap.Close()
+
+	// the recomputed rows stream from the aggregation against the stored ones, so only the
+	// stored range is held in memory
+	var summary ReconciliationSummary
+	outKey := job.ID + ".csv"
+	err = storage.Put(ctx, s.store, outKey, func(w io.Writer) error {
+		report, err := newReconReport(w)
+		if err != nil {
+			return err
+		}
+		if summary, err = reconcile(stored, result.each, report.write); err != nil {
+			return err
+		}
+		return report.close()
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.jobs.SetSummary(ctx, job.ID, summary); err != nil {
+		return err
+	}
+	return s.jobs.SetCompleted(ctx, job.ID, outKey)
+}
+
+// storedTotals converts a settlements row for comparison
+func storedTotals(st models.Settlement) settlementTotals {
+	return settlementTotals{
+		gross:    st.GrossCents,
+		fee:      st.FeeCents,
+		net:      st.NetCents,
+		adj:      st.AdjustmentCents,
+		reserved: st.ReservedCents,
+		released: st.ReleasedCents,
+		count:    st.TxnCount,
+	}
+}
+
+// reconField names a compared amount and reads it from totals
+type reconField struct {
+	name string
+	get  func(settlementTotals) int64
+}
+
+var reconFields = []reconField{
+	{"gross", func(v settlementTotals) int64 { return v.gross }},
+	{"fee", func(v settlementTotals) int64 { return v.fee }},
+	{"adjustments", func(v settlementTotals) int64 { return v.adj }},
+	{"net", func(v settlementTotals) int64 { return v.net }},
+	{"reserved", func(v settlementTotals) int64 { return v.reserved }},
+	{"released", func(v settlementTotals) int64 { return v.released }},
+	{"payable", settlementTotals.payable},
+	{"txn_count", func(v settlementTotals) int64 { return v.count }},
+}
+
+// reconcile merges the stored settlement rows with the recomputed ones that each yields in merchant
+// and date order, and passes every merchant/day that does not reconcile to diff in that order
+func reconcile(stored []models.Settlement, each func(func(settlementKey, settlementTotals) error) error, diff func(reconDiff) error) (ReconciliationSummary, error) {
+	var summary ReconciliationSummary
+	// the database orders merchant ids by its collation; the merge needs the byte order of keyLess
+	sort.SliceStable(stored, func(i, j int) bool { return keyLess(storedKey(stored[i]), storedKey(stored[j])) })
+	for _, st := range stored {
+		summary.StoredNetCents += st.NetCents
+	}
+	next := 0
+	// extra reports the stored rows before until that nothing was recomputed for, or all left when it is nil
+	extra := func(until *settlementKey) error {
+		for ; next < len(stored); next++ {
+			k := storedKey(stored[next])
+			if until != nil && !keyLess(k, *until) {
+				return nil
+			}
+			summary.Days++
+			summary.Extra++
+			if err := diff(reconDiff{key: k, issue: ReconExtra, stored: storedTotals(stored[next])}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	err := each(func(k settlementKey, rc settlementTotals) error {
+		if err := extra(&k); err != nil {
+			return err
+		}
+		summary.Days++
+		summary.RecomputedNetCents += rc.net
+		d := reconDiff{key: k, recomputed: rc}
+		if next == len(stored) || storedKey(stored[next]) != k {
+			d.issue = ReconMissing
+			summary.Missing++
+			return diff(d)
+		}
+		d.stored = storedTotals(stored[next])
+		next++
+		for _, f := range reconFields {
+			if f.get(d.stored) != f.get(rc) {
+				d.fields = append(d.fields, f.name)
+			}
+		}
+		if len(d.fields) == 0 {
+			summary.Matched++
+			return nil
+		}
+		d.issue = ReconMismatch
+		summary.Mismatched++
+		return diff(d)
+	})
+	if err != nil {
+		return summary, err
+	}
+	return summary, extra(nil)
+}
+
+func storedKey(st models.Settlement) settlementKey {
+	return settlementKey{merchant: st.MerchantID, day: st.Date.Format("2006-01-02")}
+}
+
+// reconReport writes the differences as CSV, one row each
+type reconReport struct {
+	w *csv.Writer
+}
+
+// newReconReport writes the report header
+func newReconReport(f io.Writer) (*reconReport, error) {
+	w := csv.NewWriter(f)
+	header := []string{"merchant_id", "date", "issue", "fields"}
+	for _, fl := range reconFields {
+		header = append(header, "stored_"+fl.name, "recomputed_"+fl.name)
+	}
+	return &reconReport{w: w}, w.Write(header)
+}
+
+func (r *reconReport) write(d reconDiff) error {
+	rec := []string{d.key.merchant, d.key.day, d.issue, strings.Join(d.fields, ";")}
+	for _, fl := range reconFields {
+		rec = append(rec, strconv.FormatInt(fl.get(d.stored), 10), strconv.FormatInt(fl.get(d.recomputed), 10))
+	}
+	return r.w.Write(rec)
+}
+
+func (r *reconReport) close() error {
+	r.w.Flush()
+	return r.w.Error()
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"be/internal/models"
+)
+
+func TestReconcile(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	stored := []models.Settlement{
+		{MerchantID: "m-001", Date: day("2025-01-01"), GrossCents: 1000, FeeCents: 30, NetCents: 970, PayableCents: 970, TxnCount: 1},
+		{MerchantID: "m-001", Date: day("2025-01-02"), GrossCents: 1000, FeeCents: 30, NetCents: 970, PayableCents: 970, TxnCount: 1},
+		{MerchantID: "m-002", Date: day("2025-01-01"), GrossCents: 500, FeeCents: 30, NetCents: 470, PayableCents: 470, TxnCount: 1},
+	}
+	recomputed := map[settlementKey]settlementTotals{
+		{"m-001", "2025-01-01"}: {gross: 1000, fee: 30, net: 970, count: 1},
+		{"m-001", "2025-01-02"}: {gross: 1500, fee: 45, net: 1455, count: 2},
+		{"m-003", "2025-01-01"}: {gross: 200, fee: 30, net: 170, count: 1},
+	}
+
+	each := func(fn func(settlementKey, settlementTotals) error) error {
+		for _, k := range sortedKeys(recomputed) {
+			if err := fn(k, recomputed[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	var diffs []reconDiff
+	summary, err := reconcile(stored, each, func(d reconDiff) error {
+		diffs = append(diffs, d)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ReconciliationSummary{Days: 4, Matched: 1, Missing: 1, Extra: 1, Mismatched: 1, StoredNetCents: 2410, RecomputedNetCents: 2595}
+	if summary != want {
+		t.Fatalf("summary = %+v, want %+v", summary, want)
+	}
+	got := make([]string, len(diffs))
+	for i, d := range diffs {
+		got[i] = d.key.merchant + " " + d.key.day + " " + d.issue
+	}
+	wantDiffs := []string{"m-001 2025-01-02 MISMATCH", "m-002 2025-01-01 EXTRA", "m-003 2025-01-01 MISSING"}
+	if len(got) != len(wantDiffs) {
+		t.Fatalf("diffs = %v, want %v", got, wantDiffs)
+	}
+	for i := range got {
+		if got[i] != wantDiffs[i] {
+			t.Errorf("diff %d = %q, want %q", i, got[i], wantDiffs[i])
+		}
+	}
+	if fields := diffs[0].fields; len(fields) != 5 || fields[0] != "gross" || fields[4] != "txn_count" {
+		t.Errorf("mismatched fields = %v", fields)
+	}
+}
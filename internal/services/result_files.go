@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"be/internal/results"
+	"be/internal/storage"
+)
+
+var ErrInvalidFormat = errors.New("INVALID_FORMAT")
+
+// DefaultResultFormat is the format of a settlement job that does not choose one
+const DefaultResultFormat = "csv"
+
+// normalizeFormats validates the requested result formats and drops duplicates; none means CSV
+func normalizeFormats(formats []string) ([]string, error) {
+	if len(formats) == 0 {
+		return []string{DefaultResultFormat}, nil
+	}
+	seen := map[string]bool{}
+	out := make([]string, 0, len(formats))
+	for _, f := range formats {
+		if _, ok := results.Get(f); !ok {
+			return nil, ErrInvalidFormat
+		}
+		if !seen[f] {
+			seen[f] = true
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+// ResultKey returns the artifact a job writes in format
+func ResultKey(jobID, format string) string {
+	f, ok := results.Get(format)
+	if !ok {
+		return ""
+	}
+	return jobID + f.Extension()
+}
+
+// resultFiles writes the same table to one artifact per format; the first is the job's primary result.
+// Close also stores the table's preview index.
+type resultFiles struct {
+	store   storage.ArtifactStore
+	ctx     context.Context
+	jobID   string
+	keys    []string
+	files   []storage.Writer
+	writers []results.ResultWriter
+	extra   []string
+
+	cols    []results.Column
+	rows    int64
+	totals  []int64
+	preview *previewIndex
+}
+
+func createResultFiles(ctx context.Context, store storage.ArtifactStore, jobID string, formats []string, cols []results.Column) (*resultFiles, error) {
+	names, ints := make([]string, len(cols)), make([]bool, len(cols))
+	for i, c := range cols {
+		names[i], ints[i] = c.Name, c.Int
+	}
+	rf := &resultFiles{store: store, ctx: ctx, jobID: jobID, cols: cols, totals: make([]int64, len(cols)), preview: newPreviewIndex(names, ints)}
+	for _, name := range formats {
+		format, ok := results.Get(name)
+		if !ok {
+			rf.remove()
+			return nil, ErrInvalidFormat
+		}
+		key := ResultKey(jobID, name)
+		f, err := store.Create(ctx, key)
+		if err != nil {
+			rf.remove()
+			return nil, err
+		}
+		rf.keys = append(rf.keys, key)
+		rf.files = append(rf.files, f)
+		w, err := format.NewWriter(f, cols)
+		if err != nil {
+			rf.remove()
+			return nil, err
+		}
+		rf.writers = append(rf.writers, w)
+	}
+	return rf, nil
+}
+
+func (rf *resultFiles) primary() string { return rf.keys[0] }
+
+// track adds another artifact of the job that remove deletes
+func (rf *resultFiles) track(key string) { rf.extra = append(rf.extra, key) }
+
+// artifacts lists every artifact of the job: the result files, then the tracked ones
+func (rf *resultFiles) artifacts() []string {
+	return append(append([]string{}, rf.keys...), rf.extra...)
+}
+
+func (rf *resultFiles) WriteRow(r results.Row) error {
+	for _, w := range rf.writers {
+		if err := w.WriteRow(r); err != nil {
+			return err
+		}
+	}
+	rf.rows++
+	rf.preview.add(r)
+	for i, c := range rf.cols {
+		if v, ok := r[i].(int64); ok && c.Int {
+			rf.totals[i] += v
+		}
+	}
+	return nil
+}
+
+// stats returns the rows written so far and the totals of the integer columns
+func (rf *resultFiles) stats() *tableStats {
+	st := &tableStats{rows: rf.rows, totals: map[string]int64{}}
+	for i, c := range rf.cols {
+		if c.Int {
+			st.totals[c.Name] = rf.totals[i]
+		}
+	}
+	return st
+}
+
+// Close completes every file and stores it, then the preview index
+func (rf *resultFiles) Close() error {
+	var errs []error
+	for i, w := range rf.writers {
+		errs = append(errs, w.Close(), rf.files[i].Close())
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	rf.track(PreviewKey(rf.jobID))
+	return storage.Put(rf.ctx, rf.store, PreviewKey(rf.jobID), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(rf.preview)
+	})
+}
+
+// remove discards the files, stored or not, for a failed job. It runs even when the job's
+// context is canceled.
+func (rf *resultFiles) remove() {
+	ctx := context.WithoutCancel(rf.ctx)
+	for i, f := range rf.files {
+		_ = f.Abort()
+		_ = rf.store.Delete(ctx, rf.keys[i])
+	}
+	for _, key := range rf.extra {
+		_ = rf.store.Delete(ctx, key)
+	}
+}
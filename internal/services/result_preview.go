@@ -0,0 +1,534 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"sync"
+
+	"be/internal/repositories"
+	"be/internal/storage"
+)
+
+var (
+	ErrPreviewUnsupported = errors.New("PREVIEW_NOT_SUPPORTED")
+	ErrInvalidCursor      = errors.New("INVALID_CURSOR")
+)
+
+// Page sizes of result previews
+const (
+	DefaultPreviewLimit = 100
+	MaxPreviewLimit     = 1000
+)
+
+// previewTotals are the columns a preview sums, when the result has them
+var previewTotals = []string{"gross", "fee", "net", "txn_count"}
+
+// previewCacheSize is how many scanned preview indexes a service keeps
+const previewCacheSize = 256
+
+// PreviewQuery selects a page of a job's result rows
+type PreviewQuery struct {
+	MerchantID string // only rows of this merchant when set
+	Cursor     string // next_cursor of the previous page; empty for the first
+	Limit      int
+}
+
+// PreviewSummary counts and sums every row the query matches, not just the page
+type PreviewSummary struct {
+	Rows   int64            `json:"rows"`
+	Totals map[string]int64 `json:"totals"`
+}
+
+// ResultPreview is one page of a job's result rows, read from its stored artifact
+type ResultPreview struct {
+	JobID      string           `json:"job_id"`
+	Artifact   string           `json:"artifact"`
+	Columns    []string         `json:"columns"`
+	Rows       []map[string]any `json:"rows"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Summary    PreviewSummary   `json:"summary"`
+}
+
+// previewReader reads the rows of a result table; values are strings, or JSON scalars with json.Number
+// numbers when the reader is typed
+type previewReader interface {
+	Columns() []string
+	Next() ([]any, error)
+	Typed() bool
+}
+
+// PreviewKey returns the preview index a job writes next to its results
+func PreviewKey(jobID string) string {
+	return jobID + ".preview.json"
+}
+
+// previewIndex is what a preview needs to know of a whole result: its columns, which of them hold
+// integers, and the count and totals of its rows, overall and per merchant. Jobs writing a result
+// table store it as an artifact; for other results it is built by reading the artifact once.
+type previewIndex struct {
+	Columns   []string                  `json:"columns"`
+	Ints      []bool                    `json:"ints"`
+	Summary   PreviewSummary            `json:"summary"`
+	Merchants map[string]PreviewSummary `json:"merchants,omitempty"` // when there is a merchant_id column
+
+	merchantCol int
+	totalCols   map[int]string
+}
+
+func newPreviewIndex(cols []string, ints []bool) *previewIndex {
+	x := &previewIndex{Columns: cols, Ints: ints, Summary: PreviewSummary{Totals: map[string]int64{}}}
+	x.init()
+	if x.merchantCol >= 0 {
+		x.Merchants = map[string]PreviewSummary{}
+	}
+	for _, c := range x.totalCols {
+		x.Summary.Totals[c] = 0
+	}
+	return x
+}
+
+// init finds the merchant and total columns
+func (x *previewIndex) init() {
+	x.merchantCol, x.totalCols = -1, map[int]string{}
+	for i, c := range x.Columns {
+		if c == "merchant_id" {
+			x.merchantCol = i
+		}
+		for _, t := range previewTotals {
+			if c == t {
+				x.totalCols[i] = c
+			}
+		}
+	}
+}
+
+// add counts a row in the overall and its merchant's summary
+func (x *previewIndex) add(row []any) {
+	x.Summary.Rows++
+	var m PreviewSummary
+	if x.merchantCol >= 0 {
+		if m = x.Merchants[fmt.Sprint(row[x.merchantCol])]; m.Totals == nil {
+			m.Totals = map[string]int64{}
+			for _, c := range x.totalCols {
+				m.Totals[c] = 0
+			}
+		}
+		m.Rows++
+	}
+	for i, c := range x.totalCols {
+		if v, ok := previewInt(row[i]); ok {
+			x.Summary.Totals[c] += v
+			if m.Totals != nil {
+				m.Totals[c] += v
+			}
+		}
+	}
+	if x.merchantCol >= 0 {
+		x.Merchants[fmt.Sprint(row[x.merchantCol])] = m
+	}
+}
+
+// summary returns the count and totals of the rows of merchantID, or of every row when it is empty
+func (x *previewIndex) summary(merchantID string) (PreviewSummary, error) {
+	if merchantID == "" {
+		return x.Summary, nil
+	}
+	if x.merchantCol < 0 {
+		return PreviewSummary{}, ErrPreviewUnsupported
+	}
+	if m, ok := x.Merchants[merchantID]; ok {
+		return m, nil
+	}
+	m := PreviewSummary{Totals: map[string]int64{}}
+	for _, c := range x.totalCols {
+		m.Totals[c] = 0
+	}
+	return m, nil
+}
+
+// scanPreviewIndex reads every row of a result without a stored index. In an untyped result, the
+// integer columns are the ones whose values all are.
+func scanPreviewIndex(pr previewReader) (*previewIndex, error) {
+	cols := pr.Columns()
+	if cols == nil {
+		cols = []string{}
+	}
+	ints := make([]bool, len(cols))
+	for i := range ints {
+		ints[i] = !pr.Typed()
+	}
+	x := newPreviewIndex(cols, ints)
+	for n := int64(0); ; n++ {
+		row, err := pr.Next()
+		if err == io.EOF {
+			return x, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != len(cols) {
+			return nil, fmt.Errorf("result row %d has %d values, want %d", n+1, len(row), len(cols))
+		}
+		for i, v := range row {
+			if x.Ints[i] {
+				_, ok := previewInt(v)
+				x.Ints[i] = ok
+			}
+		}
+		x.add(row)
+	}
+}
+
+// previewCache keeps the indexes scanned from artifacts, which never change once written, so a result
+// without a stored index is read whole only for its first page
+type previewCache struct {
+	mu      sync.Mutex
+	entries map[string]*previewIndex
+	order   []string // oldest first
+}
+
+func (c *previewCache) get(key string) *previewIndex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key]
+}
+
+func (c *previewCache) put(key string, x *previewIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = map[string]*previewIndex{}
+	}
+	if _, ok := c.entries[key]; !ok {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = x
+	for len(c.order) > previewCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// previewKey picks the artifact of a completed job a preview reads: the first CSV or NDJSON result
+// of a settlement job, or the result of another job when it is one
+func previewKey(jr *repositories.JobRow) (string, error) {
+	if jr.Status != repositories.JobStatusCompleted || !jr.ResultPath.Valid || jr.ArtifactExpiredAt.Valid {
+		return "", ErrArtifactNotFound
+	}
+	if jr.Type == repositories.JobTypeSettlement {
+		var params SettlementParams
+		if err := jr.DecodeParams(&params); err != nil {
+			return "", err
+		}
+		for _, f := range params.Formats {
+			if f == "csv" || f == "ndjson" {
+				return ResultKey(jr.ID, f), nil
+			}
+		}
+	}
+	// older jobs stored a file path as their result; its base name is the artifact key
+	key := path.Base(jr.ResultPath.String)
+	switch path.Ext(key) {
+	case ".csv", ".ndjson":
+		return key, nil
+	}
+	return "", ErrPreviewUnsupported
+}
+
+// PreviewResults returns a page of a completed job's result rows from the artifact it stored, so
+// they match the file exactly, with the count and totals of every row the query matches. Those come
+// from the job's preview index, so the artifact is only read up to the end of the page.
+func (s *jobService) PreviewResults(ctx context.Context, jobID string, q PreviewQuery) (*ResultPreview, error) {
+	jr, err := s.jobs.Get(ctx, jobID)
+	if err != nil {
+		return nil, ErrArtifactNotFound
+	}
+	key, err := previewKey(jr)
+	if err != nil {
+		return nil, err
+	}
+	start, err := decodeCursor(q.Cursor)
+	if err != nil {
+		return nil, err
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultPreviewLimit
+	}
+	q.Limit = min(q.Limit, MaxPreviewLimit)
+
+	idx, err := s.previewIndex(ctx, jr.ID, key)
+	if err != nil {
+		return nil, err
+	}
+	pr, done, err := s.openPreview(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+	p, err := previewPage(pr, idx, q.MerchantID, start, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	p.JobID, p.Artifact = jr.ID, key
+	return p, nil
+}
+
+// openPreview opens a result artifact for reading rows
+func (s *jobService) openPreview(ctx context.Context, key string) (previewReader, func(), error) {
+	rc, _, err := s.store.Open(ctx, key)
+	if err != nil {
+		return nil, nil, ErrArtifactNotFound
+	}
+	if path.Ext(key) == ".ndjson" {
+		return newNDJSONPreviewReader(rc), func() { rc.Close() }, nil
+	}
+	pr, err := newCSVPreviewReader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, nil, err
+	}
+	return pr, func() { rc.Close() }, nil
+}
+
+// previewIndex returns the index of a job's result: the one the job stored, or one read from the
+// artifact once and then cached
+func (s *jobService) previewIndex(ctx context.Context, jobID, key string) (*previewIndex, error) {
+	if x := s.previews.get(key); x != nil {
+		return x, nil
+	}
+	var x *previewIndex
+	rc, _, err := s.store.Open(ctx, PreviewKey(jobID))
+	switch {
+	case err == nil:
+		defer rc.Close()
+		x = &previewIndex{}
+		if err := json.NewDecoder(rc).Decode(x); err != nil {
+			return nil, err
+		}
+		x.init()
+	case errors.Is(err, storage.ErrNotFound):
+		pr, done, err := s.openPreview(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		if x, err = scanPreviewIndex(pr); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, err
+	}
+	s.previews.put(key, x)
+	return x, nil
+}
+
+// previewPage keeps up to limit rows matching merchantID from row start on, and stops reading once the
+// page is full and the next matching row is found, or every row the index counted was seen.
+// Integers are returned as numbers.
+func previewPage(pr previewReader, idx *previewIndex, merchantID string, start int64, limit int) (*ResultPreview, error) {
+	summary, err := idx.summary(merchantID)
+	if err != nil {
+		return nil, err
+	}
+	cols := idx.Columns
+	p := &ResultPreview{Columns: cols, Rows: []map[string]any{}, Summary: summary}
+	var page [][]any
+	var matched int64
+	for n := int64(0); matched < summary.Rows; n++ {
+		row, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(row) != len(cols) {
+			return nil, fmt.Errorf("result row %d has %d values, want %d", n+1, len(row), len(cols))
+		}
+		if merchantID != "" && fmt.Sprint(row[idx.merchantCol]) != merchantID {
+			continue
+		}
+		matched++
+		if n < start {
+			continue
+		}
+		if len(page) == limit {
+			p.NextCursor = encodeCursor(n)
+			break
+		}
+		page = append(page, row)
+	}
+	for _, row := range page {
+		obj := make(map[string]any, len(cols))
+		for i, v := range row {
+			if _, num := v.(json.Number); num || idx.Ints[i] {
+				if iv, ok := previewInt(v); ok {
+					obj[cols[i]] = iv
+					continue
+				}
+			}
+			obj[cols[i]] = v
+		}
+		p.Rows = append(p.Rows, obj)
+	}
+	return p, nil
+}
+
+// previewInt reads an integer result value
+func previewInt(v any) (int64, bool) {
+	var s string
+	switch v := v.(type) {
+	case int64:
+		return v, true
+	case string:
+		s = v
+	case json.Number:
+		s = v.String()
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	return n, err == nil
+}
+
+// A cursor is the position in the result of the next row to return
+func encodeCursor(row int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(row, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	row, err := strconv.ParseInt(string(raw), 10, 64)
+	if err != nil || row < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return row, nil
+}
+
+type csvPreviewReader struct {
+	r    *csv.Reader
+	cols []string
+}
+
+func newCSVPreviewReader(r io.Reader) (*csvPreviewReader, error) {
+	cr := csv.NewReader(r)
+	cols, err := cr.Read()
+	if err == io.EOF {
+		return &csvPreviewReader{r: cr}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &csvPreviewReader{r: cr, cols: cols}, nil
+}
+
+func (c *csvPreviewReader) Columns() []string { return c.cols }
+func (c *csvPreviewReader) Typed() bool       { return false }
+
+func (c *csvPreviewReader) Next() ([]any, error) {
+	rec, err := c.r.Read()
+	if err != nil {
+		return nil, err
+	}
+	row := make([]any, len(rec))
+	for i, v := range rec {
+		row[i] = v
+	}
+	return row, nil
+}
+
+// ndjsonPreviewReader reads flat JSON objects, one per line, keeping their key order; the first
+// line's keys are the columns
+type ndjsonPreviewReader struct {
+	s     *bufio.Scanner
+	cols  []string
+	first []any
+	err   error
+}
+
+func newNDJSONPreviewReader(r io.Reader) *ndjsonPreviewReader {
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 64<<10), 16<<20)
+	nr := &ndjsonPreviewReader{s: s}
+	nr.cols, nr.first, nr.err = nr.line()
+	return nr
+}
+
+func (n *ndjsonPreviewReader) Columns() []string { return n.cols }
+func (n *ndjsonPreviewReader) Typed() bool       { return true }
+
+func (n *ndjsonPreviewReader) Next() ([]any, error) {
+	if n.err != nil {
+		return nil, n.err
+	}
+	if n.first != nil {
+		row := n.first
+		n.first = nil
+		return row, nil
+	}
+	keys, row, err := n.line()
+	if err != nil {
+		return nil, err
+	}
+	if len(keys) != len(n.cols) {
+		return nil, fmt.Errorf("result row has %d fields, want %d", len(keys), len(n.cols))
+	}
+	for i, k := range keys {
+		if k != n.cols[i] {
+			return nil, fmt.Errorf("result row has field %q where %q was expected", k, n.cols[i])
+		}
+	}
+	return row, nil
+}
+
+// line decodes the next non-empty line's keys and scalar values in order
+func (n *ndjsonPreviewReader) line() ([]string, []any, error) {
+	for n.s.Scan() {
+		b := bytes.TrimSpace(n.s.Bytes())
+		if len(b) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+			return nil, nil, errors.New("result line is not a JSON object")
+		}
+		var keys []string
+		var row []any
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return nil, nil, err
+			}
+			key, _ := t.(string)
+			if t, err = dec.Token(); err != nil {
+				return nil, nil, err
+			}
+			if _, ok := t.(json.Delim); ok {
+				return nil, nil, fmt.Errorf("result field %q is not a scalar", key)
+			}
+			keys = append(keys, key)
+			row = append(row, t)
+		}
+		return keys, row, nil
+	}
+	if err := n.s.Err(); err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, io.EOF
+}
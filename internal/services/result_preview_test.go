@@ -0,0 +1,140 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+
+	"be/internal/results"
+)
+
+// previewFile writes rows in format like a settlement job and returns a preview reader over it
+func previewFile(t *testing.T, format string, rows []results.Row) previewReader {
+	t.Helper()
+	f, _ := results.Get(format)
+	var buf bytes.Buffer
+	w, err := f.NewWriter(&buf, settlementColumns)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range rows {
+		if err := w.WriteRow(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if format == "ndjson" {
+		return newNDJSONPreviewReader(&buf)
+	}
+	pr, err := newCSVPreviewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pr
+}
+
+// countingReader counts the rows read from a preview reader
+type countingReader struct {
+	previewReader
+	rows int
+}
+
+func (c *countingReader) Next() ([]any, error) {
+	c.rows++
+	return c.previewReader.Next()
+}
+
+// writtenIndex builds the preview index of rows like resultFiles does while writing them
+func writtenIndex(rows []results.Row) *previewIndex {
+	names, ints := make([]string, len(settlementColumns)), make([]bool, len(settlementColumns))
+	for i, c := range settlementColumns {
+		names[i], ints[i] = c.Name, c.Int
+	}
+	x := newPreviewIndex(names, ints)
+	for _, r := range rows {
+		x.add(r)
+	}
+	return x
+}
+
+func TestPreviewPage(t *testing.T) {
+	row := func(merchant, date string, net int64) results.Row {
+		return results.Row{merchant, date, net + 30, int64(30), net, int64(2), int64(0), int64(0), int64(0)}
+	}
+	rows := []results.Row{
+		row("m-001", "2025-01-01", 970), row("m-002", "2025-01-01", 470),
+		row("m-001", "2025-01-02", 100), row("m-001", "2025-01-03", 200),
+	}
+	for _, format := range []string{"csv", "ndjson"} {
+		t.Run(format, func(t *testing.T) {
+			idx, err := scanPreviewIndex(previewFile(t, format, rows))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written := writtenIndex(rows); fmt.Sprint(written.Merchants) != fmt.Sprint(idx.Merchants) ||
+				fmt.Sprint(written.Summary) != fmt.Sprint(idx.Summary) {
+				t.Fatalf("written index %+v, scanned %+v", written, idx)
+			}
+			p, err := previewPage(previewFile(t, format, rows), idx, "m-001", 0, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(p.Columns) != len(settlementColumns) || len(p.Rows) != 2 || p.NextCursor == "" {
+				t.Fatalf("page = %+v", p)
+			}
+			if p.Rows[0]["merchant_id"] != "m-001" || p.Rows[0]["date"] != "2025-01-01" || p.Rows[0]["net"] != int64(970) ||
+				p.Rows[1]["date"] != "2025-01-02" {
+				t.Fatalf("rows = %v", p.Rows)
+			}
+			want := map[string]int64{"gross": 1360, "fee": 90, "net": 1270, "txn_count": 6}
+			if p.Summary.Rows != 3 || len(p.Summary.Totals) != len(want) {
+				t.Fatalf("summary = %+v", p.Summary)
+			}
+			for k, v := range want {
+				if p.Summary.Totals[k] != v {
+					t.Fatalf("summary = %+v", p.Summary)
+				}
+			}
+
+			start, err := decodeCursor(p.NextCursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			next, err := previewPage(previewFile(t, format, rows), idx, "m-001", start, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(next.Rows) != 1 || next.Rows[0]["date"] != "2025-01-03" || next.NextCursor != "" || next.Summary.Rows != 3 {
+				t.Fatalf("next page = %+v", next)
+			}
+
+			// the only m-002 row is the second, so the rest of the file is not read
+			cr := &countingReader{previewReader: previewFile(t, format, rows)}
+			only, err := previewPage(cr, writtenIndex(rows), "m-002", 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(only.Rows) != 1 || only.Summary.Rows != 1 || only.Summary.Totals["net"] != 470 || cr.rows != 2 {
+				t.Fatalf("m-002 page = %+v after %d rows", only, cr.rows)
+			}
+			none, err := previewPage(cr, idx, "m-404", 0, 10)
+			if err != nil || len(none.Rows) != 0 || none.Summary.Rows != 0 || cr.rows != 2 {
+				t.Fatalf("m-404 page = %+v, %v after %d rows", none, err, cr.rows)
+			}
+		})
+	}
+}
+
+func TestDecodeCursor(t *testing.T) {
+	for _, bad := range []string{"!", "eA", "LTE"} {
+		if _, err := decodeCursor(bad); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("decodeCursor(%q) = %v, want ErrInvalidCursor", bad, err)
+		}
+	}
+	if n, err := decodeCursor(encodeCursor(42)); err != nil || n != 42 {
+		t.Fatalf("round trip = %d, %v", n, err)
+	}
+}
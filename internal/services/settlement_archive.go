@@ -0,0 +1,311 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"be/internal/repositories"
+	"be/internal/results"
+	"be/internal/storage"
+)
+
+// Archive formats of a per-merchant split
+const (
+	ArchiveZip   = "zip"
+	ArchiveTarGz = "tar.gz"
+)
+
+var (
+	ErrInvalidArchive   = errors.New("INVALID_ARCHIVE")
+	ErrArtifactNotFound = errors.New("ARTIFACT_NOT_FOUND")
+	ErrUnsafeMerchantID = errors.New("UNSAFE_MERCHANT_ID")
+)
+
+// archiveIndexName is the manifest inside a per-merchant archive
+const archiveIndexName = "index.json"
+
+// archiveEpoch is the modification time of every archive entry, so equal results give equal archives
+var archiveEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// ArchiveEntry describes one merchant's file in the archive index
+type ArchiveEntry struct {
+	MerchantID string `json:"merchant_id"`
+	File       string `json:"file"`
+	Rows       int    `json:"rows"`
+	Bytes      int    `json:"bytes"`
+	SHA256     string `json:"sha256"`
+	NetCents   int64  `json:"net_cents"`
+}
+
+// ArchiveIndex is the manifest written as index.json, after the merchant files
+type ArchiveIndex struct {
+	JobID     string         `json:"job_id"`
+	Format    string         `json:"format"`
+	Merchants []ArchiveEntry `json:"merchants"`
+}
+
+// Artifact is one file extracted from a job's result
+type Artifact struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+// ArchiveKey returns the per-merchant archive of a job
+func ArchiveKey(jobID, archive string) string {
+	return jobID + "_merchants." + archive
+}
+
+// merchantFileName names a merchant's file in an archive. Merchant IDs that could leave the archive's
+// root when extracted, or clash with the index, are rejected rather than rewritten, so a name always
+// maps back to one merchant.
+func merchantFileName(merchantID string, format results.Format) (string, error) {
+	name := merchantID + format.Extension()
+	if merchantID == "" || merchantID == "." || strings.Contains(merchantID, "..") ||
+		strings.ContainsAny(merchantID, "/\\:") || name == archiveIndexName ||
+		strings.IndexFunc(merchantID, func(r rune) bool { return r < 0x20 || r == 0x7f }) >= 0 {
+		return "", fmt.Errorf("%w: %q", ErrUnsafeMerchantID, merchantID)
+	}
+	return name, nil
+}
+
+// archiveWriter adds whole files to a zip or tar.gz stream
+type archiveWriter interface {
+	add(name string, data []byte) error
+	Close() error
+}
+
+type zipArchive struct{ zw *zip.Writer }
+
+func (a zipArchive) add(name string, data []byte) error {
+	w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: archiveEpoch})
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+func (a zipArchive) Close() error { return a.zw.Close() }
+
+type tarGzArchive struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (a tarGzArchive) add(name string, data []byte) error {
+	if err := a.tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: archiveEpoch, Typeflag: tar.TypeReg}); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+func (a tarGzArchive) Close() error { return errors.Join(a.tw.Close(), a.gz.Close()) }
+
+func newArchiveWriter(w io.Writer, archive string) (archiveWriter, error) {
+	switch archive {
+	case ArchiveZip:
+		return zipArchive{zw: zip.NewWriter(w)}, nil
+	case ArchiveTarGz:
+		gz := gzip.NewWriter(w)
+		gz.ModTime = archiveEpoch
+		return tarGzArchive{gz: gz, tw: tar.NewWriter(gz)}, nil
+	}
+	return nil, ErrInvalidArchive
+}
+
+// merchantArchive writes one file per merchant, in a result format, and then the index into an archive.
+// Rows come in merchant order, so only the current merchant's file is held until it is complete.
+type merchantArchive struct {
+	aw     archiveWriter
+	format results.Format
+	index  ArchiveIndex
+	entry  *ArchiveEntry
+	buf    bytes.Buffer
+	w      results.ResultWriter
+}
+
+func newMerchantArchive(f io.Writer, jobID, archive, format string) (*merchantArchive, error) {
+	rf, ok := results.Get(format)
+	if !ok {
+		return nil, ErrInvalidFormat
+	}
+	aw, err := newArchiveWriter(f, archive)
+	if err != nil {
+		return nil, err
+	}
+	return &merchantArchive{aw: aw, format: rf, index: ArchiveIndex{JobID: jobID, Format: format, Merchants: []ArchiveEntry{}}}, nil
+}
+
+// WriteRow adds a settlement row to its merchant's file, completing the previous merchant's first
+func (a *merchantArchive) WriteRow(k settlementKey, v settlementTotals) error {
+	if a.entry == nil || a.entry.MerchantID != k.merchant {
+		name, err := merchantFileName(k.merchant, a.format)
+		if err != nil {
+			return err
+		}
+		if err := a.flush(); err != nil {
+			return err
+		}
+		w, err := a.format.NewWriter(&a.buf, settlementColumns)
+		if err != nil {
+			return err
+		}
+		a.w = w
+		a.entry = &ArchiveEntry{MerchantID: k.merchant, File: name}
+	}
+	if err := a.w.WriteRow(settlementRecord(k, v)); err != nil {
+		return err
+	}
+	a.entry.Rows++
+	a.entry.NetCents += v.net
+	return nil
+}
+
+// flush adds the current merchant's file to the archive
+func (a *merchantArchive) flush() error {
+	if a.entry == nil {
+		return nil
+	}
+	if err := a.w.Close(); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(a.buf.Bytes())
+	a.entry.Bytes, a.entry.SHA256 = a.buf.Len(), hex.EncodeToString(sum[:])
+	if err := a.aw.add(a.entry.File, a.buf.Bytes()); err != nil {
+		return err
+	}
+	a.index.Merchants = append(a.index.Merchants, *a.entry)
+	a.entry = nil
+	a.buf.Reset()
+	return nil
+}
+
+// Close adds the last merchant's file and the index, and completes the archive
+func (a *merchantArchive) Close() error {
+	if err := a.flush(); err != nil {
+		return err
+	}
+	raw, err := json.MarshalIndent(a.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := a.aw.add(archiveIndexName, append(raw, '\n')); err != nil {
+		return err
+	}
+	return a.aw.Close()
+}
+
+// readerAt gives random access to a stored artifact, which reading a zip needs: directly when the
+// store returns a file, otherwise through a temp copy
+func readerAt(rc io.ReadCloser) (io.ReaderAt, func(), error) {
+	if ra, ok := rc.(io.ReaderAt); ok {
+		return ra, func() { rc.Close() }, nil
+	}
+	defer rc.Close()
+	tmp, err := os.CreateTemp("", "artifact-*")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}
+	if _, err := io.Copy(tmp, rc); err != nil {
+		cleanup()
+		return nil, nil, err
+	}
+	return tmp, cleanup, nil
+}
+
+// readArchiveFile returns the named file of a stored archive
+func readArchiveFile(ctx context.Context, store storage.ArtifactStore, key, archive, name string) ([]byte, error) {
+	rc, info, err := store.Open(ctx, key)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrArtifactNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch archive {
+	case ArchiveZip:
+		ra, done, err := readerAt(rc)
+		if err != nil {
+			return nil, err
+		}
+		defer done()
+		zr, err := zip.NewReader(ra, info.Size)
+		if err != nil {
+			return nil, err
+		}
+		rc, err := zr.Open(name)
+		if err != nil {
+			return nil, ErrArtifactNotFound
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	case ArchiveTarGz:
+		defer rc.Close()
+		gz, err := gzip.NewReader(rc)
+		if err != nil {
+			return nil, err
+		}
+		tr := tar.NewReader(gz)
+		for {
+			h, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				return nil, ErrArtifactNotFound
+			}
+			if err != nil {
+				return nil, err
+			}
+			if h.Name == name {
+				return io.ReadAll(tr)
+			}
+		}
+	}
+	rc.Close()
+	return nil, ErrInvalidArchive
+}
+
+// MerchantArtifact returns one merchant's file from a completed settlement job split by merchant
+func (s *jobService) MerchantArtifact(ctx context.Context, jobID, merchantID string) (*Artifact, error) {
+	jr, err := s.jobs.Get(ctx, jobID)
+	if err != nil {
+		return nil, ErrArtifactNotFound
+	}
+	var params SettlementParams
+	if err := jr.DecodeParams(&params); err != nil {
+		return nil, err
+	}
+	if jr.Type != repositories.JobTypeSettlement || jr.Status != repositories.JobStatusCompleted || params.Archive == "" ||
+		len(params.Formats) == 0 {
+		return nil, ErrArtifactNotFound
+	}
+	format, ok := results.Get(params.Formats[0])
+	if !ok {
+		return nil, ErrArtifactNotFound
+	}
+	name, err := merchantFileName(merchantID, format)
+	if err != nil {
+		return nil, ErrArtifactNotFound
+	}
+	data, err := readArchiveFile(ctx, s.store, ArchiveKey(jobID, params.Archive), params.Archive, name)
+	if err != nil {
+		return nil, err
+	}
+	return &Artifact{Name: name, ContentType: format.ContentType(), Data: data}, nil
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"be/internal/storage"
+)
+
+// TestMerchantArchiveRoundTrip checks that both archive kinds hold one file per merchant and an
+// index that matches them, and that writing the same result twice gives the same bytes.
+func TestMerchantArchiveRoundTrip(t *testing.T) {
+	rows := []struct {
+		k settlementKey
+		v settlementTotals
+	}{
+		{settlementKey{"m-001", "2025-01-01"}, settlementTotals{gross: 1000, fee: 30, net: 970, count: 1}},
+		{settlementKey{"m-001", "2025-01-02"}, settlementTotals{gross: 500, fee: 15, net: 485, count: 1}},
+		{settlementKey{"m-002", "2025-01-01"}, settlementTotals{gross: 200, fee: 30, net: 170, count: 1}},
+	}
+	for _, archive := range []string{ArchiveZip, ArchiveTarGz} {
+		t.Run(archive, func(t *testing.T) {
+			ctx := context.Background()
+			store, err := storage.NewLocalStore(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			write := func(key string) []byte {
+				var buf bytes.Buffer
+				err := storage.Put(ctx, store, key, func(w io.Writer) error {
+					a, err := newMerchantArchive(io.MultiWriter(w, &buf), "job_1", archive, "csv")
+					if err != nil {
+						return err
+					}
+					for _, r := range rows {
+						if err := a.WriteRow(r.k, r.v); err != nil {
+							return err
+						}
+					}
+					return a.Close()
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+				return buf.Bytes()
+			}
+			key := ArchiveKey("job_1", archive)
+			first := write(key)
+			raw, err := readArchiveFile(ctx, store, key, archive, archiveIndexName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var index ArchiveIndex
+			if err := json.Unmarshal(raw, &index); err != nil {
+				t.Fatal(err)
+			}
+			if len(index.Merchants) != 2 || index.Merchants[0].Rows != 2 || index.Merchants[0].NetCents != 1455 || index.Merchants[1].File != "m-002.csv" {
+				t.Fatalf("index = %+v", index)
+			}
+			for _, e := range index.Merchants {
+				data, err := readArchiveFile(ctx, store, key, archive, e.File)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sum := sha256.Sum256(data)
+				if len(data) != e.Bytes || hex.EncodeToString(sum[:]) != e.SHA256 {
+					t.Errorf("%s does not match its index entry", e.File)
+				}
+			}
+			if _, err := readArchiveFile(ctx, store, key, archive, "m-404.csv"); !errors.Is(err, ErrArtifactNotFound) {
+				t.Errorf("missing merchant err = %v", err)
+			}
+
+			if !bytes.Equal(first, write("again")) {
+				t.Error("archive is not deterministic")
+			}
+		})
+	}
+}
+
+// TestMerchantArchiveRejectsUnsafeIDs checks that a merchant ID cannot name a file outside the archive root
+func TestMerchantArchiveRejectsUnsafeIDs(t *testing.T) {
+	for _, id := range []string{"", ".", "..", "../../etc/cron.d/x", "/abs", `a\b`, "a/../b", "c:evil", "a\x00b"} {
+		a, err := newMerchantArchive(io.Discard, "job_1", ArchiveZip, "csv")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := a.WriteRow(settlementKey{id, "2025-01-01"}, settlementTotals{}); !errors.Is(err, ErrUnsafeMerchantID) {
+			t.Errorf("WriteRow(%q) = %v, want ErrUnsafeMerchantID", id, err)
+		}
+	}
+}
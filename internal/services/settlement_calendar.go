@@ -0,0 +1,55 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrInvalidCutoff = errors.New("INVALID_CUTOFF")
+
+// settlementDay returns the settlement day paidAt belongs to: the merchant-local
+// calendar day, or the following day when paid at or after the cut-off
+func settlementDay(paidAt time.Time, loc *time.Location, cutoff time.Duration) string {
+	local := paidAt.In(loc)
+	y, m, d := local.Date()
+	if cutoff > 0 {
+		sinceMidnight := time.Duration(local.Hour())*time.Hour +
+			time.Duration(local.Minute())*time.Minute +
+			time.Duration(local.Second())*time.Second +
+			time.Duration(local.Nanosecond())
+		if sinceMidnight >= cutoff {
+			d++
+		}
+	}
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC).Format("2006-01-02")
+}
+
+// ParseCutoff parses a local cut-off time such as "17:00" or "17:00:00"; "" means midnight
+func ParseCutoff(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.Sub(time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)), nil
+		}
+	}
+	return 0, ErrInvalidCutoff
+}
+
+// locationCache resolves IANA timezone names once; it is not safe for concurrent use
+type locationCache map[string]*time.Location
+
+func (c locationCache) get(name string) *time.Location {
+	if loc, ok := c[name]; ok {
+		return loc
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("Unknown timezone %q, falling back to UTC: %v", name, err)
+		loc = time.UTC
+	}
+	c[name] = loc
+	return loc
+}
@@ -0,0 +1,109 @@
+package services
+
+import (
+	"testing"
+	"time"
+)
+
+func mustLoad(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("skipping: tzdata for %s unavailable: %v", name, err)
+	}
+	return loc
+}
+
+func TestSettlementDay(t *testing.T) {
+	cases := []struct {
+		name   string
+		tz     string
+		paidAt string
+		want   string
+	}{
+		{"utc end of day", "UTC", "2025-01-01T23:59:59Z", "2025-01-01"},
+		{"jakarta after local midnight", "Asia/Jakarta", "2025-01-01T17:00:00Z", "2025-01-02"},
+		{"jakarta before local midnight", "Asia/Jakarta", "2025-01-01T16:59:59Z", "2025-01-01"},
+		{"singapore after local midnight", "Asia/Singapore", "2025-01-01T16:00:00Z", "2025-01-02"},
+		// New York springs forward on 2025-03-09; local midnight is still EST (UTC-5)
+		{"new york before spring forward midnight", "America/New_York", "2025-03-10T03:59:59Z", "2025-03-09"},
+		{"new york after spring forward midnight", "America/New_York", "2025-03-10T04:00:00Z", "2025-03-10"},
+		// New York falls back on 2025-11-02; the following midnight is EST (UTC-5)
+		{"new york fall back day late", "America/New_York", "2025-11-03T04:59:59Z", "2025-11-02"},
+		{"new york after fall back midnight", "America/New_York", "2025-11-03T05:00:00Z", "2025-11-03"},
+		// Berlin springs forward on 2025-03-30 at 02:00 local; the day is 23 hours long
+		{"berlin during skipped hour", "Europe/Berlin", "2025-03-30T01:30:00Z", "2025-03-30"},
+		{"berlin end of short day", "Europe/Berlin", "2025-03-30T21:59:59Z", "2025-03-30"},
+		{"berlin next day", "Europe/Berlin", "2025-03-30T22:00:00Z", "2025-03-31"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			paidAt, err := time.Parse(time.RFC3339, tc.paidAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := settlementDay(paidAt, mustLoad(t, tc.tz), 0); got != tc.want {
+				t.Fatalf("settlementDay(%s, %s) = %s, want %s", tc.paidAt, tc.tz, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSettlementDayCutoff(t *testing.T) {
+	cases := []struct {
+		name   string
+		tz     string
+		cutoff time.Duration
+		paidAt string
+		want   string
+	}{
+		{"utc before cutoff", "UTC", 17 * time.Hour, "2025-01-01T16:59:59Z", "2025-01-01"},
+		{"utc at cutoff", "UTC", 17 * time.Hour, "2025-01-01T17:00:00Z", "2025-01-02"},
+		{"utc after local midnight", "UTC", 17 * time.Hour, "2025-01-02T00:30:00Z", "2025-01-02"},
+		{"jakarta before cutoff", "Asia/Jakarta", 17 * time.Hour, "2025-01-01T09:59:59Z", "2025-01-01"},
+		{"jakarta at cutoff", "Asia/Jakarta", 17 * time.Hour, "2025-01-01T10:00:00Z", "2025-01-02"},
+		{"month rollover", "UTC", 17 * time.Hour, "2025-01-31T18:00:00Z", "2025-02-01"},
+		// On 2025-03-09 New York is EDT (UTC-4) by 17:00 local
+		{"new york spring forward at cutoff", "America/New_York", 17 * time.Hour, "2025-03-09T21:00:00Z", "2025-03-10"},
+		{"new york spring forward before cutoff", "America/New_York", 17 * time.Hour, "2025-03-09T20:59:59Z", "2025-03-09"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			paidAt, err := time.Parse(time.RFC3339, tc.paidAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := settlementDay(paidAt, mustLoad(t, tc.tz), tc.cutoff); got != tc.want {
+				t.Fatalf("settlementDay(%s, %s, %v) = %s, want %s", tc.paidAt, tc.tz, tc.cutoff, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestParseCutoff(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":         0,
+		"00:00":    0,
+		"17:00":    17 * time.Hour,
+		"09:30:15": 9*time.Hour + 30*time.Minute + 15*time.Second,
+	}
+	for in, want := range cases {
+		got, err := ParseCutoff(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseCutoff(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseCutoff("25:00"); err != ErrInvalidCutoff {
+		t.Fatalf("expected ErrInvalidCutoff, got %v", err)
+	}
+}
+
+func TestLocationCacheFallsBackToUTC(t *testing.T) {
+	c := locationCache{}
+	if loc := c.get("Not/AZone"); loc != time.UTC {
+		t.Fatalf("expected UTC fallback, got %v", loc)
+	}
+	if loc := c.get("Asia/Jakarta"); loc.String() != "Asia/Jakarta" {
+		t.Fatalf("expected Asia/Jakarta, got %v", loc)
+	}
+}
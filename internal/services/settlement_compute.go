@@ -0,0 +1,515 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"be/internal/repositories"
+	"be/internal/results"
+)
+
+// settlementKey identifies one settlement row
+type settlementKey struct{ merchant, day string }
+
+// settlementTotals is the computed content of one settlement row
+type settlementTotals struct {
+	gross, fee, net int64
+	adj             int64
+	reserved        int64
+	released        int64
+	count           int64
+	cutoff          int64 // seconds after local midnight
+}
+
+func (v settlementTotals) payable() int64 { return v.net - v.reserved + v.released }
+
+// computedSettlement is the outcome of aggregating a date range, before anything is written. each
+// streams its rows in merchant/date order out of the aggregation's sorted runs and applies adjustments
+// and reserves on the way, so only the few merchant/days those touch are held in memory.
+type computedSettlement struct {
+	agg            *spillAggregator                   // transaction totals per merchant/day
+	extra          map[settlementKey]settlementTotals // adjustments, released stored reserves and stored days to keep
+	adjustmentIDs  []int64
+	reserveConfigs map[string]repositories.ReserveConfig
+	kept           map[settlementKey]bool // settlement days whose reserve release comes from a stored reserve
+	inScope        func(merchant, day string) bool
+	globalCutoff   int64
+	scope          []repositories.SettlementKey // merchant/days recomputed; nil for the whole range
+}
+
+// pendingRelease is a reserve held by the run that is released into a later day of the same merchant
+type pendingRelease struct {
+	key    settlementKey
+	amount int64
+}
+
+// each calls fn for every settlement row in merchant/date order. A day's reserve is released into a later
+// day of the same merchant, so only the current merchant's pending releases are kept until they are due.
+func (c *computedSettlement) each(fn func(settlementKey, settlementTotals) error) error {
+	extraKeys := sortedKeys(c.extra)
+	var pending []pendingRelease // in day order, as a merchant's days and so their release days come in order
+
+	// emit completes a row: the transaction totals when it has any, plus what else falls on it
+	emit := func(k settlementKey, v settlementTotals, aggregated bool) error {
+		if len(extraKeys) > 0 && extraKeys[0] == k {
+			extraKeys = extraKeys[1:]
+		}
+		if x, ok := c.extra[k]; ok {
+			v.adj += x.adj
+			v.net += x.net
+			v.released += x.released
+			if !aggregated {
+				v.cutoff = x.cutoff
+			}
+		} else if !aggregated {
+			v.cutoff = c.globalCutoff
+		}
+		if len(pending) > 0 && pending[0].key == k {
+			v.released += pending[0].amount
+			pending = pending[1:]
+		}
+		// Rolling reserves hold back part of each day's positive net until its release date
+		if rc, ok := c.reserveConfigs[k.merchant]; ok && v.net > 0 {
+			v.reserved = v.net * rc.Bps / 10000
+			if release := c.releaseDay(k, v); release != "" && c.inScope(k.merchant, release) && !c.kept[k] {
+				pending = append(pending, pendingRelease{key: settlementKey{merchant: k.merchant, day: release}, amount: v.reserved})
+			}
+		}
+		return fn(k, v)
+	}
+	// flush emits the rows without transactions that come before until, or all of them when it is nil
+	flush := func(until *settlementKey) error {
+		for {
+			var k settlementKey
+			ok := false
+			if len(extraKeys) > 0 {
+				k, ok = extraKeys[0], true
+			}
+			if len(pending) > 0 && (!ok || keyLess(pending[0].key, k)) {
+				k, ok = pending[0].key, true
+			}
+			if !ok || until != nil && !keyLess(k, *until) {
+				return nil
+			}
+			if err := emit(k, settlementTotals{}, false); err != nil {
+				return err
+			}
+		}
+	}
+	err := c.agg.each(func(k settlementKey, v settlementTotals) error {
+		if err := flush(&k); err != nil {
+			return err
+		}
+		return emit(k, v, true)
+	})
+	if err != nil {
+		return err
+	}
+	return flush(nil)
+}
+
+// collect returns every settlement row by key, for callers that compare the whole range at once
+func (c *computedSettlement) collect() (map[settlementKey]settlementTotals, error) {
+	out := map[settlementKey]settlementTotals{}
+	err := c.each(func(k settlementKey, v settlementTotals) error {
+		out[k] = v
+		return nil
+	})
+	return out, err
+}
+
+// close removes the aggregation's spill files
+func (c *computedSettlement) close() error { return c.agg.close() }
+
+// releaseDay returns the day the reserve a row holds is released on, or "" when it holds none
+func (c *computedSettlement) releaseDay(k settlementKey, v settlementTotals) string {
+	rc, ok := c.reserveConfigs[k.merchant]
+	if !ok || v.reserved == 0 {
+		return ""
+	}
+	day, err := time.Parse("2006-01-02", k.day)
+	if err != nil {
+		return ""
+	}
+	return day.AddDate(0, 0, rc.Days).Format("2006-01-02")
+}
+
+// settlementColumns are the columns of the settlement job's result; record returns its rows
+var settlementColumns = []results.Column{
+	{Name: "merchant_id"}, {Name: "date"},
+	{Name: "gross", Int: true}, {Name: "fee", Int: true}, {Name: "net", Int: true}, {Name: "txn_count", Int: true},
+	{Name: "adjustments", Int: true}, {Name: "reserved", Int: true}, {Name: "released", Int: true},
+}
+
+// settlementRecord returns the result row of a merchant/day
+func settlementRecord(k settlementKey, v settlementTotals) results.Row {
+	return results.Row{k.merchant, k.day, v.gross, v.fee, v.net, v.count, v.adj, v.reserved, v.released}
+}
+
+// runRow returns the settlements row of a merchant/day as written by run runID, with the reserve it holds
+func (c *computedSettlement) runRow(k settlementKey, v settlementTotals, runID string) repositories.SettlementRunRow {
+	return repositories.SettlementRunRow{SettlementRow: settlementRow(k, v, runID), ReleaseDate: c.releaseDay(k, v)}
+}
+
+// settlementRow returns the settlements row of a merchant/day as written by run runID
+func settlementRow(k settlementKey, v settlementTotals, runID string) repositories.SettlementRow {
+	return repositories.SettlementRow{
+		MerchantID:      k.merchant,
+		Date:            k.day,
+		GrossCents:      v.gross,
+		FeeCents:        v.fee,
+		AdjustmentCents: v.adj,
+		NetCents:        v.net,
+		ReservedCents:   v.reserved,
+		ReleasedCents:   v.released,
+		PayableCents:    v.payable(),
+		TxnCount:        v.count,
+		Cutoff:          repositories.FormatCutoff(time.Duration(v.cutoff) * time.Second),
+		RunID:           runID,
+	}
+}
+
+// Aggregation strategies
+const (
+	AggregationStream = "stream" // fetch transaction rows and sum them in Go workers
+	AggregationSQL    = "sql"    // sum per merchant/day with GROUP BY in Postgres
+)
+
+// settlementCalculator aggregates settlement rows; it is shared by the settlement and reconciliation jobs
+// so both apply the same rules
+type settlementCalculator struct {
+	txRepo   repositories.TransactionRepository
+	adjRepo  repositories.AdjustmentRepository
+	rsvRepo  repositories.ReserveRepository
+	workers  int
+	cutoff   time.Duration // global cut-off for merchants without their own
+	strategy string        // AggregationStream when empty
+
+	partitions int   // concurrent transaction scans of the stream strategy; 1 when unset
+	memBudget  int64 // bytes the stream strategy aggregates in memory before spilling to disk; 0 is unbounded
+}
+
+func (c *settlementCalculator) partitionCount() int { return max(c.partitions, 1) }
+
+// withStrategy returns a copy of the calculator using the aggregation strategy, or c itself when empty
+func (c *settlementCalculator) withStrategy(strategy string) *settlementCalculator {
+	if strategy == "" {
+		return c
+	}
+	cc := *c
+	cc.strategy = strategy
+	return &cc
+}
+
+// compute sums the transactions of settlement dates in range (inclusive) with the calculator's strategy,
+// and gathers the approved adjustments and stored reserves that each adds to the rows with rolling
+// reserves. Non-nil keys limit it to those merchant/days (and the days their reserves release on). It
+// only reads; onBatch is called with the number of transactions aggregated so far and stops the
+// aggregation when it returns an error. The caller closes the result.
+func (c *settlementCalculator) compute(ctx context.Context, from, to time.Time, keys []repositories.SettlementKey, onBatch func(processed int64) error) (*computedSettlement, error) {
+	fromDay, toDay := from.Format("2006-01-02"), to.Format("2006-01-02")
+	reserveConfigs, err := c.rsvRepo.Configs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inScope := func(merchant, day string) bool { return day >= fromDay && day <= toDay }
+	if keys != nil {
+		keys = expandReleaseDays(keys, reserveConfigs, toDay)
+		set := make(map[settlementKey]bool, len(keys))
+		for _, k := range keys {
+			set[settlementKey{merchant: k.MerchantID, day: k.Date}] = true
+		}
+		inScope = func(merchant, day string) bool { return set[settlementKey{merchant: merchant, day: day}] }
+	}
+
+	agg := newSpillAggregator(c.memBudget)
+	if c.strategy == AggregationSQL {
+		err = c.sumInSQL(ctx, from, to, keys, agg, onBatch)
+	} else {
+		err = c.sumStreamed(ctx, from, to, keys, agg, onBatch)
+	}
+	if err != nil {
+		_ = agg.close()
+		return nil, err
+	}
+	if n := agg.spills(); n > 0 {
+		log.Printf("Aggregation spilled %d runs to disk", n)
+	}
+
+	out := &computedSettlement{
+		agg:            agg,
+		extra:          map[settlementKey]settlementTotals{},
+		reserveConfigs: reserveConfigs,
+		kept:           map[settlementKey]bool{},
+		inScope:        inScope,
+		globalCutoff:   int64(c.cutoff / time.Second),
+		scope:          keys,
+	}
+	// extra returns what else falls on a merchant/day; a day without transactions has no cut-off
+	// to take, so it records the global one
+	extra := func(k settlementKey) settlementTotals {
+		v, ok := out.extra[k]
+		if !ok {
+			v.cutoff = out.globalCutoff
+		}
+		return v
+	}
+
+	// Approved adjustments count towards their effective date
+	adjustments, err := c.adjRepo.ApprovedInRange(ctx, from, to)
+	if err != nil {
+		_ = agg.close()
+		return nil, err
+	}
+	for _, a := range adjustments {
+		if !inScope(a.MerchantID, a.EffectiveDate.Format("2006-01-02")) {
+			continue
+		}
+		k := settlementKey{merchant: a.MerchantID, day: a.EffectiveDate.Format("2006-01-02")}
+		v := extra(k)
+		v.adj += a.AmountCents
+		v.net += a.AmountCents
+		out.extra[k] = v
+		out.adjustmentIDs = append(out.adjustmentIDs, a.ID)
+	}
+
+	// Reserves due in scope are released into the settlement of their release date. Held reserves
+	// of settlement dates in scope are superseded by the ones each computes; released ones stay.
+	due, err := c.rsvRepo.DueInRange(ctx, from, to)
+	if err != nil {
+		_ = agg.close()
+		return nil, err
+	}
+	for _, rs := range due {
+		settled, released := rs.SettlementDate.Format("2006-01-02"), rs.ReleaseDate.Format("2006-01-02")
+		if !inScope(rs.MerchantID, released) ||
+			rs.Status == repositories.ReserveStatusHeld && inScope(rs.MerchantID, settled) {
+			continue
+		}
+		out.kept[settlementKey{merchant: rs.MerchantID, day: settled}] = true
+		k := settlementKey{merchant: rs.MerchantID, day: released}
+		v := extra(k)
+		v.released += rs.AmountCents
+		out.extra[k] = v
+	}
+	return out, nil
+}
+
+// sumStreamed streams the transactions in batches and fans them out to workers to sum into agg
+func (c *settlementCalculator) sumStreamed(ctx context.Context, from, to time.Time, keys []repositories.SettlementKey, agg *spillAggregator, onBatch func(processed int64) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var aggErr error
+	var aggErrOnce sync.Once
+
+	batches := make(chan []repositories.TransactionRow, c.workers)
+	var wg sync.WaitGroup
+	worker := func() {
+		defer wg.Done()
+		log.Println("Worker started")
+		locs := locationCache{}
+		for {
+			select {
+			case <-ctx.Done():
+				log.Println("Worker exiting due to cancel")
+				return
+			case batch, ok := <-batches:
+				if !ok {
+					return
+				}
+				local := make(map[settlementKey]settlementTotals)
+				for _, t := range batch {
+					cutoff := time.Duration(t.CutoffSeconds) * time.Second
+					day := settlementDay(t.PaidAt, locs.get(t.Timezone), cutoff)
+					k := settlementKey{merchant: t.MerchantID, day: day}
+					v := local[k]
+					v.gross += t.AmountCents
+					v.fee += t.FeeCents
+					v.net += t.AmountCents - t.FeeCents
+					v.count++
+					v.cutoff = t.CutoffSeconds
+					local[k] = v
+				}
+				if err := agg.add(local); err != nil {
+					aggErrOnce.Do(func() { aggErr = err })
+					cancel()
+					return
+				}
+			}
+		}
+	}
+
+	for i := 0; i < c.workers; i++ {
+		wg.Add(1)
+		go worker()
+	}
+
+	// Each partition streams on its own connection; progress is reported under a lock so
+	// onBatch sees one running total, and the first error cancels every partition
+	var (
+		progressMu sync.Mutex
+		processed  int64
+		streamErr  error
+		streams    sync.WaitGroup
+	)
+	fn := func(ts []repositories.TransactionRow) error {
+		progressMu.Lock()
+		processed += int64(len(ts))
+		err := onBatch(processed)
+		progressMu.Unlock()
+		if err != nil {
+			return err
+		}
+		select {
+		case batches <- ts:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	stream := func(scan func(ctx context.Context) error) {
+		defer streams.Done()
+		// partitions read concurrently, so each imports the job's snapshot on its own connection
+		pctx, done, err := repositories.ForkSnapshot(ctx)
+		if err == nil {
+			err = scan(pctx)
+			done()
+		}
+		if err != nil {
+			progressMu.Lock()
+			if streamErr == nil {
+				streamErr = err
+			}
+			progressMu.Unlock()
+			cancel()
+		}
+	}
+	if keys != nil {
+		for _, part := range partitionKeys(keys, c.partitionCount()) {
+			streams.Add(1)
+			go stream(func(ctx context.Context) error { return c.txRepo.StreamKeys(ctx, part, c.cutoff, 500, fn) })
+		}
+	} else {
+		for _, part := range partitionDays(from, to, c.partitionCount()) {
+			streams.Add(1)
+			go stream(func(ctx context.Context) error {
+				return c.txRepo.StreamBatches(ctx, part[0], part[1], c.cutoff, 10000, fn)
+			})
+		}
+	}
+	go func() {
+		streams.Wait()
+		close(batches)
+	}()
+	wg.Wait()
+	// workers also stop on cancel, so wait for the stream to let go of batches
+	for range batches {
+	}
+	if aggErr != nil {
+		return aggErr
+	}
+	if streamErr != nil {
+		return streamErr
+	}
+	return ctx.Err()
+}
+
+// sumInSQL has Postgres sum the transactions per merchant/day, one settlement date (or batch of keys)
+// per query so progress and cancellation are checked between chunks. The sums go into agg, which puts
+// the date-ordered chunks in merchant order within the memory budget.
+func (c *settlementCalculator) sumInSQL(ctx context.Context, from, to time.Time, keys []repositories.SettlementKey, agg *spillAggregator, onBatch func(processed int64) error) error {
+	processed := int64(0)
+	fn := func(rows []repositories.SettlementTotalRow) error {
+		local := make(map[settlementKey]settlementTotals, len(rows))
+		for _, r := range rows {
+			k := settlementKey{merchant: r.MerchantID, day: r.Date}
+			v := local[k]
+			v.gross += r.GrossCents
+			v.fee += r.FeeCents
+			v.net += r.GrossCents - r.FeeCents
+			v.count += r.TxnCount
+			v.cutoff = r.CutoffSeconds
+			local[k] = v
+			processed += r.TxnCount
+		}
+		if err := agg.add(local); err != nil {
+			return err
+		}
+		if err := onBatch(processed); err != nil {
+			return err
+		}
+		return ctx.Err()
+	}
+	if keys != nil {
+		return c.txRepo.AggregateKeys(ctx, keys, c.cutoff, 500, fn)
+	}
+	return c.txRepo.AggregateDays(ctx, from, to, c.cutoff, fn)
+}
+
+// partitionDays splits the settlement dates from..to (inclusive) into at most n contiguous ranges of
+// nearly equal length, in order
+func partitionDays(from, to time.Time, n int) [][2]time.Time {
+	days := int(to.Sub(from).Hours()/24) + 1
+	if days < 1 {
+		return [][2]time.Time{{from, to}}
+	}
+	n = min(n, days)
+	out := make([][2]time.Time, 0, n)
+	start := 0
+	for i := 0; i < n; i++ {
+		size := days / n
+		if i < days%n {
+			size++
+		}
+		out = append(out, [2]time.Time{from.AddDate(0, 0, start), from.AddDate(0, 0, start+size-1)})
+		start += size
+	}
+	return out
+}
+
+// partitionKeys splits keys into at most n slices of nearly equal length
+func partitionKeys(keys []repositories.SettlementKey, n int) [][]repositories.SettlementKey {
+	n = max(min(n, len(keys)), 1)
+	out := make([][]repositories.SettlementKey, 0, n)
+	start := 0
+	for i := 0; i < n; i++ {
+		size := len(keys) / n
+		if i < len(keys)%n {
+			size++
+		}
+		out = append(out, keys[start:start+size])
+		start += size
+	}
+	return out
+}
+
+// expandReleaseDays adds the day each key's reserve would be released on, when it falls in range,
+// since that day's payable depends on the key's reserve
+func expandReleaseDays(keys []repositories.SettlementKey, configs map[string]repositories.ReserveConfig, toDay string) []repositories.SettlementKey {
+	seen := make(map[repositories.SettlementKey]bool, len(keys))
+	out := make([]repositories.SettlementKey, 0, len(keys))
+	add := func(k repositories.SettlementKey) {
+		if !seen[k] {
+			seen[k] = true
+			out = append(out, k)
+		}
+	}
+	for _, k := range keys {
+		add(k)
+		rc, ok := configs[k.MerchantID]
+		if !ok {
+			continue
+		}
+		day, err := time.Parse("2006-01-02", k.Date)
+		if err != nil {
+			continue
+		}
+		if release := day.AddDate(0, 0, rc.Days).Format("2006-01-02"); release <= toDay {
+			add(repositories.SettlementKey{MerchantID: k.MerchantID, Date: release})
+		}
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"be/internal/repositories"
+	"be/internal/results"
+)
+
+func TestDirtyKeys(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	dirty := []repositories.DirtyDay{
+		{MerchantID: "m-001", Day: day("2024-12-31")}, // paid the day before the range, may settle on its first day
+		{MerchantID: "m-001", Day: day("2025-01-01")},
+		{MerchantID: "m-002", Day: day("2025-01-31")}, // may settle on the day after the range
+	}
+	got := dirtyKeys(dirty, day("2025-01-01"), day("2025-01-31"))
+	want := []repositories.SettlementKey{
+		{MerchantID: "m-001", Date: "2025-01-01"},
+		{MerchantID: "m-001", Date: "2025-01-02"},
+		{MerchantID: "m-002", Date: "2025-01-31"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("dirtyKeys = %v, want %v", got, want)
+	}
+	// a full run settles only days whose transactions cannot spill out of its range
+	if settled := settledDirtyDays(dirty, day("2025-01-01"), day("2025-01-31")); !reflect.DeepEqual(settled, dirty[1:2]) {
+		t.Fatalf("settledDirtyDays = %v, want %v", settled, dirty[1:2])
+	}
+}
+
+func TestExpandReleaseDays(t *testing.T) {
+	keys := []repositories.SettlementKey{
+		{MerchantID: "m-001", Date: "2025-01-02"},
+		{MerchantID: "m-001", Date: "2025-01-30"},
+		{MerchantID: "m-002", Date: "2025-01-02"},
+	}
+	configs := map[string]repositories.ReserveConfig{"m-001": {MerchantID: "m-001", Bps: 1000, Days: 7}}
+	got := expandReleaseDays(keys, configs, "2025-01-31")
+	want := []repositories.SettlementKey{
+		{MerchantID: "m-001", Date: "2025-01-02"},
+		{MerchantID: "m-001", Date: "2025-01-09"},
+		{MerchantID: "m-001", Date: "2025-01-30"},
+		{MerchantID: "m-002", Date: "2025-01-02"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("expandReleaseDays = %v, want %v", got, want)
+	}
+}
+
+func TestPartitionDays(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	got := partitionDays(day("2025-01-01"), day("2025-01-10"), 3)
+	want := [][2]string{{"2025-01-01", "2025-01-04"}, {"2025-01-05", "2025-01-07"}, {"2025-01-08", "2025-01-10"}}
+	if len(got) != len(want) {
+		t.Fatalf("partitions = %v, want %v", got, want)
+	}
+	for i, p := range got {
+		if p[0].Format("2006-01-02") != want[i][0] || p[1].Format("2006-01-02") != want[i][1] {
+			t.Errorf("partition %d = %s..%s, want %s..%s", i, p[0].Format("2006-01-02"), p[1].Format("2006-01-02"), want[i][0], want[i][1])
+		}
+	}
+	if got := partitionDays(day("2025-01-01"), day("2025-01-02"), 8); len(got) != 2 {
+		t.Errorf("more partitions than days: %v", got)
+	}
+
+	keys := make([]repositories.SettlementKey, 5)
+	sizes := []int{}
+	for _, part := range partitionKeys(keys, 2) {
+		sizes = append(sizes, len(part))
+	}
+	if !reflect.DeepEqual(sizes, []int{3, 2}) {
+		t.Errorf("key partition sizes = %v", sizes)
+	}
+}
+
+// TestSettlementCSVIsDeterministic adds the same totals in different orders, spilling after every add,
+// and checks that the k-way merge of the runs writes the same file in merchant/date order
+func TestSettlementCSVIsDeterministic(t *testing.T) {
+	entries := []struct {
+		k settlementKey
+		v settlementTotals
+	}{
+		{settlementKey{"m-010", "2025-01-01"}, settlementTotals{gross: 1000, fee: 30, net: 970, count: 1}},
+		{settlementKey{"m-002", "2025-01-02"}, settlementTotals{gross: 500, fee: 30, net: 470, count: 1}},
+		{settlementKey{"m-002", "2025-01-01"}, settlementTotals{gross: 200, fee: 30, net: 170, count: 1}},
+		{settlementKey{"m-002", "2025-01-02"}, settlementTotals{gross: 100, fee: 10, net: 90, count: 1}},
+	}
+	write := func(order []int) string {
+		c := &computedSettlement{agg: newSpillAggregator(aggEntryBytes)}
+		defer func() { _ = c.close() }()
+		for _, i := range order {
+			if err := c.agg.add(map[settlementKey]settlementTotals{entries[i].k: entries[i].v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var buf bytes.Buffer
+		format, _ := results.Get("csv")
+		w, err := format.NewWriter(&buf, settlementColumns)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = c.each(func(k settlementKey, v settlementTotals) error { return w.WriteRow(settlementRecord(k, v)) })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	first := write([]int{0, 1, 2, 3})
+	for _, order := range [][]int{{3, 2, 1, 0}, {1, 0, 3, 2}} {
+		if got := write(order); got != first {
+			t.Fatalf("output depends on insertion order:\n%s\nvs\n%s", got, first)
+		}
+	}
+	want := "merchant_id,date,gross,fee,net,txn_count,adjustments,reserved,released\n" +
+		"m-002,2025-01-01,200,30,170,1,0,0,0\n" +
+		"m-002,2025-01-02,600,40,560,2,0,0,0\n" +
+		"m-010,2025-01-01,1000,30,970,1,0,0,0\n"
+	if first != want {
+		t.Fatalf("csv =\n%s\nwant\n%s", first, want)
+	}
+}
+
+// TestComputedSettlementEach checks that adjustments, stored releases and the run's own reserves are
+// applied while streaming, including on days without transactions, and rows stay in key order
+func TestComputedSettlementEach(t *testing.T) {
+	c := &computedSettlement{
+		agg: newSpillAggregator(0),
+		extra: map[settlementKey]settlementTotals{
+			{"m-001", "2025-01-01"}: {adj: 100, net: 100, cutoff: 3600}, // adjustment on a day with transactions
+			{"m-001", "2025-01-02"}: {released: 7, cutoff: 3600},        // stored reserve released, no transactions
+			{"m-003", "2025-01-05"}: {adj: -50, net: -50, cutoff: 3600}, // adjustment only
+		},
+		reserveConfigs: map[string]repositories.ReserveConfig{"m-001": {MerchantID: "m-001", Bps: 1000, Days: 2}},
+		kept:           map[settlementKey]bool{},
+		inScope:        func(merchant, day string) bool { return day <= "2025-01-31" },
+		globalCutoff:   3600,
+	}
+	defer func() { _ = c.close() }()
+	if err := c.agg.add(map[settlementKey]settlementTotals{
+		{"m-001", "2025-01-01"}: {gross: 1000, fee: 100, net: 900, count: 1, cutoff: 60},
+		{"m-002", "2025-01-01"}: {gross: 10, net: 10, count: 1, cutoff: 60},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	err := c.each(func(k settlementKey, v settlementTotals) error {
+		got = append(got, fmt.Sprintf("%s %s net=%d adj=%d reserved=%d released=%d cutoff=%d release=%s",
+			k.merchant, k.day, v.net, v.adj, v.reserved, v.released, v.cutoff, c.releaseDay(k, v)))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"m-001 2025-01-01 net=1000 adj=100 reserved=100 released=0 cutoff=60 release=2025-01-03",
+		"m-001 2025-01-02 net=0 adj=0 reserved=0 released=7 cutoff=3600 release=",
+		"m-001 2025-01-03 net=0 adj=0 reserved=0 released=100 cutoff=3600 release=",
+		"m-002 2025-01-01 net=10 adj=0 reserved=0 released=0 cutoff=60 release=",
+		"m-003 2025-01-05 net=-50 adj=-50 reserved=0 released=0 cutoff=3600 release=",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("rows =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
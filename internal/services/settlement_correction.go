@@ -0,0 +1,135 @@
+package services
+
+import (
+	"context"
+	"log"
+	"sort"
+	"time"
+
+	"be/internal/models"
+	"be/internal/repositories"
+	"be/internal/results"
+)
+
+// lateArrivalBatch caps the merchant/days one correction job re-settles
+const lateArrivalBatch = 5000
+
+// CorrectionSummary is shown on the job status endpoint of a correction settlement job
+type CorrectionSummary struct {
+	Corrects          []string `json:"corrects"`
+	DaysChanged       int      `json:"days_changed"`
+	NetDeltaCents     int64    `json:"net_delta_cents"`
+	PayableDeltaCents int64    `json:"payable_delta_cents"`
+}
+
+// settlementDelta is one re-settled merchant/day whose amounts changed
+type settlementDelta struct {
+	key           settlementKey
+	originalRunID string
+	before, after settlementTotals
+}
+
+// WatchLateArrivals queues a correction settlement job whenever settled merchant/days got transactions
+// after they were settled, until ctx is done. Only one correction job is queued or running at a time.
+func (s *jobService) WatchLateArrivals(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		if id, err := s.queueCorrection(ctx); err != nil {
+			log.Printf("Late arrivals: %v", err)
+		} else if id != "" {
+			log.Printf("Late arrivals: queued correction job %s", id)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// queueCorrection creates and enqueues a correction job for the current late arrivals; it returns
+// an empty id when there is nothing to correct or a correction is already pending
+func (s *jobService) queueCorrection(ctx context.Context) (string, error) {
+	active, err := s.jobs.HasActive(ctx, repositories.JobTypeSettlement, SettlementModeCorrection)
+	if err != nil || active {
+		return "", err
+	}
+	late, err := s.stRepo.LateArrivals(ctx, lateArrivalBatch)
+	if err != nil || len(late) == 0 {
+		return "", err
+	}
+	params, from, to := correctionParams(late)
+	id := NewJobID() + "_correction"
+	if err := s.jobs.Create(ctx, id, repositories.JobTypeSettlement, 0, from, to, params, ""); err != nil {
+		return "", err
+	}
+	s.Enqueue(id)
+	return id, nil
+}
+
+// correctionParams builds the parameters and date range of a correction job for late arrivals
+func correctionParams(late []repositories.LateArrival) (SettlementParams, time.Time, time.Time) {
+	params := SettlementParams{Mode: SettlementModeCorrection, Keys: make([]repositories.SettlementKey, 0, len(late))}
+	from, to := late[0].Date, late[0].Date
+	runs := map[string]bool{}
+	for _, l := range late {
+		params.Keys = append(params.Keys, repositories.SettlementKey{MerchantID: l.MerchantID, Date: l.Date.Format("2006-01-02")})
+		if l.Date.Before(from) {
+			from = l.Date
+		}
+		if l.Date.After(to) {
+			to = l.Date
+		}
+		if !runs[l.RunID] {
+			runs[l.RunID] = true
+			params.Corrects = append(params.Corrects, l.RunID)
+		}
+	}
+	sort.Strings(params.Corrects)
+	return params, from, to
+}
+
+// diffSettlement compares a stored settlement with its recomputed totals
+func diffSettlement(k settlementKey, before models.Settlement, after settlementTotals) (settlementDelta, bool) {
+	d := settlementDelta{key: k, originalRunID: before.UniqueRunID, before: storedTotals(before), after: after}
+	for _, f := range reconFields {
+		if f.get(d.before) != f.get(d.after) {
+			return d, true
+		}
+	}
+	return d, false
+}
+
+// correctionColumns are the columns of a correction job's delta report
+func correctionColumns() []results.Column {
+	cols := []results.Column{{Name: "merchant_id"}, {Name: "date"}, {Name: "original_run_id"}}
+	for _, f := range reconFields {
+		cols = append(cols, results.Column{Name: "before_" + f.name, Int: true}, results.Column{Name: "after_" + f.name, Int: true}, results.Column{Name: "delta_" + f.name, Int: true})
+	}
+	return cols
+}
+
+// writeCorrectionReport writes one row per changed merchant/day with its amounts before and after
+func writeCorrectionReport(w results.ResultWriter, deltas []settlementDelta) error {
+	for _, d := range deltas {
+		row := results.Row{d.key.merchant, d.key.day, d.originalRunID}
+		for _, f := range reconFields {
+			b, a := f.get(d.before), f.get(d.after)
+			row = append(row, b, a, a-b)
+		}
+		if err := w.WriteRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func summarizeCorrection(corrects []string, deltas []settlementDelta) CorrectionSummary {
+	sum := CorrectionSummary{Corrects: corrects, DaysChanged: len(deltas)}
+	for _, d := range deltas {
+		sum.NetDeltaCents += d.after.net - d.before.net
+		sum.PayableDeltaCents += d.after.payable() - d.before.payable()
+	}
+	return sum
+}
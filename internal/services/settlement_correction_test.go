@@ -0,0 +1,45 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"be/internal/models"
+	"be/internal/repositories"
+)
+
+func TestCorrection(t *testing.T) {
+	day := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+	late := []repositories.LateArrival{
+		{MerchantID: "m-002", Date: day("2025-01-05"), RunID: "job_b"},
+		{MerchantID: "m-001", Date: day("2025-01-02"), RunID: "job_a"},
+		{MerchantID: "m-001", Date: day("2025-01-03"), RunID: "job_b"},
+	}
+	params, from, to := correctionParams(late)
+	if params.Mode != SettlementModeCorrection || len(params.Keys) != 3 {
+		t.Fatalf("params = %+v", params)
+	}
+	if from.Format("2006-01-02") != "2025-01-02" || to.Format("2006-01-02") != "2025-01-05" {
+		t.Errorf("range = %s..%s", from, to)
+	}
+	if len(params.Corrects) != 2 || params.Corrects[0] != "job_a" || params.Corrects[1] != "job_b" {
+		t.Errorf("corrects = %v", params.Corrects)
+	}
+
+	stored := models.Settlement{MerchantID: "m-001", Date: day("2025-01-02"), GrossCents: 1000, FeeCents: 30, NetCents: 970, PayableCents: 970, TxnCount: 1, UniqueRunID: "job_a"}
+	k := settlementKey{"m-001", "2025-01-02"}
+	if _, changed := diffSettlement(k, stored, settlementTotals{gross: 1000, fee: 30, net: 970, count: 1}); changed {
+		t.Error("unchanged day reported as changed")
+	}
+	d, changed := diffSettlement(k, stored, settlementTotals{gross: 1500, fee: 45, net: 1455, count: 2})
+	if !changed || d.originalRunID != "job_a" {
+		t.Fatalf("delta = %+v, changed = %v", d, changed)
+	}
+	sum := summarizeCorrection(params.Corrects, []settlementDelta{d})
+	if sum.DaysChanged != 1 || sum.NetDeltaCents != 485 || sum.PayableDeltaCents != 485 {
+		t.Errorf("summary = %+v", sum)
+	}
+}
@@ -0,0 +1,264 @@
+package services
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// aggEntryBytes is the estimated in-memory cost of one merchant/day in the aggregation map,
+// used to turn a memory budget into an entry limit
+const aggEntryBytes = 128
+
+// spillFanIn caps the run files merged at once, so a long run with a small budget does not open
+// a file (and a read buffer) per spill
+const spillFanIn = 64
+
+// spillAggregator sums partial settlement totals from the workers within a memory budget. Once it
+// holds limit merchant/days it writes them, sorted, to a temp file and starts over; each merges the
+// files with what is still in memory, first merging groups of fanIn files into one while there
+// are more. It is safe for concurrent use.
+type spillAggregator struct {
+	mu      sync.Mutex
+	limit   int // merchant/days held before spilling; 0 never spills
+	fanIn   int // run files merged at once, besides memory
+	mem     map[settlementKey]settlementTotals
+	dir     string
+	runs    []string
+	written int // run files written, merged ones included; names new files
+}
+
+// spillRecord is one merchant/day as written to a spill file
+type spillRecord struct {
+	Merchant, Day          string
+	Gross, Fee, Net, Count int64
+	Cutoff                 int64
+}
+
+func newSpillAggregator(budgetBytes int64) *spillAggregator {
+	return &spillAggregator{limit: int(budgetBytes / aggEntryBytes), fanIn: spillFanIn, mem: map[settlementKey]settlementTotals{}}
+}
+
+// add merges a worker's partial totals, spilling when the budget is reached
+func (a *spillAggregator) add(local map[settlementKey]settlementTotals) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	for k, v := range local {
+		a.mem[k] = mergeTotals(a.mem[k], v)
+	}
+	if a.limit > 0 && len(a.mem) >= a.limit {
+		return a.spill()
+	}
+	return nil
+}
+
+func mergeTotals(a, b settlementTotals) settlementTotals {
+	a.gross += b.gross
+	a.fee += b.fee
+	a.net += b.net
+	a.count += b.count
+	a.cutoff = b.cutoff
+	return a
+}
+
+// spill writes the in-memory totals to a new run file in key order
+func (a *spillAggregator) spill() error {
+	keys := sortedKeys(a.mem)
+	path, err := a.writeRun(func(emit func(settlementKey, settlementTotals) error) error {
+		for _, k := range keys {
+			if err := emit(k, a.mem[k]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	a.runs = append(a.runs, path)
+	a.mem = map[settlementKey]settlementTotals{}
+	return nil
+}
+
+// writeRun writes the merchant/days that fill emits, in key order, to a new run file
+func (a *spillAggregator) writeRun(fill func(emit func(settlementKey, settlementTotals) error) error) (string, error) {
+	if a.dir == "" {
+		dir, err := os.MkdirTemp("", "settlement-agg-")
+		if err != nil {
+			return "", err
+		}
+		a.dir = dir
+	}
+	path := filepath.Join(a.dir, fmt.Sprintf("run-%04d.gob", a.written))
+	a.written++
+	f, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	bw := bufio.NewWriter(f)
+	enc := gob.NewEncoder(bw)
+	err = fill(func(k settlementKey, v settlementTotals) error {
+		return enc.Encode(spillRecord{Merchant: k.merchant, Day: k.day, Gross: v.gross, Fee: v.fee, Net: v.net, Count: v.count, Cutoff: v.cutoff})
+	})
+	if err != nil {
+		return "", err
+	}
+	if err := bw.Flush(); err != nil {
+		return "", err
+	}
+	return path, f.Close()
+}
+
+func sortedKeys(m map[settlementKey]settlementTotals) []settlementKey {
+	keys := make([]settlementKey, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keyLess(keys[i], keys[j]) })
+	return keys
+}
+
+func keyLess(a, b settlementKey) bool {
+	if a.merchant != b.merchant {
+		return a.merchant < b.merchant
+	}
+	return a.day < b.day
+}
+
+// spillSource yields one sorted run, from a file or from memory
+type spillSource struct {
+	next func() (settlementKey, settlementTotals, error) // io.EOF when done
+	key  settlementKey
+	val  settlementTotals
+}
+
+type spillHeap []*spillSource
+
+func (h spillHeap) Len() int           { return len(h) }
+func (h spillHeap) Less(i, j int) bool { return keyLess(h[i].key, h[j].key) }
+func (h spillHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *spillHeap) Push(x any)        { *h = append(*h, x.(*spillSource)) }
+func (h *spillHeap) Pop() any {
+	old := *h
+	s := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return s
+}
+
+// each calls fn for every merchant/day in key order, merging the spilled runs with memory
+func (a *spillAggregator) each(fn func(settlementKey, settlementTotals) error) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// merge the oldest fanIn runs into one until the rest can be opened together
+	for len(a.runs) > a.fanIn {
+		group := a.runs[:a.fanIn]
+		path, err := a.writeRun(func(emit func(settlementKey, settlementTotals) error) error {
+			return mergeRuns(group, nil, emit)
+		})
+		if err != nil {
+			return err
+		}
+		for _, p := range group {
+			if err := os.Remove(p); err != nil {
+				return err
+			}
+		}
+		a.runs = append(a.runs[a.fanIn:], path)
+	}
+
+	memKeys := sortedKeys(a.mem)
+	mem := &spillSource{next: func() (settlementKey, settlementTotals, error) {
+		if len(memKeys) == 0 {
+			return settlementKey{}, settlementTotals{}, io.EOF
+		}
+		k := memKeys[0]
+		memKeys = memKeys[1:]
+		return k, a.mem[k], nil
+	}}
+	return mergeRuns(a.runs, mem, fn)
+}
+
+// mergeRuns calls fn for every merchant/day of the run files and extra (when not nil) in key order,
+// summing the totals of a merchant/day found in more than one
+func mergeRuns(paths []string, extra *spillSource, fn func(settlementKey, settlementTotals) error) error {
+	var sources []*spillSource
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		dec := gob.NewDecoder(bufio.NewReader(f))
+		sources = append(sources, &spillSource{next: func() (settlementKey, settlementTotals, error) {
+			var r spillRecord
+			if err := dec.Decode(&r); err != nil {
+				return settlementKey{}, settlementTotals{}, err
+			}
+			return settlementKey{merchant: r.Merchant, day: r.Day}, settlementTotals{gross: r.Gross, fee: r.Fee, net: r.Net, count: r.Count, cutoff: r.Cutoff}, nil
+		}})
+	}
+	if extra != nil {
+		sources = append(sources, extra)
+	}
+
+	h := &spillHeap{}
+	advance := func(s *spillSource) error {
+		k, v, err := s.next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.key, s.val = k, v
+		heap.Push(h, s)
+		return nil
+	}
+	for _, s := range sources {
+		if err := advance(s); err != nil {
+			return err
+		}
+	}
+	for h.Len() > 0 {
+		s := heap.Pop(h).(*spillSource)
+		k, v := s.key, s.val
+		if err := advance(s); err != nil {
+			return err
+		}
+		for h.Len() > 0 && (*h)[0].key == k {
+			same := heap.Pop(h).(*spillSource)
+			v = mergeTotals(v, same.val)
+			if err := advance(same); err != nil {
+				return err
+			}
+		}
+		if err := fn(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// spills reports how many run files were written
+func (a *spillAggregator) spills() int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return len(a.runs)
+}
+
+// close removes the spill files
+func (a *spillAggregator) close() error {
+	if a.dir == "" {
+		return nil
+	}
+	return os.RemoveAll(a.dir)
+}
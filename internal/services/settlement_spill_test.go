@@ -0,0 +1,107 @@
+package services
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestSpillAggregatorMergesRuns(t *testing.T) {
+	agg := newSpillAggregator(2 * aggEntryBytes) // spill every second merchant/day
+	batches := []map[settlementKey]settlementTotals{
+		{{"m-002", "2025-01-01"}: {gross: 100, fee: 3, net: 97, count: 1, cutoff: 60}},
+		{{"m-001", "2025-01-02"}: {gross: 200, fee: 6, net: 194, count: 1}},
+		{{"m-002", "2025-01-01"}: {gross: 50, fee: 1, net: 49, count: 1, cutoff: 60}, {"m-001", "2025-01-01"}: {gross: 10, net: 10, count: 1}},
+		{{"m-001", "2025-01-02"}: {gross: 300, fee: 9, net: 291, count: 2}},
+	}
+	for _, b := range batches {
+		if err := agg.add(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if agg.spills() < 2 {
+		t.Fatalf("spills = %d, want at least 2 with a two-entry budget", agg.spills())
+	}
+	dir := agg.dir
+
+	var keys []settlementKey
+	got := map[settlementKey]settlementTotals{}
+	err := agg.each(func(k settlementKey, v settlementTotals) error {
+		keys = append(keys, k)
+		got[k] = v
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[settlementKey]settlementTotals{
+		{"m-001", "2025-01-01"}: {gross: 10, net: 10, count: 1},
+		{"m-001", "2025-01-02"}: {gross: 500, fee: 15, net: 485, count: 3},
+		{"m-002", "2025-01-01"}: {gross: 150, fee: 4, net: 146, count: 2, cutoff: 60},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("merged = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%v = %+v, want %+v", k, got[k], v)
+		}
+	}
+	for i := 1; i < len(keys); i++ {
+		if !keyLess(keys[i-1], keys[i]) {
+			t.Errorf("keys out of order: %v", keys)
+		}
+	}
+
+	if err := agg.close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("spill dir %s not removed", dir)
+	}
+}
+
+func TestSpillAggregatorCapsFanIn(t *testing.T) {
+	agg := newSpillAggregator(aggEntryBytes) // spill on every add
+	agg.fanIn = 3
+	defer func() { _ = agg.close() }()
+	want := map[settlementKey]settlementTotals{}
+	for i := 0; i < 20; i++ {
+		k := settlementKey{"m-001", fmt.Sprintf("2025-01-%02d", 1+i%7)}
+		v := settlementTotals{gross: int64(i), net: int64(i), count: 1}
+		want[k] = mergeTotals(want[k], v)
+		if err := agg.add(map[settlementKey]settlementTotals{k: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if agg.spills() != 20 {
+		t.Fatalf("spills = %d, want 20", agg.spills())
+	}
+
+	got := map[settlementKey]settlementTotals{}
+	err := agg.each(func(k settlementKey, v settlementTotals) error {
+		got[k] = v
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(want) {
+		t.Fatalf("merged = %v, want %v", got, want)
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%v = %+v, want %+v", k, got[k], v)
+		}
+	}
+	if n := agg.spills(); n > agg.fanIn {
+		t.Errorf("%d runs left for the final merge, want at most %d", n, agg.fanIn)
+	}
+	files, err := os.ReadDir(agg.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != agg.spills() {
+		t.Errorf("%d files in the spill dir, want the %d runs left", len(files), agg.spills())
+	}
+}
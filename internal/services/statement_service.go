@@ -0,0 +1,108 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"be/internal/repositories"
+	"be/internal/statement"
+	"be/internal/storage"
+)
+
+var ErrMerchantRequired = errors.New("MERCHANT_ID_REQUIRED")
+
+// StatementParams are the job parameters of a STATEMENT job
+type StatementParams struct {
+	MerchantID string `json:"merchant_id"`
+}
+
+type StatementService interface {
+	StartStatement(ctx context.Context, id string, from, to time.Time, params StatementParams, requestedBy string) error
+}
+
+type statementService struct {
+	jobs     repositories.JobRepository
+	repo     repositories.StatementRepository
+	jobSvc   JobService
+	currency string
+	store    storage.ArtifactStore
+}
+
+// NewStatementService registers the STATEMENT job runner; amounts are reported in currency
+func NewStatementService(jobs repositories.JobRepository, repo repositories.StatementRepository, jobSvc JobService, currency string, store storage.ArtifactStore) StatementService {
+	ss := &statementService{jobs: jobs, repo: repo, jobSvc: jobSvc, currency: currency, store: store}
+	jobSvc.Register(repositories.JobTypeStatement, ss.run)
+	return ss
+}
+
+// StartStatement records a STATEMENT job for one merchant and period and enqueues it
+func (s *statementService) StartStatement(ctx context.Context, id string, from, to time.Time, params StatementParams, requestedBy string) error {
+	if params.MerchantID == "" {
+		return ErrMerchantRequired
+	}
+	if err := s.jobs.Create(ctx, id, repositories.JobTypeStatement, 0, from, to, params, requestedBy); err != nil {
+		return err
+	}
+	s.jobSvc.Enqueue(id)
+	return nil
+}
+
+// run builds the statement and writes it as camt.053 XML and MT940 text into one zip archive
+func (s *statementService) run(ctx context.Context, job *repositories.JobRow) error {
+	var params StatementParams
+	if err := job.DecodeParams(&params); err != nil {
+		return err
+	}
+	from, to := job.FromDate.Time, job.ToDate.Time
+	opening, err := s.repo.OpeningBalance(ctx, params.MerchantID, from)
+	if err != nil {
+		return err
+	}
+	rows, err := s.repo.Entries(ctx, params.MerchantID, from, to)
+	if err != nil {
+		return err
+	}
+	st := &statement.Statement{
+		ID:                  job.ID,
+		MerchantID:          params.MerchantID,
+		Currency:            s.currency,
+		From:                from,
+		To:                  to,
+		CreatedAt:           time.Now().UTC(),
+		OpeningBalanceCents: opening,
+	}
+	for _, r := range rows {
+		st.Entries = append(st.Entries, statement.Entry{Date: r.BookingDate, AmountCents: r.AmountCents, Kind: r.Kind, Reference: r.Reference, Description: r.Description})
+	}
+	_ = s.jobs.SetProgress(ctx, job.ID, int64(len(rows)))
+
+	outKey := job.ID + ".zip"
+	if err := storage.Put(ctx, s.store, outKey, func(w io.Writer) error { return writeStatementArchive(w, st) }); err != nil {
+		return err
+	}
+	return s.jobs.SetCompleted(ctx, job.ID, outKey)
+}
+
+func writeStatementArchive(f io.Writer, st *statement.Statement) error {
+	zw := zip.NewWriter(f)
+	base := fmt.Sprintf("%s_%s_%s", st.MerchantID, st.From.Format("20060102"), st.To.Format("20060102"))
+	camt, err := zw.Create(base + ".camt053.xml")
+	if err != nil {
+		return err
+	}
+	if err := statement.WriteCAMT053(camt, st); err != nil {
+		return err
+	}
+	mt, err := zw.Create(base + ".mt940.txt")
+	if err != nil {
+		return err
+	}
+	if err := statement.WriteMT940(mt, st); err != nil {
+		return err
+	}
+	return zw.Close()
+}
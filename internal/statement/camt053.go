@@ -0,0 +1,161 @@
+package statement
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+const camt053Namespace = "urn:iso:std:iso:20022:tech:xsd:camt.053.001.02"
+
+type camtDocument struct {
+	XMLName xml.Name     `xml:"Document"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Stmt    camtBkToCstm `xml:"BkToCstmrStmt"`
+}
+
+type camtBkToCstm struct {
+	GrpHdr camtGroupHeader `xml:"GrpHdr"`
+	Stmt   camtStatement   `xml:"Stmt"`
+}
+
+type camtGroupHeader struct {
+	MsgID   string `xml:"MsgId"`
+	CreDtTm string `xml:"CreDtTm"`
+}
+
+type camtStatement struct {
+	ID        string      `xml:"Id"`
+	CreDtTm   string      `xml:"CreDtTm"`
+	FrToDt    camtPeriod  `xml:"FrToDt"`
+	Acct      camtAccount `xml:"Acct"`
+	Bal       []camtBal   `xml:"Bal"`
+	TxsSummry camtSummary `xml:"TxsSummry"`
+	Ntry      []camtEntry `xml:"Ntry"`
+}
+
+type camtPeriod struct {
+	FrDtTm string `xml:"FrDtTm"`
+	ToDtTm string `xml:"ToDtTm"`
+}
+
+type camtAccount struct {
+	ID struct {
+		Othr struct {
+			ID string `xml:"Id"`
+		} `xml:"Othr"`
+	} `xml:"Id"`
+	Ccy string `xml:"Ccy"`
+}
+
+type camtAmount struct {
+	Ccy   string `xml:"Ccy,attr"`
+	Value string `xml:",chardata"`
+}
+
+type camtDate struct {
+	Dt string `xml:"Dt"`
+}
+
+type camtBal struct {
+	Tp struct {
+		CdOrPrtry struct {
+			Cd string `xml:"Cd"`
+		} `xml:"CdOrPrtry"`
+	} `xml:"Tp"`
+	Amt       camtAmount `xml:"Amt"`
+	CdtDbtInd string     `xml:"CdtDbtInd"`
+	Dt        camtDate   `xml:"Dt"`
+}
+
+type camtSummary struct {
+	TtlNtries struct {
+		NbOfNtries    int    `xml:"NbOfNtries"`
+		Sum           string `xml:"Sum"`
+		TtlNetNtryAmt string `xml:"TtlNetNtryAmt"`
+		CdtDbtInd     string `xml:"CdtDbtInd"`
+	} `xml:"TtlNtries"`
+}
+
+type camtEntry struct {
+	Amt         camtAmount `xml:"Amt"`
+	CdtDbtInd   string     `xml:"CdtDbtInd"`
+	Sts         string     `xml:"Sts"`
+	BookgDt     camtDate   `xml:"BookgDt"`
+	ValDt       camtDate   `xml:"ValDt"`
+	AcctSvcrRef string     `xml:"AcctSvcrRef"`
+	BkTxCd      struct {
+		Prtry struct {
+			Cd string `xml:"Cd"`
+		} `xml:"Prtry"`
+	} `xml:"BkTxCd"`
+	AddtlNtryInf string `xml:"AddtlNtryInf,omitempty"`
+}
+
+func creditDebit(cents int64) string {
+	if cents < 0 {
+		return "DBIT"
+	}
+	return "CRDT"
+}
+
+func camtBalance(code string, cents int64, ccy, date string) camtBal {
+	var b camtBal
+	b.Tp.CdOrPrtry.Cd = code
+	b.Amt = camtAmount{Ccy: ccy, Value: decimal(cents, ".")}
+	b.CdtDbtInd = creditDebit(cents)
+	b.Dt.Dt = date
+	return b
+}
+
+// WriteCAMT053 writes the statement as an ISO 20022 camt.053.001.02 bank-to-customer statement
+func WriteCAMT053(w io.Writer, s *Statement) error {
+	created := s.CreatedAt.UTC().Format("2006-01-02T15:04:05")
+	st := camtStatement{
+		ID:      swiftText(s.ID, 35),
+		CreDtTm: created,
+		FrToDt: camtPeriod{
+			FrDtTm: s.From.Format("2006-01-02") + "T00:00:00",
+			ToDtTm: s.To.Format("2006-01-02") + "T23:59:59",
+		},
+		Bal: []camtBal{
+			camtBalance("OPBD", s.OpeningBalanceCents, s.Currency, s.From.Format("2006-01-02")),
+			camtBalance("CLBD", s.ClosingBalanceCents(), s.Currency, s.To.Format("2006-01-02")),
+		},
+	}
+	st.Acct.ID.Othr.ID = s.MerchantID
+	st.Acct.Ccy = s.Currency
+	count, sum, net := s.totals()
+	st.TxsSummry.TtlNtries.NbOfNtries = count
+	st.TxsSummry.TtlNtries.Sum = decimal(sum, ".")
+	st.TxsSummry.TtlNtries.TtlNetNtryAmt = decimal(net, ".")
+	st.TxsSummry.TtlNtries.CdtDbtInd = creditDebit(net)
+	for _, e := range s.Entries {
+		date := e.Date.Format("2006-01-02")
+		ne := camtEntry{
+			Amt:          camtAmount{Ccy: s.Currency, Value: decimal(e.AmountCents, ".")},
+			CdtDbtInd:    creditDebit(e.AmountCents),
+			Sts:          "BOOK",
+			BookgDt:      camtDate{Dt: date},
+			ValDt:        camtDate{Dt: date},
+			AcctSvcrRef:  swiftText(e.Reference, 35),
+			AddtlNtryInf: e.Description,
+		}
+		ne.BkTxCd.Prtry.Cd = e.Kind
+		st.Ntry = append(st.Ntry, ne)
+	}
+
+	doc := camtDocument{Xmlns: camt053Namespace}
+	doc.Stmt.GrpHdr = camtGroupHeader{MsgID: swiftText(s.ID, 35), CreDtTm: created}
+	doc.Stmt.Stmt = st
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
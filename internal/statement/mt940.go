@@ -0,0 +1,82 @@
+package statement
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"strings"
+)
+
+const (
+	mt940LineEnd       = "\r\n"
+	mt940InfoLineLen   = 65
+	mt940InfoMaxLines  = 6
+	mt940ReferenceSize = 16
+)
+
+// mt940Mark is the debit/credit mark of a balance or statement line
+func mt940Mark(cents int64) string {
+	if cents < 0 {
+		return "D"
+	}
+	return "C"
+}
+
+// mt940Balance renders a :60F:/:62F: balance value
+func mt940Balance(cents int64, date, ccy string) string {
+	return mt940Mark(cents) + date + ccy + decimal(cents, ",")
+}
+
+// mt940Reference is the 16-character :20: reference for a statement id: the id when it fits, the
+// random suffix of a job id (job_<timestamp>_<16 hex>), else a hash of the id, so that truncation
+// never makes two statements share a reference
+func mt940Reference(id string) string {
+	if ref := swiftText(id, len(id)); len(ref) <= mt940ReferenceSize {
+		return ref
+	}
+	if i := strings.LastIndexByte(id, '_'); i >= 0 && len(id)-i-1 == mt940ReferenceSize {
+		if _, err := hex.DecodeString(id[i+1:]); err == nil {
+			return id[i+1:]
+		}
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:mt940ReferenceSize/2])
+}
+
+// WriteMT940 writes the statement as the text block of a SWIFT MT940 customer statement message
+func WriteMT940(w io.Writer, s *Statement) error {
+	var lines []string
+	add := func(l string) { lines = append(lines, l) }
+
+	add(":20:" + mt940Reference(s.ID))
+	add(":25:" + swiftText(s.MerchantID, 35))
+	add(":28C:1/1")
+	add(":60F:" + mt940Balance(s.OpeningBalanceCents, s.From.Format("060102"), s.Currency))
+	for _, e := range s.Entries {
+		ref := swiftText(e.Reference, mt940ReferenceSize)
+		if ref == "" {
+			ref = "NONREF"
+		}
+		add(":61:" + e.Date.Format("060102") + e.Date.Format("0102") + mt940Mark(e.AmountCents) +
+			decimal(e.AmountCents, ",") + "NMSC" + ref)
+		info := swiftText(e.Kind+" "+e.Description, mt940InfoLineLen*mt940InfoMaxLines)
+		for first := true; info != "" || first; first = false {
+			n := min(len(info), mt940InfoLineLen)
+			if first {
+				add(":86:" + info[:n])
+			} else {
+				add(info[:n])
+			}
+			info = info[n:]
+		}
+	}
+	add(":62F:" + mt940Balance(s.ClosingBalanceCents(), s.To.Format("060102"), s.Currency))
+	add("-")
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(strings.Join(lines, mt940LineEnd) + mt940LineEnd); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,78 @@
+// Package statement renders a merchant's settlement activity as bank-style account statements.
+package statement
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// Entry kinds, used as the proprietary bank transaction code
+const (
+	KindSettlement = "SETTLEMENT"
+	KindPayout     = "PAYOUT"
+	KindAdjustment = "ADJUSTMENT"
+)
+
+// Entry is one booked movement on the merchant account; credits are positive, debits negative
+type Entry struct {
+	Date        time.Time
+	AmountCents int64
+	Kind        string
+	Reference   string
+	Description string
+}
+
+// Statement is the activity of one merchant account over a period
+type Statement struct {
+	ID                  string
+	MerchantID          string
+	Currency            string
+	From, To            time.Time
+	CreatedAt           time.Time
+	OpeningBalanceCents int64
+	Entries             []Entry
+}
+
+// ClosingBalanceCents is the opening balance plus every entry
+func (s *Statement) ClosingBalanceCents() int64 {
+	b := s.OpeningBalanceCents
+	for _, e := range s.Entries {
+		b += e.AmountCents
+	}
+	return b
+}
+
+// totals returns the number of entries, the sum of their absolute amounts and their net amount
+func (s *Statement) totals() (count int, sum, net int64) {
+	for _, e := range s.Entries {
+		sum += abs(e.AmountCents)
+		net += e.AmountCents
+	}
+	return len(s.Entries), sum, net
+}
+
+func abs(v int64) int64 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// swiftInvalid matches characters outside the SWIFT "x" character set
+var swiftInvalid = regexp.MustCompile(`[^A-Za-z0-9/\-?:().,'+ ]`)
+
+// swiftText replaces characters outside the SWIFT character set and truncates to n characters
+func swiftText(s string, n int) string {
+	s = swiftInvalid.ReplaceAllString(s, "-")
+	if len(s) > n {
+		s = s[:n]
+	}
+	return s
+}
+
+// decimal renders an absolute amount in cents with the given decimal separator
+func decimal(cents int64, sep string) string {
+	cents = abs(cents)
+	return fmt.Sprintf("%d%s%02d", cents/100, sep, cents%100)
+}
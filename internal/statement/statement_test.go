@@ -0,0 +1,84 @@
+package statement
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite golden files")
+
+func date(s string) time.Time {
+	d, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func testStatement() *Statement {
+	return &Statement{
+		ID:                  "job_20250201090000_0123456789abcdef",
+		MerchantID:          "m-001",
+		Currency:            "USD",
+		From:                date("2025-01-01"),
+		To:                  date("2025-01-31"),
+		CreatedAt:           time.Date(2025, 2, 1, 9, 0, 0, 0, time.UTC),
+		OpeningBalanceCents: 150000,
+		Entries: []Entry{
+			{Date: date("2025-01-01"), AmountCents: 970, Kind: KindSettlement, Reference: "STL-20250101", Description: "Settlement 2025-01-01, 1 transactions"},
+			{Date: date("2025-01-02"), AmountCents: 1255040, Kind: KindSettlement, Reference: "STL-20250102", Description: "Settlement 2025-01-02, 100 transactions"},
+			{Date: date("2025-01-03"), AmountCents: -151010, Kind: KindPayout, Reference: "PAYOUT-42", Description: "Payout 42 bank reference ACH_0001 and a long description that wraps onto a second line"},
+		},
+	}
+}
+
+func TestClosingBalance(t *testing.T) {
+	if got := testStatement().ClosingBalanceCents(); got != 1255000 {
+		t.Fatalf("closing balance = %d, want 1255000", got)
+	}
+}
+
+func TestMT940Reference(t *testing.T) {
+	if got := mt940Reference("job_20250201090000_0123456789abcdef"); got != "0123456789abcdef" {
+		t.Fatalf("job id reference = %q, want the random suffix", got)
+	}
+	if got := mt940Reference("stmt-42"); got != "stmt-42" {
+		t.Fatalf("short id reference = %q", got)
+	}
+	a, b := mt940Reference("job_20250201090000_correction"), mt940Reference("job_20250201090001_correction")
+	if len(a) != 16 || a == b {
+		t.Fatalf("long ids must hash to distinct 16-character references, got %q and %q", a, b)
+	}
+}
+
+func TestWritersGolden(t *testing.T) {
+	writers := map[string]func(*bytes.Buffer, *Statement) error{
+		"camt053": func(b *bytes.Buffer, s *Statement) error { return WriteCAMT053(b, s) },
+		"mt940":   func(b *bytes.Buffer, s *Statement) error { return WriteMT940(b, s) },
+	}
+	for name, write := range writers {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := write(&buf, testStatement()); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", name+".golden")
+			if *update {
+				if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), want) {
+				t.Fatalf("%s output differs from %s; run go test ./internal/statement -update to accept\n%s", name, golden, buf.String())
+			}
+		})
+	}
+}
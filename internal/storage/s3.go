@@ -0,0 +1,315 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minPartSize is the smallest part S3 accepts in a multipart upload, other than the last
+const minPartSize = 5 << 20
+
+// S3Config addresses a bucket of an S3-compatible service. Objects are addressed path-style,
+// <endpoint>/<bucket>/<prefix><key>, which AWS and MinIO both accept.
+type S3Config struct {
+	Endpoint        string // e.g. https://s3.us-east-1.amazonaws.com or http://localhost:9000
+	Region          string
+	Bucket          string
+	Prefix          string // prepended to every key, e.g. "settlements/"
+	AccessKeyID     string
+	SecretAccessKey string
+	PartSize        int64 // multipart upload part size; at least 5 MiB, 8 MiB when zero
+}
+
+type s3Store struct {
+	cfg      S3Config
+	endpoint *url.URL
+	client   *http.Client
+}
+
+// NewS3Store stores artifacts in an S3-compatible bucket, which must already exist
+func NewS3Store(cfg S3Config) (ArtifactStore, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("s3: bucket is required")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = 8 << 20
+	}
+	if cfg.PartSize < minPartSize {
+		return nil, fmt.Errorf("s3: part size %d is below 5 MiB", cfg.PartSize)
+	}
+	u, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("s3: invalid endpoint %q", cfg.Endpoint)
+	}
+	return &s3Store{cfg: cfg, endpoint: u, client: &http.Client{}}, nil
+}
+
+// s3Error is the error document S3 returns
+type s3Error struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+// do sends a signed request for key (the bucket itself when key is empty) and returns the response
+// of a 2xx status; any other status is returned as an error, ErrNotFound for a missing key
+func (s *s3Store) do(ctx context.Context, method, key string, query map[string]string, body []byte) (*http.Response, error) {
+	u := *s.endpoint
+	u.Path = u.Path + "/" + s.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + s.cfg.Prefix + key
+	}
+	u.RawPath = uriEncode(u.Path, true)
+	u.RawQuery = canonicalQuery(query)
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	if len(body) == 0 {
+		req.Body = http.NoBody
+	}
+	payloadHash := emptyPayloadHash
+	if len(body) > 0 {
+		sum := sha256.Sum256(body)
+		payloadHash = hex.EncodeToString(sum[:])
+	}
+	signV4(req, payloadHash, s.cfg.AccessKeyID, s.cfg.SecretAccessKey, s.cfg.Region, time.Now())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	var e s3Error
+	_ = xml.Unmarshal(raw, &e)
+	if resp.StatusCode == http.StatusNotFound && (e.Code == "" || e.Code == "NoSuchKey") {
+		return nil, ErrNotFound
+	}
+	if e.Code == "" {
+		e.Code = resp.Status
+	}
+	return nil, fmt.Errorf("s3 %s %s: %s %s", method, key, e.Code, e.Message)
+}
+
+// doXML sends a request and decodes its XML response into v; S3 can report an error in a 200 response
+func (s *s3Store) doXML(ctx context.Context, method, key string, query map[string]string, body []byte, v any) error {
+	resp, err := s.do(ctx, method, key, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var e s3Error
+	if xml.Unmarshal(raw, &e) == nil && e.Code != "" {
+		return fmt.Errorf("s3 %s %s: %s %s", method, key, e.Code, e.Message)
+	}
+	return xml.Unmarshal(raw, v)
+}
+
+func drain(resp *http.Response) {
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+// s3Writer buffers one part at a time: an artifact smaller than a part is sent with one PUT,
+// a larger one as a multipart upload
+type s3Writer struct {
+	s        *s3Store
+	ctx      context.Context
+	key      string
+	buf      bytes.Buffer
+	uploadID string
+	parts    []s3Part
+	done     bool
+}
+
+type s3Part struct {
+	Number int    `xml:"PartNumber"`
+	ETag   string `xml:"ETag"`
+}
+
+func (s *s3Store) Create(ctx context.Context, key string) (Writer, error) {
+	if err := checkKey(key); err != nil {
+		return nil, err
+	}
+	return &s3Writer{s: s, ctx: ctx, key: key}, nil
+}
+
+func (w *s3Writer) Write(p []byte) (int, error) {
+	if w.done {
+		return 0, errors.New("s3: write after close")
+	}
+	n, _ := w.buf.Write(p)
+	for int64(w.buf.Len()) >= w.s.cfg.PartSize {
+		if err := w.uploadPart(w.buf.Next(int(w.s.cfg.PartSize))); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (w *s3Writer) uploadPart(data []byte) error {
+	if w.uploadID == "" {
+		var res struct {
+			UploadID string `xml:"UploadId"`
+		}
+		if err := w.s.doXML(w.ctx, http.MethodPost, w.key, map[string]string{"uploads": ""}, nil, &res); err != nil {
+			return err
+		}
+		w.uploadID = res.UploadID
+	}
+	n := len(w.parts) + 1
+	resp, err := w.s.do(w.ctx, http.MethodPut, w.key, map[string]string{"partNumber": strconv.Itoa(n), "uploadId": w.uploadID}, data)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	w.parts = append(w.parts, s3Part{Number: n, ETag: resp.Header.Get("ETag")})
+	return nil
+}
+
+func (w *s3Writer) Close() error {
+	if w.done {
+		return nil
+	}
+	if w.uploadID == "" {
+		w.done = true
+		resp, err := w.s.do(w.ctx, http.MethodPut, w.key, nil, w.buf.Bytes())
+		if err != nil {
+			return err
+		}
+		drain(resp)
+		return nil
+	}
+	if w.buf.Len() > 0 {
+		if err := w.uploadPart(w.buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	w.done = true
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []s3Part `xml:"Part"`
+	}{Parts: w.parts})
+	if err != nil {
+		return err
+	}
+	var res struct{}
+	if err := w.s.doXML(w.ctx, http.MethodPost, w.key, map[string]string{"uploadId": w.uploadID}, body, &res); err != nil {
+		w.done = false
+		return err
+	}
+	return nil
+}
+
+func (w *s3Writer) Abort() error {
+	if w.done {
+		return nil
+	}
+	w.done = true
+	if w.uploadID == "" {
+		return nil
+	}
+	resp, err := w.s.do(context.WithoutCancel(w.ctx), http.MethodDelete, w.key, map[string]string{"uploadId": w.uploadID}, nil)
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
+
+// info reads an object's size and modification time from response headers
+func info(key string, resp *http.Response) ObjectInfo {
+	oi := ObjectInfo{Key: key, Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		oi.ModTime = t
+	}
+	return oi
+}
+
+func (s *s3Store) Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error) {
+	if err := checkKey(key); err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, key, nil, nil)
+	if err != nil {
+		return nil, ObjectInfo{}, err
+	}
+	return resp.Body, info(key, resp), nil
+}
+
+func (s *s3Store) Stat(ctx context.Context, key string) (ObjectInfo, error) {
+	if err := checkKey(key); err != nil {
+		return ObjectInfo{}, err
+	}
+	resp, err := s.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return ObjectInfo{}, err
+	}
+	drain(resp)
+	return info(key, resp), nil
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	out := []ObjectInfo{}
+	query := map[string]string{"list-type": "2", "prefix": s.cfg.Prefix + prefix}
+	for {
+		var res struct {
+			Contents []struct {
+				Key          string    `xml:"Key"`
+				Size         int64     `xml:"Size"`
+				LastModified time.Time `xml:"LastModified"`
+			} `xml:"Contents"`
+			IsTruncated           bool   `xml:"IsTruncated"`
+			NextContinuationToken string `xml:"NextContinuationToken"`
+		}
+		if err := s.doXML(ctx, http.MethodGet, "", query, nil, &res); err != nil {
+			return nil, err
+		}
+		for _, c := range res.Contents {
+			out = append(out, ObjectInfo{Key: strings.TrimPrefix(c.Key, s.cfg.Prefix), Size: c.Size, ModTime: c.LastModified})
+		}
+		if !res.IsTruncated || res.NextContinuationToken == "" {
+			return out, nil
+		}
+		query["continuation-token"] = res.NextContinuationToken
+	}
+}
+
+func (s *s3Store) Delete(ctx context.Context, key string) error {
+	if err := checkKey(key); err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodDelete, key, nil, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	drain(resp)
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package storage keeps job artifacts in a store shared by every app replica: a local directory
+// or an S3-compatible bucket.
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("ARTIFACT_NOT_FOUND")
+	ErrInvalidKey = errors.New("INVALID_ARTIFACT_KEY")
+)
+
+// ObjectInfo describes a stored artifact
+type ObjectInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Writer streams one artifact into a store. The artifact becomes visible only when Close
+// succeeds; Abort discards what was written. Either may be called after the other.
+type Writer interface {
+	io.Writer
+	Close() error
+	Abort() error
+}
+
+// ArtifactStore stores job artifacts by key. Keys are slash separated relative names.
+type ArtifactStore interface {
+	Create(ctx context.Context, key string) (Writer, error)
+	// Open returns the artifact's content; it is an io.ReadSeeker when the store can seek
+	Open(ctx context.Context, key string) (io.ReadCloser, ObjectInfo, error)
+	Stat(ctx context.Context, key string) (ObjectInfo, error)
+	// List returns the artifacts whose key starts with prefix, in key order
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Delete removes an artifact; deleting a missing one is not an error
+	Delete(ctx context.Context, key string) error
+}
+
+// TempLister is a store whose writers leave temp files behind when the process dies before Close or
+// Abort, like the local store; S3 multipart uploads are left to the bucket's lifecycle rules
+type TempLister interface {
+	// ListTemp returns the temp files, in key order, under keys Delete accepts
+	ListTemp(ctx context.Context) ([]ObjectInfo, error)
+}
+
+// checkKey rejects keys that are empty, absolute or step out of the store
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return ErrInvalidKey
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return ErrInvalidKey
+		}
+	}
+	return nil
+}
+
+// Put stores an artifact written by write, discarding it when write fails
+func Put(ctx context.Context, s ArtifactStore, key string, write func(io.Writer) error) error {
+	w, err := s.Create(ctx, key)
+	if err != nil {
+		return err
+	}
+	if err := write(w); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		_ = w.Abort()
+		return err
+	}
+	return nil
+}
@@ -2,21 +2,34 @@ package main
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+	_ "time/tzdata"
 
 	"github.com/gin-gonic/gin"
 
+	"be/internal/bankfile"
 	dbpkg "be/internal/db"
+	"be/internal/downloads"
 	"be/internal/handlers"
+	"be/internal/manifest"
 	"be/internal/repositories"
 	"be/internal/services"
+	"be/internal/storage"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerify(os.Args[2:]))
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -33,26 +46,177 @@ func main() {
 	jobRepo := repositories.NewJobRepository(db)
 	txRepo := repositories.NewTransactionRepository(db)
 	stRepo := repositories.NewSettlementRepository(db)
+
+	var holidays *services.HolidayCalendar
+	if path := os.Getenv("HOLIDAYS_FILE"); path != "" {
+		if holidays, err = services.LoadHolidayCalendar(path); err != nil {
+			log.Fatalf("load holidays: %v", err)
+		}
+	}
+	reserveRepo := repositories.NewReserveRepository(db)
+	merchantRepo := repositories.NewMerchantRepository(db)
+	merchantSvc := services.NewMerchantService(merchantRepo, stRepo, reserveRepo, holidays)
+	merchantHandler := handlers.NewMerchantHandler(merchantSvc)
+
 	workers := 8
 	if ws := os.Getenv("WORKERS"); ws != "" {
 		if n, err := strconv.Atoi(ws); err == nil && n > 0 {
 			workers = n
 		}
 	}
-	jobSvc := services.NewJobService(jobRepo, txRepo, stRepo, workers)
-	jobHandler := handlers.NewJobHandler(jobRepo, jobSvc)
+	// each partition holds a connection while it scans; leave half the pool to the API and other jobs
+	partitions := 4
+	if ps := os.Getenv("SCAN_PARTITIONS"); ps != "" {
+		if n, err := strconv.Atoi(ps); err == nil && n > 0 {
+			partitions = n
+		}
+	}
+	if limit := max(db.Stats().MaxOpenConnections/2, 1); partitions > limit {
+		log.Printf("SCAN_PARTITIONS %d exceeds half the DB pool, using %d", partitions, limit)
+		partitions = limit
+	}
+	aggMemoryMB := 256
+	if v := os.Getenv("AGGREGATION_MEMORY_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			aggMemoryMB = n
+		}
+	}
+	aggMemory := int64(aggMemoryMB) << 20
+	cutoff, err := services.ParseCutoff(os.Getenv("SETTLEMENT_CUTOFF"))
+	if err != nil {
+		log.Fatalf("invalid SETTLEMENT_CUTOFF: %v", err)
+	}
+	adjustmentRepo := repositories.NewAdjustmentRepository(db)
+	auditRepo := repositories.NewAuditRepository(db)
+	adjustmentSvc := services.NewAdjustmentService(adjustmentRepo, auditRepo)
+	adjustmentHandler := handlers.NewAdjustmentHandler(adjustmentSvc)
+
+	store, err := newArtifactStore()
+	if err != nil {
+		log.Fatalf("artifact store: %v", err)
+	}
+	var signer ed25519.PrivateKey
+	if path := os.Getenv("MANIFEST_SIGNING_KEY"); path != "" {
+		if signer, err = manifest.LoadPrivateKey(path); err != nil {
+			log.Fatalf("load manifest signing key: %v", err)
+		}
+	} else {
+		log.Printf("MANIFEST_SIGNING_KEY not set, job manifests are written unsigned")
+	}
+	jobSvc := services.NewJobService(jobRepo, txRepo, stRepo, adjustmentRepo, reserveRepo, workers, partitions, aggMemory, cutoff, store, signer)
+	links, err := newDownloadSigner()
+	if err != nil {
+		log.Fatalf("download links: %v", err)
+	}
+	jobAccess := handlers.NewJobAccess(strings.Split(os.Getenv("JOB_ADMINS"), ","))
+	jobHandler := handlers.NewJobHandler(jobRepo, jobSvc, store, links, jobAccess, auditRepo)
+	reconciliationSvc := services.NewReconciliationService(jobRepo, txRepo, stRepo, adjustmentRepo, reserveRepo, jobSvc, workers, partitions, aggMemory, cutoff, store)
+	reconciliationHandler := handlers.NewReconciliationHandler(reconciliationSvc)
+
+	ledgerSyncInterval := 30 * time.Second
+	if v := os.Getenv("LEDGER_SYNC_INTERVAL"); v != "" {
+		if ledgerSyncInterval, err = time.ParseDuration(v); err != nil || ledgerSyncInterval <= 0 {
+			log.Fatalf("invalid LEDGER_SYNC_INTERVAL: %q", v)
+		}
+	}
+	ledgerSvc := services.NewLedgerService(repositories.NewLedgerRepository(db), cutoff)
+	ledgerHandler := handlers.NewLedgerHandler(ledgerSvc)
+	go ledgerSvc.Follow(context.Background(), ledgerSyncInterval)
+
+	lateArrivalInterval := time.Minute
+	if v := os.Getenv("LATE_ARRIVAL_INTERVAL"); v != "" {
+		if lateArrivalInterval, err = time.ParseDuration(v); err != nil || lateArrivalInterval <= 0 {
+			log.Fatalf("invalid LATE_ARRIVAL_INTERVAL: %q", v)
+		}
+	}
+	go jobSvc.WatchLateArrivals(context.Background(), lateArrivalInterval)
+
+	payoutRepo := repositories.NewPayoutRepository(db)
+	payoutSvc := services.NewPayoutService(payoutRepo, jobRepo, jobSvc, store)
+	payoutHandler := handlers.NewPayoutHandler(payoutSvc, jobAccess)
+
+	var bankCfg *bankfile.Config
+	if path := os.Getenv("BANK_CONFIG_FILE"); path != "" {
+		if bankCfg, err = bankfile.LoadConfig(path); err != nil {
+			log.Fatalf("load bank config: %v", err)
+		}
+	}
+	bankFileSvc := services.NewBankFileService(jobRepo, payoutRepo, jobSvc, bankCfg, store)
+	bankFileHandler := handlers.NewBankFileHandler(bankFileSvc)
+
+	currency := "USD"
+	if bankCfg != nil {
+		currency = bankCfg.Originator.Currency
+	}
+	statementRepo := repositories.NewStatementRepository(db)
+	statementSvc := services.NewStatementService(jobRepo, statementRepo, jobSvc, currency, store)
+	statementHandler := handlers.NewStatementHandler(statementSvc)
 
+	retention, err := services.ParseRetention(os.Getenv("ARTIFACT_RETENTION"))
+	if err != nil {
+		log.Fatalf("invalid ARTIFACT_RETENTION: %v", err)
+	}
+	sweeper := services.NewArtifactSweeper(jobRepo, jobSvc, store, retention)
+	sweepHandler := handlers.NewArtifactSweepHandler(sweeper, jobAccess)
+	sweepInterval := time.Hour
+	if v := os.Getenv("ARTIFACT_SWEEP_INTERVAL"); v != "" {
+		if sweepInterval, err = time.ParseDuration(v); err != nil || sweepInterval < 0 {
+			log.Fatalf("invalid ARTIFACT_SWEEP_INTERVAL: %q", v)
+		}
+	}
+	if sweepInterval > 0 {
+		go sweeper.Watch(context.Background(), sweepInterval)
+	}
+
+	userAuth, err := newUserAuth()
+	if err != nil {
+		log.Fatalf("user auth: %v", err)
+	}
 	r := gin.Default()
+	r.Use(userAuth.Middleware())
 	r.GET("/health", func(c *gin.Context) { c.JSON(http.StatusOK, gin.H{"status": "ok"}) })
 
 	r.POST("/orders", orderHandler.Create)
 	r.GET("/orders/:id", orderHandler.Get)
 
+	r.GET("/merchants/:id", merchantHandler.Get)
+	r.PUT("/merchants/:id", merchantHandler.Configure)
+	r.GET("/merchants/:id/payout-schedule", merchantHandler.PayoutSchedule)
+	r.GET("/merchants/:id/reserves", merchantHandler.Reserves)
+
+	r.POST("/adjustments", adjustmentHandler.Propose)
+	r.GET("/adjustments", adjustmentHandler.List)
+	r.GET("/adjustments/:id", adjustmentHandler.Get)
+	r.POST("/adjustments/:id/approve", adjustmentHandler.Approve)
+	r.POST("/adjustments/:id/reject", adjustmentHandler.Reject)
+	r.GET("/adjustments/:id/audit", adjustmentHandler.Audit)
+
+	r.GET("/ledger/merchants/:id/balance", ledgerHandler.MerchantBalance)
+	r.GET("/ledger/check", ledgerHandler.Check)
+	r.POST("/ledger/sync", ledgerHandler.Sync)
+
 	r.POST("/jobs/settlement", jobHandler.StartSettlement)
 	r.GET("/jobs/:id", jobHandler.Get)
 	r.POST("/jobs/:id/cancel", jobHandler.Cancel)
+	r.GET("/jobs/:id/artifacts/:merchant_id", jobHandler.Artifact)
+	r.POST("/jobs/:id/verify", jobHandler.Verify)
+	r.GET("/jobs/:id/results", jobHandler.Results)
+	r.POST("/jobs/reconciliation", reconciliationHandler.StartReconciliation)
+
+	r.POST("/jobs/payout", payoutHandler.StartPayout)
+	r.GET("/payouts", payoutHandler.List)
+	r.POST("/payouts/results", payoutHandler.UploadResults)
+	r.GET("/payouts/:id", payoutHandler.Get)
+	r.POST("/payouts/:id/sent", payoutHandler.MarkSent)
+	r.POST("/payouts/:id/confirm", payoutHandler.Confirm)
+	r.POST("/payouts/:id/fail", payoutHandler.Fail)
 
-	r.Static("/downloads", "./tmp/settlements")
+	r.GET("/bank-files/formats", bankFileHandler.Formats)
+	r.POST("/jobs/bank-file", bankFileHandler.StartExport)
+	r.POST("/jobs/statement", statementHandler.StartStatement)
+	r.POST("/jobs/artifact-sweep", sweepHandler.StartSweep)
+
+	r.GET("/downloads/:name", handlers.NewDownloadHandler(store, links, auditRepo).Get)
 
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -62,3 +226,77 @@ func main() {
 		log.Fatal(err)
 	}
 }
+
+// newDownloadSigner signs download links with DOWNLOAD_SIGNING_KEY, valid for DOWNLOAD_LINK_TTL
+func newDownloadSigner() (*downloads.Signer, error) {
+	ttl := 15 * time.Minute
+	if v := os.Getenv("DOWNLOAD_LINK_TTL"); v != "" {
+		var err error
+		if ttl, err = time.ParseDuration(v); err != nil || ttl <= 0 {
+			return nil, fmt.Errorf("invalid DOWNLOAD_LINK_TTL: %q", v)
+		}
+	}
+	key := []byte(os.Getenv("DOWNLOAD_SIGNING_KEY"))
+	if len(key) == 0 {
+		// links then only work on this replica until it restarts
+		log.Printf("DOWNLOAD_SIGNING_KEY not set, signing download links with a random key")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	} else if len(key) < 32 {
+		return nil, errors.New("DOWNLOAD_SIGNING_KEY must be at least 32 bytes")
+	}
+	return downloads.NewSigner(key, ttl), nil
+}
+
+// newUserAuth checks X-User-ID signatures with USER_AUTH_KEY, the key shared with the identity gateway
+func newUserAuth() (*handlers.UserAuth, error) {
+	key := []byte(os.Getenv("USER_AUTH_KEY"))
+	if len(key) == 0 {
+		// no signature then matches, so every request is anonymous and X-User-ID is refused
+		log.Printf("USER_AUTH_KEY not set, requests naming a user are refused")
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	} else if len(key) < 32 {
+		return nil, errors.New("USER_AUTH_KEY must be at least 32 bytes")
+	}
+	return handlers.NewUserAuth(key), nil
+}
+
+// newArtifactStore returns the store of job artifacts: ARTIFACT_STORE=local (the default) keeps them
+// in ARTIFACT_DIR, s3 in an S3-compatible bucket shared by every replica
+func newArtifactStore() (storage.ArtifactStore, error) {
+	switch kind := os.Getenv("ARTIFACT_STORE"); kind {
+	case "", "local":
+		dir := os.Getenv("ARTIFACT_DIR")
+		if dir == "" {
+			dir = "./tmp/settlements"
+		}
+		return storage.NewLocalStore(dir)
+	case "s3":
+		cfg := storage.S3Config{
+			Endpoint:        os.Getenv("S3_ENDPOINT"),
+			Region:          os.Getenv("S3_REGION"),
+			Bucket:          os.Getenv("S3_BUCKET"),
+			Prefix:          os.Getenv("S3_PREFIX"),
+			AccessKeyID:     os.Getenv("S3_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("S3_SECRET_ACCESS_KEY"),
+		}
+		if cfg.AccessKeyID == "" {
+			cfg.AccessKeyID, cfg.SecretAccessKey = os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+		}
+		if v := os.Getenv("S3_PART_SIZE_MB"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				return nil, fmt.Errorf("invalid S3_PART_SIZE_MB: %q", v)
+			}
+			cfg.PartSize = int64(n) << 20
+		}
+		return storage.NewS3Store(cfg)
+	default:
+		return nil, fmt.Errorf("unknown ARTIFACT_STORE %q", kind)
+	}
+}
@@ -0,0 +1,12 @@
+BEGIN;
+
+-- Merchants table holds per-merchant settlement configuration.
+-- Merchants without a row settle on UTC days.
+CREATE TABLE IF NOT EXISTS merchants (
+    id TEXT PRIMARY KEY,
+    timezone TEXT NOT NULL DEFAULT 'UTC',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMIT;
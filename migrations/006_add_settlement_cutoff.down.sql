@@ -0,0 +1,11 @@
+BEGIN;
+
+DROP FUNCTION IF EXISTS settlement_date(TIMESTAMPTZ, TEXT, TIME);
+
+ALTER TABLE settlements
+    DROP COLUMN IF EXISTS cutoff;
+
+ALTER TABLE merchants
+    DROP COLUMN IF EXISTS cutoff;
+
+COMMIT;
@@ -0,0 +1,20 @@
+BEGIN;
+
+-- Per-merchant cut-off; NULL falls back to the global SETTLEMENT_CUTOFF
+ALTER TABLE merchants
+    ADD COLUMN IF NOT EXISTS cutoff TIME;
+
+-- Cut-off that was in effect when the settlement row was computed
+ALTER TABLE settlements
+    ADD COLUMN IF NOT EXISTS cutoff TIME NOT NULL DEFAULT '00:00';
+
+-- settlement_date maps a payment to its settlement day: the merchant-local day,
+-- moved to the next day when paid at or after the cut-off time.
+CREATE OR REPLACE FUNCTION settlement_date(paid_at TIMESTAMPTZ, tz TEXT, cutoff TIME)
+RETURNS DATE
+LANGUAGE sql STABLE AS $$
+    SELECT ((paid_at AT TIME ZONE tz) - cutoff::interval)::date
+        + CASE WHEN cutoff > TIME '00:00' THEN 1 ELSE 0 END
+$$;
+
+COMMIT;
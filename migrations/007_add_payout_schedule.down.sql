@@ -0,0 +1,8 @@
+BEGIN;
+
+ALTER TABLE merchants
+    DROP COLUMN IF EXISTS payout_schedule,
+    DROP COLUMN IF EXISTS payout_lag_days,
+    DROP COLUMN IF EXISTS payout_weekday;
+
+COMMIT;
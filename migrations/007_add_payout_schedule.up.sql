@@ -0,0 +1,11 @@
+BEGIN;
+
+-- Payout schedule per merchant: DAILY (T+N business days), WEEKLY (on a weekday)
+-- or MONTHLY (N business days after month end)
+ALTER TABLE merchants
+    ADD COLUMN IF NOT EXISTS payout_schedule TEXT NOT NULL DEFAULT 'DAILY'
+        CHECK (payout_schedule IN ('DAILY', 'WEEKLY', 'MONTHLY')),
+    ADD COLUMN IF NOT EXISTS payout_lag_days INTEGER NOT NULL DEFAULT 1 CHECK (payout_lag_days >= 0),
+    ADD COLUMN IF NOT EXISTS payout_weekday SMALLINT CHECK (payout_weekday BETWEEN 0 AND 6);
+
+COMMIT;
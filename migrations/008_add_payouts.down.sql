@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_settlements_unpaid;
+ALTER TABLE settlements
+    DROP COLUMN IF EXISTS payout_id;
+
+DROP TABLE IF EXISTS payouts CASCADE;
+
+COMMIT;
@@ -0,0 +1,30 @@
+BEGIN;
+
+-- Payouts collect unpaid settlement rows of one merchant into a single bank transfer
+CREATE TABLE IF NOT EXISTS payouts (
+    id BIGSERIAL PRIMARY KEY,
+    merchant_id TEXT NOT NULL,
+    job_id TEXT NOT NULL REFERENCES jobs(id),
+    amount_cents BIGINT NOT NULL,
+    settlement_count INTEGER NOT NULL CHECK (settlement_count > 0),
+    period_start DATE NOT NULL,
+    period_end DATE NOT NULL,
+    status TEXT NOT NULL DEFAULT 'PENDING'
+        CHECK (status IN ('PENDING', 'SENT', 'CONFIRMED', 'FAILED')),
+    bank_reference TEXT,
+    failure_reason TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at TIMESTAMPTZ,
+    confirmed_at TIMESTAMPTZ,
+    failed_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS idx_payouts_merchant_id ON payouts(merchant_id);
+CREATE INDEX IF NOT EXISTS idx_payouts_status ON payouts(status);
+
+-- A settlement row belongs to at most one live payout; failed payouts release their rows
+ALTER TABLE settlements
+    ADD COLUMN IF NOT EXISTS payout_id BIGINT REFERENCES payouts(id);
+CREATE INDEX IF NOT EXISTS idx_settlements_unpaid ON settlements(merchant_id, date) WHERE payout_id IS NULL;
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Job type specific parameters, e.g. the format and merchants of a bank file export
+ALTER TABLE jobs
+    ADD COLUMN IF NOT EXISTS params JSONB;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+ALTER TABLE settlements
+    DROP COLUMN IF EXISTS adjustment_cents;
+
+DROP TABLE IF EXISTS audit_log CASCADE;
+DROP TABLE IF EXISTS adjustments CASCADE;
+
+COMMIT;
@@ -0,0 +1,41 @@
+BEGIN;
+
+-- Manual credits (positive) and debits (negative) proposed by one user and reviewed by another
+CREATE TABLE IF NOT EXISTS adjustments (
+    id BIGSERIAL PRIMARY KEY,
+    merchant_id TEXT NOT NULL,
+    amount_cents BIGINT NOT NULL CHECK (amount_cents <> 0),
+    effective_date DATE NOT NULL,
+    reason TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'PROPOSED'
+        CHECK (status IN ('PROPOSED', 'APPROVED', 'REJECTED')),
+    proposed_by TEXT NOT NULL,
+    reviewed_by TEXT,
+    review_note TEXT,
+    reviewed_at TIMESTAMPTZ,
+    settlement_run_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    CHECK (reviewed_by IS NULL OR reviewed_by <> proposed_by)
+);
+CREATE INDEX IF NOT EXISTS idx_adjustments_effective ON adjustments(effective_date) WHERE status = 'APPROVED';
+CREATE INDEX IF NOT EXISTS idx_adjustments_merchant_id ON adjustments(merchant_id);
+
+-- Append-only audit trail of workflow actions
+CREATE TABLE IF NOT EXISTS audit_log (
+    id BIGSERIAL PRIMARY KEY,
+    entity_type TEXT NOT NULL,
+    entity_id TEXT NOT NULL,
+    action TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    details JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity_type, entity_id);
+
+-- Approved adjustments are part of net; a debit can make net negative
+ALTER TABLE settlements
+    ADD COLUMN IF NOT EXISTS adjustment_cents BIGINT NOT NULL DEFAULT 0,
+    DROP CONSTRAINT IF EXISTS settlements_net_cents_check;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP TABLE IF EXISTS ledger_cursors CASCADE;
+DROP TABLE IF EXISTS postings CASCADE;
+DROP FUNCTION IF EXISTS check_journal_entry_balance();
+DROP TABLE IF EXISTS journal_entries CASCADE;
+DROP TABLE IF EXISTS ledger_accounts CASCADE;
+
+COMMIT;
@@ -0,0 +1,66 @@
+BEGIN;
+
+-- Chart of accounts; merchant payable accounts are created on first posting
+CREATE TABLE IF NOT EXISTS ledger_accounts (
+    code TEXT PRIMARY KEY,
+    type TEXT NOT NULL CHECK (type IN ('ASSET', 'LIABILITY', 'REVENUE', 'EXPENSE')),
+    merchant_id TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- One journal entry per business event; value_date is the settlement date it belongs to
+CREATE TABLE IF NOT EXISTS journal_entries (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL CHECK (kind IN ('CAPTURE', 'REFUND', 'ADJUSTMENT', 'PAYOUT')),
+    source_type TEXT NOT NULL,
+    source_id TEXT NOT NULL,
+    merchant_id TEXT NOT NULL,
+    value_date DATE NOT NULL,
+    occurred_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (source_type, source_id, kind)
+);
+CREATE INDEX IF NOT EXISTS idx_journal_entries_merchant_value_date ON journal_entries(merchant_id, value_date);
+
+-- Signed postings: debits positive, credits negative
+CREATE TABLE IF NOT EXISTS postings (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES journal_entries(id),
+    account TEXT NOT NULL REFERENCES ledger_accounts(code),
+    amount_cents BIGINT NOT NULL CHECK (amount_cents <> 0)
+);
+CREATE INDEX IF NOT EXISTS idx_postings_entry_id ON postings(entry_id);
+CREATE INDEX IF NOT EXISTS idx_postings_account ON postings(account, entry_id);
+
+-- Every journal entry must balance by the time its transaction commits
+CREATE OR REPLACE FUNCTION check_journal_entry_balance()
+RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF (SELECT SUM(amount_cents) FROM postings WHERE entry_id = NEW.entry_id) <> 0 THEN
+        RAISE EXCEPTION 'journal entry % does not balance', NEW.entry_id;
+    END IF;
+    RETURN NULL;
+END
+$$;
+
+DROP TRIGGER IF EXISTS postings_balanced ON postings;
+CREATE CONSTRAINT TRIGGER postings_balanced
+    AFTER INSERT OR UPDATE ON postings
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE FUNCTION check_journal_entry_balance();
+
+-- How far the ledger has followed the transactions feed
+CREATE TABLE IF NOT EXISTS ledger_cursors (
+    name TEXT PRIMARY KEY,
+    position BIGINT NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+INSERT INTO ledger_accounts (code, type) VALUES
+    ('cash', 'ASSET'),
+    ('fee_revenue', 'REVENUE'),
+    ('adjustments', 'EXPENSE')
+ON CONFLICT (code) DO NOTHING;
+
+COMMIT;
@@ -0,0 +1,14 @@
+BEGIN;
+
+ALTER TABLE settlements
+    DROP COLUMN IF EXISTS payable_cents,
+    DROP COLUMN IF EXISTS released_cents,
+    DROP COLUMN IF EXISTS reserved_cents;
+
+DROP TABLE IF EXISTS reserves CASCADE;
+
+ALTER TABLE merchants
+    DROP COLUMN IF EXISTS reserve_days,
+    DROP COLUMN IF EXISTS reserve_bps;
+
+COMMIT;
@@ -0,0 +1,34 @@
+BEGIN;
+
+-- Rolling reserve: hold reserve_bps basis points of each day's positive net for reserve_days days
+ALTER TABLE merchants
+    ADD COLUMN IF NOT EXISTS reserve_bps INTEGER NOT NULL DEFAULT 0 CHECK (reserve_bps BETWEEN 0 AND 10000),
+    ADD COLUMN IF NOT EXISTS reserve_days INTEGER NOT NULL DEFAULT 0 CHECK (reserve_days >= 0);
+
+-- One reserve per merchant and settlement date, released into the settlement of its release date
+CREATE TABLE IF NOT EXISTS reserves (
+    id BIGSERIAL PRIMARY KEY,
+    merchant_id TEXT NOT NULL,
+    settlement_date DATE NOT NULL,
+    amount_cents BIGINT NOT NULL CHECK (amount_cents > 0),
+    release_date DATE NOT NULL,
+    status TEXT NOT NULL DEFAULT 'HELD' CHECK (status IN ('HELD', 'RELEASED')),
+    settlement_run_id TEXT NOT NULL,
+    release_run_id TEXT,
+    released_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (merchant_id, settlement_date)
+);
+CREATE INDEX IF NOT EXISTS idx_reserves_release_date ON reserves(release_date);
+
+-- payable = net - reserved + released; payouts and bank files pay this amount
+ALTER TABLE settlements
+    ADD COLUMN IF NOT EXISTS reserved_cents BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS released_cents BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN IF NOT EXISTS payable_cents BIGINT;
+UPDATE settlements SET payable_cents = net_cents WHERE payable_cents IS NULL;
+ALTER TABLE settlements
+    ALTER COLUMN payable_cents SET DEFAULT 0,
+    ALTER COLUMN payable_cents SET NOT NULL;
+
+COMMIT;
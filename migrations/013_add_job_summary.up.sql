@@ -0,0 +1,7 @@
+BEGIN;
+
+-- Job-type specific result summary, shown on the job status endpoint
+ALTER TABLE jobs
+    ADD COLUMN IF NOT EXISTS summary JSONB;
+
+COMMIT;
@@ -0,0 +1,9 @@
+BEGIN;
+
+DROP TRIGGER IF EXISTS transactions_dirty_delete ON transactions;
+DROP TRIGGER IF EXISTS transactions_dirty_update ON transactions;
+DROP TRIGGER IF EXISTS transactions_dirty_insert ON transactions;
+DROP FUNCTION IF EXISTS mark_settlement_dirty();
+DROP TABLE IF EXISTS settlement_dirty;
+
+COMMIT;
@@ -0,0 +1,52 @@
+BEGIN;
+
+-- Merchant-days touched by transaction changes since they were last settled. day is the
+-- merchant-local day of paid_at; depending on the cut-off it settles on that day or the next.
+CREATE TABLE IF NOT EXISTS settlement_dirty (
+    merchant_id TEXT NOT NULL,
+    day DATE NOT NULL,
+    marked_at TIMESTAMPTZ NOT NULL DEFAULT clock_timestamp(),
+    PRIMARY KEY (merchant_id, day)
+);
+
+-- Statement-level so bulk loads mark each merchant-day once; old_rows/new_rows are the
+-- transition tables of the firing trigger
+CREATE OR REPLACE FUNCTION mark_settlement_dirty()
+RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        INSERT INTO settlement_dirty (merchant_id, day)
+        SELECT DISTINCT o.merchant_id, (o.paid_at AT TIME ZONE COALESCE(m.timezone, 'UTC'))::date
+        FROM old_rows o LEFT JOIN merchants m ON m.id = o.merchant_id
+        ON CONFLICT (merchant_id, day) DO UPDATE SET marked_at = clock_timestamp();
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO settlement_dirty (merchant_id, day)
+        SELECT DISTINCT n.merchant_id, (n.paid_at AT TIME ZONE COALESCE(m.timezone, 'UTC'))::date
+        FROM new_rows n LEFT JOIN merchants m ON m.id = n.merchant_id
+        ON CONFLICT (merchant_id, day) DO UPDATE SET marked_at = clock_timestamp();
+    END IF;
+    RETURN NULL;
+END
+$$;
+
+DROP TRIGGER IF EXISTS transactions_dirty_insert ON transactions;
+CREATE TRIGGER transactions_dirty_insert
+    AFTER INSERT ON transactions
+    REFERENCING NEW TABLE AS new_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION mark_settlement_dirty();
+
+DROP TRIGGER IF EXISTS transactions_dirty_update ON transactions;
+CREATE TRIGGER transactions_dirty_update
+    AFTER UPDATE ON transactions
+    REFERENCING OLD TABLE AS old_rows NEW TABLE AS new_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION mark_settlement_dirty();
+
+DROP TRIGGER IF EXISTS transactions_dirty_delete ON transactions;
+CREATE TRIGGER transactions_dirty_delete
+    AFTER DELETE ON transactions
+    REFERENCING OLD TABLE AS old_rows
+    FOR EACH STATEMENT EXECUTE FUNCTION mark_settlement_dirty();
+
+COMMIT;
@@ -0,0 +1,7 @@
+BEGIN;
+
+ALTER TABLE jobs
+    DROP COLUMN IF EXISTS snapshot,
+    DROP COLUMN IF EXISTS snapshot_at;
+
+COMMIT;
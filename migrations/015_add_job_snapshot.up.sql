@@ -0,0 +1,9 @@
+BEGIN;
+
+-- Point in time a settlement job read its data at: when its snapshot started and the
+-- transactions visible in it (xmin:xmax:xip_list)
+ALTER TABLE jobs
+    ADD COLUMN IF NOT EXISTS snapshot_at TIMESTAMPTZ,
+    ADD COLUMN IF NOT EXISTS snapshot TEXT;
+
+COMMIT;
@@ -0,0 +1,8 @@
+BEGIN;
+
+DROP INDEX IF EXISTS idx_audit_log_action;
+
+ALTER TABLE jobs
+    DROP COLUMN IF EXISTS created_by;
+
+COMMIT;
@@ -0,0 +1,10 @@
+BEGIN;
+
+-- User who started a job (X-User-ID); NULL for jobs the system queues itself. Only they and
+-- the configured job admins get download links for the job's artifacts.
+ALTER TABLE jobs
+    ADD COLUMN IF NOT EXISTS created_by TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action, created_at);
+
+COMMIT;
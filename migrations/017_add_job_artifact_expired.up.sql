@@ -0,0 +1,8 @@
+BEGIN;
+
+-- Set by the artifact sweeper once a completed job's artifacts are deleted after their retention;
+-- the job row stays, but it no longer has downloads.
+ALTER TABLE jobs
+    ADD COLUMN IF NOT EXISTS artifact_expired_at TIMESTAMPTZ;
+
+COMMIT;
@@ -0,0 +1,6 @@
+BEGIN;
+
+-- The ledger finds unposted transactions by checking the journal, not by following an id cursor
+DROP TABLE IF EXISTS ledger_cursors;
+
+COMMIT;
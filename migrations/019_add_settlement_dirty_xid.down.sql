@@ -0,0 +1,26 @@
+BEGIN;
+
+CREATE OR REPLACE FUNCTION mark_settlement_dirty()
+RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        INSERT INTO settlement_dirty (merchant_id, day)
+        SELECT DISTINCT o.merchant_id, (o.paid_at AT TIME ZONE COALESCE(m.timezone, 'UTC'))::date
+        FROM old_rows o LEFT JOIN merchants m ON m.id = o.merchant_id
+        ON CONFLICT (merchant_id, day) DO UPDATE SET marked_at = clock_timestamp();
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO settlement_dirty (merchant_id, day)
+        SELECT DISTINCT n.merchant_id, (n.paid_at AT TIME ZONE COALESCE(m.timezone, 'UTC'))::date
+        FROM new_rows n LEFT JOIN merchants m ON m.id = n.merchant_id
+        ON CONFLICT (merchant_id, day) DO UPDATE SET marked_at = clock_timestamp();
+    END IF;
+    RETURN NULL;
+END
+$$;
+
+ALTER TABLE settlement_dirty
+    DROP COLUMN IF EXISTS xid;
+
+COMMIT;
@@ -0,0 +1,29 @@
+BEGIN;
+
+-- The transaction that last marked the day. A run's recorded snapshot says whether it saw that
+-- transaction, which a clock time cannot: a change committed after the snapshot may have been
+-- marked before it. Days marked before this column existed have none and compare marked_at.
+ALTER TABLE settlement_dirty
+    ADD COLUMN IF NOT EXISTS xid XID8 DEFAULT pg_current_xact_id();
+
+CREATE OR REPLACE FUNCTION mark_settlement_dirty()
+RETURNS trigger
+LANGUAGE plpgsql AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        INSERT INTO settlement_dirty (merchant_id, day)
+        SELECT DISTINCT o.merchant_id, (o.paid_at AT TIME ZONE COALESCE(m.timezone, 'UTC'))::date
+        FROM old_rows o LEFT JOIN merchants m ON m.id = o.merchant_id
+        ON CONFLICT (merchant_id, day) DO UPDATE SET marked_at = clock_timestamp(), xid = pg_current_xact_id();
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        INSERT INTO settlement_dirty (merchant_id, day)
+        SELECT DISTINCT n.merchant_id, (n.paid_at AT TIME ZONE COALESCE(m.timezone, 'UTC'))::date
+        FROM new_rows n LEFT JOIN merchants m ON m.id = n.merchant_id
+        ON CONFLICT (merchant_id, day) DO UPDATE SET marked_at = clock_timestamp(), xid = pg_current_xact_id();
+    END IF;
+    RETURN NULL;
+END
+$$;
+
+COMMIT;
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"be/internal/manifest"
+)
+
+// runVerify implements `server verify`: it checks a job manifest's signature and artifacts and prints the
+// report as JSON. Without artifact arguments every artifact of the manifest is read from its
+// directory. It returns the process exit code: 0 valid, 1 invalid, 2 usage or read errors.
+func runVerify(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	manifestPath := fs.String("manifest", "", "job manifest (<job_id>.manifest.json)")
+	keyPath := fs.String("key", os.Getenv("MANIFEST_PUBLIC_KEY"), "Ed25519 public (or private) key PEM; the signature is not checked without one")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: server verify -manifest <job_id>.manifest.json [-key key.pem] [artifact ...]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if *manifestPath == "" {
+		fs.Usage()
+		return 2
+	}
+	m, err := manifest.Read(*manifestPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "verify:", err)
+		return 2
+	}
+	var pub ed25519.PublicKey
+	if *keyPath != "" {
+		if pub, err = manifest.LoadPublicKey(*keyPath); err != nil {
+			fmt.Fprintln(os.Stderr, "verify:", err)
+			return 2
+		}
+	}
+
+	var report *manifest.Report
+	if fs.NArg() == 0 {
+		report = manifest.VerifyDir(m, pub, filepath.Dir(*manifestPath))
+	} else {
+		report = m.NewReport(pub)
+		for _, path := range fs.Args() {
+			report.CheckFile(m, path)
+		}
+	}
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(report)
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}